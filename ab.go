@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+)
+
+// abGap is the silence between the A and B renderings
+const abGap = 500 * time.Millisecond
+
+// withFlags runs f with the flags overridden by config, a comma separated
+// list of name=value pairs like "attack=10ms,instrument=pluck", and puts
+// the previous values back afterwards. The overrides are validated like
+// the command line flags, f doesn't run when they are invalid
+func withFlags(config string, f func()) error {
+	// the values before any override, a flag set twice still gets its
+	// original back
+	previous := map[*flag.Flag]string{}
+	defer func() {
+		for fl, value := range previous {
+			fl.Value.Set(value)
+		}
+	}()
+
+	for _, pair := range strings.Split(config, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, "=", 2)
+		fl := flag.Lookup(parts[0])
+		if len(parts) != 2 || fl == nil {
+			return fmt.Errorf("invalid setting %q", pair)
+		}
+
+		if _, ok := previous[fl]; !ok {
+			previous[fl] = fl.Value.String()
+		}
+		if err := fl.Value.Set(parts[1]); err != nil {
+			return fmt.Errorf("%s: %v", parts[0], err)
+		}
+	}
+
+	if err := validateFlags(); err != nil {
+		return err
+	}
+
+	f()
+	return nil
+}
+
+// renderAB renders the song with config a, a short silence and then the
+// song again with config b. With match the B rendering is scaled to the
+// loudness of the A one, so the louder one doesn't win for being louder
+func renderAB(song *Song, a, b string, match bool) ([][]float64, error) {
+	var first, second [][]float64
+	if err := withFlags(a, func() { first = renderSong(song) }); err != nil {
+		return nil, fmt.Errorf("config A: %v", err)
+	}
+
+	if err := withFlags(b, func() { second = renderSong(song) }); err != nil {
+		return nil, fmt.Errorf("config B: %v", err)
+	}
+
+	if len(first) != len(second) {
+		return nil, fmt.Errorf("A renders %d channels and B %d, the settings can't change the channel layout", len(first), len(second))
+	}
+
+	if match {
+		loudnessA := measureLUFS(first, SampleRate)
+		loudnessB := measureLUFS(second, SampleRate)
+		fmt.Fprintf(os.Stderr, "loudness A: %.1f LUFS, B: %.1f LUFS, matching B to A\n", loudnessA, loudnessB)
+
+		if !math.IsInf(loudnessA, -1) {
+			normalizeLoudness(second, loudnessA)
+		}
+	}
+
+	gap := make([]float64, int(abGap.Seconds()*SampleRate))
+	for ch := range first {
+		first[ch] = append(append(first[ch], gap...), second[ch]...)
+	}
+
+	return first, nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"flag"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestRenderAB(t *testing.T) {
+	const score = "C4:q E4:q | G3:h"
+	tests := []struct {
+		a, b string
+	}{
+		{"attack=10ms", "attack=100ms,release=200ms"},
+		{"", "instrument=pluck"},
+		{"channel-count=2", "channel-count=2,stereo-detune=10"},
+		{"decay=50ms,sustain=0.5", "drive=4"},
+	}
+
+	song, err := ParseSong(score, 120)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		got, err := renderAB(song, tt.a, tt.b, false)
+		if err != nil {
+			t.Fatalf("a %q, b %q: %v", tt.a, tt.b, err)
+		}
+
+		// the same notes rendered with each setting, a gap apart
+		a, b := renderWith(t, tt.a, score), renderWith(t, tt.b, score)
+		gap := int(abGap.Seconds() * SampleRate)
+		for ch := range got {
+			want := append(append(append([]float64(nil), a[ch]...), make([]float64, gap)...), b[ch]...)
+			if len(got[ch]) != len(want) {
+				t.Fatalf("a %q, b %q: channel %d has %d samples, want %d", tt.a, tt.b, ch, len(got[ch]), len(want))
+			}
+			for i := range want {
+				if got[ch][i] != want[i] {
+					t.Fatalf("a %q, b %q: channel %d sample %d is %v, want %v", tt.a, tt.b, ch, i, got[ch][i], want[i])
+				}
+			}
+		}
+
+		// and the settings make a difference
+		same := len(a[0]) == len(b[0])
+		for i := 0; same && i < len(a[0]); i++ {
+			same = a[0][i] == b[0][i]
+		}
+		if same {
+			t.Errorf("a %q, b %q: both halves are the same", tt.a, tt.b)
+		}
+	}
+}
+
+func TestRenderABErrors(t *testing.T) {
+	song, err := ParseSong("C4:q", 120)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		a, b, want string
+	}{
+		{"attack=10ms", "channel-count=2", "can't change the channel layout"},
+		{"nosuchflag=1", "", "config A: invalid setting"},
+		{"", "attack", "config B: invalid setting"},
+		{"", "bpm=0", "config B: invalid tempo"},
+		{"channel-count=3", "", "config A"},
+	}
+
+	for _, tt := range tests {
+		_, err := renderAB(song, tt.a, tt.b, false)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("a %q, b %q: %v, want an error with %q", tt.a, tt.b, err, tt.want)
+		}
+	}
+
+	// the overrides don't outlive the rendering
+	if *attack != 0 || *channelCount != 1 || *bpm != 120 {
+		t.Errorf("the flags keep the overrides: attack %v, channel count %d, bpm %d", *attack, *channelCount, *bpm)
+	}
+}
+
+func TestWithFlagsRestores(t *testing.T) {
+	tests := []struct {
+		config string
+		names  []string
+	}{
+		{"attack=10ms,attack=20ms", []string{"attack"}},
+		{"bpm=90,channel-count=2,bpm=60,bpm=30", []string{"bpm", "channel-count"}},
+		// invalid once set, and failing to set halfway through
+		{"bpm=90,bpm=0", []string{"bpm"}},
+		{"attack=10ms,bpm=90,attack=x", []string{"attack", "bpm"}},
+	}
+
+	for _, tt := range tests {
+		before := map[string]string{}
+		for _, name := range tt.names {
+			before[name] = flag.Lookup(name).Value.String()
+		}
+
+		withFlags(tt.config, func() {})
+		for name, want := range before {
+			if got := flag.Lookup(name).Value.String(); got != want {
+				t.Errorf("%q: -%s is left at %s, want %s", tt.config, name, got, want)
+			}
+		}
+	}
+}
+
+func TestRenderABMatchesLoudness(t *testing.T) {
+	song, err := ParseSong("C4:q E4:q", 120)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := renderAB(song, "", "lufs=-30", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	half := len(renderWith(t, "", "C4:q E4:q")[0])
+	gap := int(abGap.Seconds() * SampleRate)
+	a := measureLUFS([][]float64{got[0][:half]}, SampleRate)
+	b := measureLUFS([][]float64{got[0][half+gap:]}, SampleRate)
+	if d := a - b; d < -0.01 || d > 0.01 {
+		t.Errorf("A at %.2f LUFS and B at %.2f LUFS", a, b)
+	}
+}
+
+func TestLoudnessMatch(t *testing.T) {
+	const score = "C4:q E4:q G4:h"
+	song, err := ParseSong(score, 120)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		a, b string
+		// apart is how far apart in LUFS they are left without matching,
+		// below 0 when it isn't known
+		apart float64
+	}{
+		{"lufs=-14", "lufs=-30", 16},
+		{"lufs=-40", "lufs=-20", 20},
+		{"sustain=0.25", "", 12},
+		{"", "instrument=pluck", -1},
+	}
+
+	half := len(renderWith(t, "", score)[0])
+	gap := int(abGap.Seconds() * SampleRate)
+	for _, tt := range tests {
+		for _, match := range []bool{false, true} {
+			got, err := renderAB(song, tt.a, tt.b, match)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			a := measureLUFS([][]float64{got[0][:half]}, SampleRate)
+			b := measureLUFS([][]float64{got[0][half+gap:]}, SampleRate)
+			want := 0.0
+			if !match {
+				if tt.apart < 0 {
+					continue
+				}
+				want = tt.apart
+			}
+			if d := math.Abs(a - b); math.Abs(d-want) > 0.1 {
+				t.Errorf("A %q, B %q, match %v: A at %.2f LUFS and B at %.2f LUFS, want %g apart", tt.a, tt.b, match, a, b, want)
+			}
+		}
+	}
+}
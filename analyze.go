@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+)
+
+// maxAnalysisSamples bounds the FFT size used to find the fundamental
+const maxAnalysisSamples = 1 << 16
+
+// AnalysisResult holds the basic stats of a mono signal
+type AnalysisResult struct {
+	PeakDBFS    float64
+	RMSDBFS     float64
+	DCOffset    float64
+	Fundamental float64
+	Clipped     int
+}
+
+// analyze measures levels, DC offset, clipping and the fundamental
+// frequency of the samples
+func analyze(samples []float64, sampleRate int) AnalysisResult {
+	var result AnalysisResult
+	if len(samples) == 0 {
+		result.PeakDBFS, result.RMSDBFS = math.Inf(-1), math.Inf(-1)
+		return result
+	}
+
+	peak, sum, squares := 0.0, 0.0, 0.0
+	for _, s := range samples {
+		peak = math.Max(peak, math.Abs(s))
+		sum += s
+		squares += s * s
+		if math.Abs(s) >= 1 {
+			result.Clipped++
+		}
+	}
+
+	result.PeakDBFS = 20 * math.Log10(peak)
+	result.RMSDBFS = 20 * math.Log10(math.Sqrt(squares/float64(len(samples))))
+	result.DCOffset = sum / float64(len(samples))
+
+	window := samples
+	if len(window) > maxAnalysisSamples {
+		window = window[:maxAnalysisSamples]
+	}
+
+	magnitudes := spectrum(window)
+	best := 1
+	for i := 1; i < len(magnitudes); i++ {
+		if magnitudes[i] > magnitudes[best] {
+			best = i
+		}
+	}
+
+	// parabolic interpolation around the peak for sub-bin accuracy
+	bin := float64(best)
+	if best > 0 && best < len(magnitudes)-1 {
+		a, b, c := magnitudes[best-1], magnitudes[best], magnitudes[best+1]
+		if d := a - 2*b + c; d != 0 {
+			bin += 0.5 * (a - c) / d
+		}
+	}
+
+	if len(magnitudes) > 1 {
+		result.Fundamental = binFrequency(bin, len(magnitudes), sampleRate)
+	}
+
+	return result
+}
+
+// printAnalysis writes the result in a human readable form
+func printAnalysis(w io.Writer, result AnalysisResult) {
+	fmt.Fprintf(w, "peak:        %.2f dBFS\n", result.PeakDBFS)
+	fmt.Fprintf(w, "rms:         %.2f dBFS\n", result.RMSDBFS)
+	fmt.Fprintf(w, "dc offset:   %.6f\n", result.DCOffset)
+	fmt.Fprintf(w, "fundamental: %.2f Hz\n", result.Fundamental)
+	fmt.Fprintf(w, "clipped:     %d samples\n", result.Clipped)
+}
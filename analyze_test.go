@@ -0,0 +1,64 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestAnalyze(t *testing.T) {
+	offset := tone(440, 0.5, 1)
+	for i := range offset {
+		offset[i] += 0.1
+	}
+	clipped := tone(440, 1.5, 1)
+	for i := range clipped {
+		clipped[i] = math.Max(-1, math.Min(1, clipped[i]))
+	}
+
+	tests := []struct {
+		name    string
+		samples []float64
+		want    AnalysisResult
+		clipped bool
+	}{
+		// a sine's RMS is 3dB under its peak
+		{"full scale 440Hz", tone(440, 1, 1), AnalysisResult{PeakDBFS: 0, RMSDBFS: -3.01, Fundamental: 440}, false},
+		{"-6dB 1kHz", tone(1000, 0.5, 1), AnalysisResult{PeakDBFS: -6.02, RMSDBFS: -9.03, Fundamental: 1000}, false},
+		{"DC offset", offset, AnalysisResult{PeakDBFS: -4.44, RMSDBFS: -8.70, DCOffset: 0.1, Fundamental: 440}, false},
+		{"clipped", clipped, AnalysisResult{PeakDBFS: 0, Fundamental: 440}, true},
+	}
+
+	for _, tt := range tests {
+		got := analyze(tt.samples, SampleRate)
+		if math.Abs(got.Fundamental-tt.want.Fundamental) > 1 {
+			t.Errorf("%s: fundamental %.2fHz, want %gHz", tt.name, got.Fundamental, tt.want.Fundamental)
+		}
+		if math.Abs(got.PeakDBFS-tt.want.PeakDBFS) > 0.05 {
+			t.Errorf("%s: peak %.2f dBFS, want %.2f", tt.name, got.PeakDBFS, tt.want.PeakDBFS)
+		}
+		if tt.clipped {
+			if got.Clipped == 0 {
+				t.Errorf("%s: no clipped samples", tt.name)
+			}
+			continue
+		}
+		if math.Abs(got.RMSDBFS-tt.want.RMSDBFS) > 0.05 {
+			t.Errorf("%s: rms %.2f dBFS, want %.2f", tt.name, got.RMSDBFS, tt.want.RMSDBFS)
+		}
+		if math.Abs(got.DCOffset-tt.want.DCOffset) > 1e-3 {
+			t.Errorf("%s: DC offset %.4f, want %g", tt.name, got.DCOffset, tt.want.DCOffset)
+		}
+		if got.Clipped != 0 {
+			t.Errorf("%s: %d clipped samples, want none", tt.name, got.Clipped)
+		}
+	}
+}
+
+func TestAnalyzeSilence(t *testing.T) {
+	for _, samples := range [][]float64{nil, make([]float64, 1000)} {
+		got := analyze(samples, SampleRate)
+		if !math.IsInf(got.PeakDBFS, -1) || !math.IsInf(got.RMSDBFS, -1) {
+			t.Errorf("%d silent samples: peak %v, rms %v, want -Inf", len(samples), got.PeakDBFS, got.RMSDBFS)
+		}
+	}
+}
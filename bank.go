@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/tecnologer/SoundOfCode/synth"
+)
+
+// Preset is a patch of a preset bank: the layers of the instrument and
+// the settings it wants, by flag name, like its effects
+type Preset struct {
+	Layers   []PresetLayer     `json:"layers"`
+	Settings map[string]string `json:"settings"`
+}
+
+// PresetLayer is a Layer as written in a bank, times being durations like
+// "250ms" and level and ratio defaulting to 1
+type PresetLayer struct {
+	Attack        bankDuration `json:"attack"`
+	Hold          bankDuration `json:"hold"`
+	Decay         bankDuration `json:"decay"`
+	Sustain       float64      `json:"sustain"`
+	Release       bankDuration `json:"release"`
+	Level         *float64     `json:"level"`
+	Ratio         *float64     `json:"ratio"`
+	Harmonics     []float64    `json:"harmonics"`
+	HarmonicDecay float64      `json:"harmonic_decay"`
+	// Partials replace Harmonics with an explicit spectrum, e.g.
+	// [{"ratio": 1, "amplitude": 1}, {"ratio": 2, "amplitude": 0.5, "decay": 3}]
+	Partials []synth.Partial `json:"partials"`
+}
+
+// bankDuration is a time.Duration read from a string like "10ms"
+type bankDuration time.Duration
+
+func (d *bankDuration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("durations are strings like \"10ms\": %v", err)
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = bankDuration(v)
+	return nil
+}
+
+// LoadPresetBank reads a JSON bank of named presets, e.g.
+//
+//	{"lead": {"layers": [{"attack": "5ms", "sustain": 0.8, "release": "200ms",
+//	  "harmonics": [1, 0.5, 0.25]}], "settings": {"delay": "150ms"}}}
+func LoadPresetBank(path string) (map[string]Preset, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var bank map[string]Preset
+	if err := json.Unmarshal(data, &bank); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	for name, p := range bank {
+		if len(p.Layers) == 0 {
+			return nil, fmt.Errorf("%s: preset %q has no layers", path, name)
+		}
+		for setting := range p.Settings {
+			if flag.Lookup(setting) == nil {
+				return nil, fmt.Errorf("%s: preset %q has an unknown setting %q", path, name, setting)
+			}
+		}
+	}
+
+	return bank, nil
+}
+
+// Instrument builds the instrument of the preset
+func (p Preset) Instrument() Instrument {
+	in := make(Instrument, len(p.Layers))
+	for i, l := range p.Layers {
+		in[i] = Layer{
+			Envelope: synth.Envelope{
+				Attack:  time.Duration(l.Attack),
+				Hold:    time.Duration(l.Hold),
+				Decay:   time.Duration(l.Decay),
+				Sustain: l.Sustain,
+				Release: time.Duration(l.Release),
+			},
+			Level:         1,
+			Ratio:         1,
+			Harmonics:     l.Harmonics,
+			Partials:      l.Partials,
+			HarmonicDecay: l.HarmonicDecay,
+		}
+		if l.Level != nil {
+			in[i].Level = *l.Level
+		}
+		if l.Ratio != nil {
+			in[i].Ratio = *l.Ratio
+		}
+	}
+	return in
+}
+
+// apply sets the flags of the preset settings, except the ones given on
+// the command line, which win, and checks the flags they leave
+func (p Preset) apply() error {
+	explicit := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	for name, value := range p.Settings {
+		if explicit[name] {
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("preset setting %s: %v", name, err)
+		}
+	}
+	return validateFlags()
+}
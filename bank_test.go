@@ -0,0 +1,149 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tecnologer/SoundOfCode/synth"
+)
+
+const twoPatchBank = `{
+	"lead": {
+		"layers": [
+			{"attack": "5ms", "decay": "100ms", "sustain": 0.8, "release": "200ms", "harmonics": [1, 0.5, 0.25], "harmonic_decay": 2},
+			{"release": "50ms", "level": 0.3, "ratio": 2}
+		],
+		"settings": {"delay": "150ms", "delay-mix": "0.4"}
+	},
+	"chime": {
+		"layers": [
+			{"hold": "10ms", "decay": "2s", "partials": [{"ratio": 1, "amplitude": 1}, {"ratio": 2.76, "amplitude": 0.5, "decay": 3}]}
+		]
+	}
+}`
+
+// writeBank writes the bank to a file in a temporary directory
+func writeBank(t *testing.T, bank string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "bank.json")
+	if err := os.WriteFile(path, []byte(bank), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPresetBank(t *testing.T) {
+	bank, err := LoadPresetBank(writeBank(t, twoPatchBank))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bank) != 2 {
+		t.Fatalf("loaded %d presets, want 2", len(bank))
+	}
+
+	tests := []struct {
+		name string
+		want Instrument
+	}{
+		{"lead", Instrument{
+			{
+				Envelope:      synth.Envelope{Attack: 5 * time.Millisecond, Decay: 100 * time.Millisecond, Sustain: 0.8, Release: 200 * time.Millisecond},
+				Level:         1,
+				Ratio:         1,
+				Harmonics:     []float64{1, 0.5, 0.25},
+				HarmonicDecay: 2,
+			},
+			{Envelope: synth.Envelope{Release: 50 * time.Millisecond}, Level: 0.3, Ratio: 2},
+		}},
+		{"chime", Instrument{
+			{
+				Envelope: synth.Envelope{Hold: 10 * time.Millisecond, Decay: 2 * time.Second},
+				Level:    1,
+				Ratio:    1,
+				Partials: []synth.Partial{{Ratio: 1, Amplitude: 1}, {Ratio: 2.76, Amplitude: 0.5, Decay: 3}},
+			},
+		}},
+	}
+
+	for _, tt := range tests {
+		if got := bank[tt.name].Instrument(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: instrument %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPresetApply(t *testing.T) {
+	bank, err := LoadPresetBank(writeBank(t, twoPatchBank))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"delay", "delay-mix"} {
+		fl := flag.Lookup(name)
+		previous := fl.Value.String()
+		t.Cleanup(func() { fl.Value.Set(previous) })
+	}
+
+	// a flag given on the command line wins over the preset
+	setFlag(t, "delay-mix", "0.1")
+	if err := bank["lead"].apply(); err != nil {
+		t.Fatal(err)
+	}
+	if *delayTime != 150*time.Millisecond {
+		t.Errorf("-delay is %v, want the preset's 150ms", *delayTime)
+	}
+	if *delayMix != 0.1 {
+		t.Errorf("-delay-mix is %v, want the command line's 0.1", *delayMix)
+	}
+}
+
+func TestLoadPresetBankErrors(t *testing.T) {
+	tests := []struct {
+		bank string
+		err  string
+	}{
+		{`{"pad": {"layers": []}}`, "no layers"},
+		{`{"pad": {"layers": [{}], "settings": {"loudness": "3"}}}`, "unknown setting"},
+		{`{"pad": {"layers": [{"attack": 5}]}}`, "durations are strings"},
+		{`{"pad": {"layers": [{"attack": "5 ms"}]}}`, "duration"},
+		{`{"pad": `, "unexpected end"},
+	}
+
+	for _, tt := range tests {
+		_, err := LoadPresetBank(writeBank(t, tt.bank))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: error %v, want one about %q", tt.bank, err, tt.err)
+		}
+	}
+
+	if _, err := LoadPresetBank(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("loading a missing bank should fail")
+	}
+}
+
+func TestPresetApplyRejectsBadSettings(t *testing.T) {
+	tests := []struct {
+		setting, value string
+	}{
+		{"channel-count", "3"},
+		{"articulation", "0"},
+		{"bpm", "-1"},
+		{"wave", "organ"},
+	}
+
+	for _, tt := range tests {
+		fl := flag.Lookup(tt.setting)
+		previous := fl.Value.String()
+
+		p := Preset{Settings: map[string]string{tt.setting: tt.value}}
+		if err := p.apply(); err == nil {
+			t.Errorf("a preset with %s %s should be rejected", tt.setting, tt.value)
+		}
+		fl.Value.Set(previous)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// loadSongFile reads a song from a MusicXML file (.xml or .musicxml) or
+// a text file holding a -score (.txt or .score)
+func loadSongFile(path string, bpm int) (*Song, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".xml", ".musicxml":
+		return parseMusicXML(f)
+	case ".txt", ".score":
+		text, err := io.ReadAll(f)
+		if err != nil {
+			return nil, err
+		}
+		return ParseSong(strings.TrimSpace(string(text)), bpm)
+	default:
+		return nil, fmt.Errorf("unknown song format %q", filepath.Ext(path))
+	}
+}
+
+// batchRender renders every song file in dir to a WAV of the same name in
+// outDir. A file that fails is reported to log and skipped, the number
+// of failures is returned
+func batchRender(dir, outDir, format, clip string, log io.Writer) (failed int, err error) {
+	bits, ok := sampleFormats[format]
+	if !ok {
+		return 0, fmt.Errorf("unknown sample format %q", format)
+	}
+	if !clipModes[clip] {
+		return 0, fmt.Errorf("unknown clip mode %q", clip)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return 0, err
+	}
+
+	for _, entry := range entries {
+		switch strings.ToLower(filepath.Ext(entry.Name())) {
+		case ".xml", ".musicxml", ".txt", ".score":
+		default:
+			continue
+		}
+
+		name := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name())) + ".wav"
+		if err := renderFile(filepath.Join(dir, entry.Name()), filepath.Join(outDir, name), bits, clip); err != nil {
+			fmt.Fprintf(log, "%s: %v\n", entry.Name(), err)
+			failed++
+			continue
+		}
+
+		fmt.Fprintf(log, "%s: ok\n", entry.Name())
+	}
+
+	return failed, nil
+}
+
+// renderFile renders one song file to a WAV file
+func renderFile(in, out string, bits int, clip string) error {
+	song, err := loadSongFile(in, *bpm)
+	if err != nil {
+		return err
+	}
+
+	if len(song.Tracks) == 0 {
+		return errors.New("no notes")
+	}
+
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+
+	channels := renderSong(song)
+	err = writeWAV(f, interleave(channels), len(channels), SampleRate, bits, clip)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
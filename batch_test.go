@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBatchRender(t *testing.T) {
+	dir, out := t.TempDir(), filepath.Join(t.TempDir(), "rendered")
+	files := map[string]string{
+		"one.score":    "C4:q E4:q G4:h",
+		"two.musicxml": minimalScore,
+		"broken.txt":   "C4:q X9:q",
+		"empty.score":  "",
+		"notes.md":     "not a song",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var log bytes.Buffer
+	failed, err := batchRender(dir, out, "s16", "clamp", &log)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the broken files are reported and the rest rendered anyway
+	if failed != 2 {
+		t.Errorf("%d failures, want 2", failed)
+	}
+	for _, line := range []string{"one.score: ok", "two.musicxml: ok", "broken.txt: ", "empty.score: no notes"} {
+		if !strings.Contains(log.String(), line) {
+			t.Errorf("the log has no %q:\n%s", line, log.String())
+		}
+	}
+	if strings.Contains(log.String(), "notes.md") {
+		t.Errorf("notes.md isn't a song file, it was tried:\n%s", log.String())
+	}
+
+	rendered, err := os.ReadDir(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range rendered {
+		names = append(names, entry.Name())
+	}
+	if strings.Join(names, " ") != "one.wav two.wav" {
+		t.Errorf("rendered %v, want one.wav and two.wav", names)
+	}
+
+	for _, name := range names {
+		f, err := os.Open(filepath.Join(out, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		h, err := readWAVHeader(f)
+		f.Close()
+		if err != nil || h.BitsPerSample != 16 || h.Frames() == 0 {
+			t.Errorf("%s: header %+v, %v", name, h, err)
+		}
+	}
+}
+
+func TestBatchRenderErrors(t *testing.T) {
+	var log bytes.Buffer
+	if _, err := batchRender(t.TempDir(), t.TempDir(), "s12", "clamp", &log); err == nil {
+		t.Error("an unknown sample format should fail")
+	}
+	if _, err := batchRender(filepath.Join(t.TempDir(), "missing"), t.TempDir(), "s16", "clamp", &log); err == nil {
+		t.Error("a missing directory should fail")
+	}
+}
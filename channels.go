@@ -0,0 +1,184 @@
+package main
+
+import "math"
+
+// interleave merges one buffer per channel into interleaved frames. All
+// the buffers must have the same length
+func interleave(channels [][]float64) []float64 {
+	if len(channels) == 1 {
+		return channels[0]
+	}
+
+	n := len(channels)
+	out := make([]float64, len(channels[0])*n)
+	for ch, samples := range channels {
+		for i, s := range samples {
+			out[i*n+ch] = s
+		}
+	}
+
+	return out
+}
+
+// channelLayout is a speaker arrangement. A mono source is played on the
+// Sources channels, the rest stay silent
+type channelLayout struct {
+	Name    string
+	Sources []int
+}
+
+// channelLayouts are the supported layouts by channel count. Quad is
+// front left, front right, rear left, rear right, 5.1 is front left,
+// front right, center, LFE, rear left, rear right
+var channelLayouts = map[int]channelLayout{
+	1: {Name: "mono", Sources: []int{0}},
+	2: {Name: "stereo", Sources: []int{0, 1}},
+	4: {Name: "quad", Sources: []int{0, 1}},
+	6: {Name: "5.1", Sources: []int{2}},
+}
+
+// place puts one buffer per source channel of the layout into a buffer
+// per output channel, the other channels getting silence
+func (l channelLayout) place(sources [][]float64, channels int) [][]float64 {
+	out := make([][]float64, channels)
+	for i, ch := range l.Sources {
+		out[ch] = sources[i]
+	}
+
+	for ch := range out {
+		if out[ch] == nil {
+			out[ch] = make([]float64, len(sources[0]))
+		}
+	}
+	return out
+}
+
+// sources picks the source channels of the layout out of a buffer per
+// output channel, leaving out the ones it keeps silent
+func (l channelLayout) sources(channels [][]float64) [][]float64 {
+	sources := make([][]float64, len(l.Sources))
+	for i, ch := range l.Sources {
+		sources[i] = channels[ch]
+	}
+	return sources
+}
+
+// duplicate returns n independent copies of the mono samples
+func duplicate(samples []float64, n int) [][]float64 {
+	channels := make([][]float64, n)
+	channels[0] = samples
+	for ch := 1; ch < n; ch++ {
+		channels[ch] = append([]float64(nil), samples...)
+	}
+	return channels
+}
+
+// Effect processes a signal one sample at a time. Reset clears its state
+// so it starts over as if just created
+type Effect interface {
+	Process(sample float64) float64
+	Reset()
+}
+
+// panStereo places a stereo pair at pan, from -1 (left) to 1 (right),
+// with constant power gains like AutoPan
+func panStereo(channels [][]float64, pan float64) {
+	angle := (pan + 1) * π / 4
+	left, right := math.Cos(angle), math.Sin(angle)
+	for i := range channels[0] {
+		channels[0][i] *= left
+		channels[1][i] *= right
+	}
+}
+
+// silenceBounds returns the audible region of the samples, from the first
+// to right after the last one above threshold, widened by pad samples on
+// each side. Silence all over gives an empty region
+func silenceBounds(samples []float64, threshold float64, pad int) (from, to int) {
+	from, to = len(samples), 0
+	for i, s := range samples {
+		if math.Abs(s) > threshold {
+			if i < from {
+				from = i
+			}
+			to = i + 1
+		}
+	}
+
+	if to == 0 {
+		return 0, 0
+	}
+	if from -= pad; from < 0 {
+		from = 0
+	}
+	if to += pad; to > len(samples) {
+		to = len(samples)
+	}
+	return from, to
+}
+
+// trimSilence cuts the samples down to their audible region, see
+// silenceBounds
+func trimSilence(samples []float64, threshold float64, pad int) []float64 {
+	from, to := silenceBounds(samples, threshold, pad)
+	return samples[from:to]
+}
+
+// trimChannels cuts all the channels to the region audible in any of them
+// so they stay aligned, returning how many samples were cut at the start
+func trimChannels(channels [][]float64, threshold float64, pad int) (trimmed [][]float64, cut int) {
+	from, to := len(channels[0]), 0
+	for _, samples := range channels {
+		if f, t := silenceBounds(samples, threshold, pad); t > f {
+			if f < from {
+				from = f
+			}
+			if t > to {
+				to = t
+			}
+		}
+	}
+
+	if to == 0 {
+		from = 0
+	}
+	trimmed = make([][]float64, len(channels))
+	for ch, samples := range channels {
+		trimmed[ch] = samples[from:to]
+	}
+	return trimmed, from
+}
+
+// monoSum folds the channels into one, each at 1/sqrt(channels) gain
+// (-3dB for stereo) so correlated material keeps its level
+func monoSum(channels [][]float64) []float64 {
+	gain := 1 / math.Sqrt(float64(len(channels)))
+	mono := make([]float64, len(channels[0]))
+	for _, samples := range channels {
+		for i, s := range samples {
+			mono[i] += s * gain
+		}
+	}
+	return mono
+}
+
+// monoCompatibility is the energy of the mono sum over the energy of the
+// channels: 1 when they are identical, 0 when they cancel out
+func monoCompatibility(channels [][]float64) float64 {
+	total := 0.0
+	for _, samples := range channels {
+		for _, s := range samples {
+			total += s * s
+		}
+	}
+
+	if total == 0 {
+		return 1
+	}
+
+	sum := 0.0
+	for _, s := range monoSum(channels) {
+		sum += s * s
+	}
+	return sum / total
+}
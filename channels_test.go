@@ -0,0 +1,265 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestInterleave(t *testing.T) {
+	tests := []struct {
+		channels [][]float64
+		want     []float64
+	}{
+		{[][]float64{{1, 2, 3}}, []float64{1, 2, 3}},
+		{[][]float64{{1, 2}, {-1, -2}}, []float64{1, -1, 2, -2}},
+		{[][]float64{{1}, {2}, {3}, {4}}, []float64{1, 2, 3, 4}},
+	}
+
+	for _, tt := range tests {
+		if got := interleave(tt.channels); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("interleave(%v) = %v, want %v", tt.channels, got, tt.want)
+		}
+	}
+}
+
+func TestStereoDetune(t *testing.T) {
+	tests := []struct {
+		config string
+		differ bool
+	}{
+		{"channel-count=2", false},
+		{"channel-count=2,stereo-detune=0", false},
+		{"channel-count=2,stereo-detune=10", true},
+		{"channel-count=2,stereo-detune=1,instrument=pluck", true},
+	}
+
+	for _, tt := range tests {
+		channels := renderWith(t, tt.config, "C4:q E4:q G4:h")
+		left, right := channels[0], channels[1]
+
+		same := 0
+		for i := range left {
+			if left[i] == right[i] {
+				same++
+			}
+		}
+
+		if !tt.differ && same != len(left) {
+			t.Errorf("%s: %d of %d samples differ between left and right", tt.config, len(left)-same, len(left))
+		}
+		// apart from where both are silent
+		if tt.differ && same > len(left)/100 {
+			t.Errorf("%s: %d of %d samples are the same on both sides", tt.config, same, len(left))
+		}
+	}
+}
+
+func TestMonoSum(t *testing.T) {
+	tests := []struct {
+		channels [][]float64
+		want     []float64
+	}{
+		{[][]float64{{1, -0.5}}, []float64{1, -0.5}},
+		// -3dB per channel: identical channels come out 3dB up
+		{[][]float64{{0.5, 0.5}, {0.5, -0.5}}, []float64{math.Sqrt2 / 2, 0}},
+		{[][]float64{{1}, {1}, {1}, {1}}, []float64{2}},
+	}
+
+	for _, tt := range tests {
+		got := monoSum(tt.channels)
+		for i := range tt.want {
+			if math.Abs(got[i]-tt.want[i]) > 1e-12 {
+				t.Errorf("monoSum(%v) = %v, want %v", tt.channels, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestMonoCompatibility(t *testing.T) {
+	left := noise(10000, 5)
+	inverted := make([]float64, len(left))
+	for i, s := range left {
+		inverted[i] = -s
+	}
+
+	tests := []struct {
+		name     string
+		channels [][]float64
+		want     float64
+	}{
+		{"identical", [][]float64{left, left}, 1},
+		{"inverted", [][]float64{left, inverted}, 0},
+		{"uncorrelated", [][]float64{left, noise(10000, 6)}, 0.5},
+		{"one side only", [][]float64{left, make([]float64, len(left))}, 0.5},
+		{"silence", [][]float64{make([]float64, 10), make([]float64, 10)}, 1},
+	}
+
+	for _, tt := range tests {
+		if got := monoCompatibility(tt.channels); math.Abs(got-tt.want) > 0.02 {
+			t.Errorf("%s: %.3f, want %g", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMonoCompatibleRender(t *testing.T) {
+	const score = "C4:q E4:q"
+	mono := renderWith(t, "", score)[0]
+
+	tests := []struct {
+		config string
+		gain   float64
+	}{
+		// stereo carries the note on both sides, 5.1 on the center only
+		{"channel-count=2,mono-compatible=true", math.Sqrt2},
+		{"channel-count=4,mono-compatible=true", math.Sqrt2},
+		{"channel-count=6,mono-compatible=true", 1},
+	}
+
+	for _, tt := range tests {
+		got := renderWith(t, tt.config, score)
+		if len(got) != 1 {
+			t.Fatalf("%s: %d channels, want 1", tt.config, len(got))
+		}
+		for i := range mono {
+			if math.Abs(got[0][i]-tt.gain*mono[i]) > 1e-12 {
+				t.Fatalf("%s: sample %d is %v, want %v", tt.config, i, got[0][i], tt.gain*mono[i])
+			}
+		}
+	}
+}
+
+func TestChannelLayoutPlacement(t *testing.T) {
+	const score = "A4:q"
+	mono := renderWith(t, "", score)[0]
+
+	tests := []struct {
+		config string
+		// sounding are the channels carrying the note
+		sounding []bool
+	}{
+		{"channel-count=2", []bool{true, true}},
+		{"channel-count=4", []bool{true, true, false, false}},
+		{"channel-count=6", []bool{false, false, true, false, false, false}},
+	}
+
+	for _, tt := range tests {
+		channels := renderWith(t, tt.config, score)
+		if len(channels) != len(tt.sounding) {
+			t.Fatalf("%s: %d channels, want %d", tt.config, len(channels), len(tt.sounding))
+		}
+
+		for ch, sounding := range tt.sounding {
+			for i, s := range channels[ch] {
+				want := 0.0
+				if sounding {
+					want = mono[i]
+				}
+				if s != want {
+					t.Fatalf("%s: channel %d sample %d is %v, want %v", tt.config, ch, i, s, want)
+				}
+			}
+		}
+	}
+
+	if err := withFlags("channel-count=3", func() {}); err == nil {
+		t.Error("3 channels should be rejected, there is no layout for them")
+	}
+}
+
+func TestChannelLayoutSources(t *testing.T) {
+	channels := [][]float64{{1}, {2}, {3}, {4}, {5}, {6}}
+	tests := []struct {
+		count int
+		want  [][]float64
+	}{
+		{2, [][]float64{{1}, {2}}},
+		{4, [][]float64{{1}, {2}}},
+		{6, [][]float64{{3}}},
+	}
+
+	for _, tt := range tests {
+		if got := channelLayouts[tt.count].sources(channels[:tt.count]); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s sources: %v, want %v", channelLayouts[tt.count].Name, got, tt.want)
+		}
+	}
+}
+
+// padded is the audible samples with lead samples of silence before and
+// trail after
+func padded(audible []float64, lead, trail int) []float64 {
+	samples := make([]float64, lead, lead+len(audible)+trail)
+	samples = append(samples, audible...)
+	return append(samples, make([]float64, trail)...)
+}
+
+func TestSilenceBounds(t *testing.T) {
+	// quiet edges under the threshold around a loud middle
+	audible := []float64{0.0005, 0.3, -0.8, 0, 0.5, -0.002, 0.0001}
+
+	tests := []struct {
+		name      string
+		samples   []float64
+		threshold float64
+		pad       int
+		from, to  int
+	}{
+		{"padded", padded(audible, 1000, 500), 0.001, 0, 1001, 1006},
+		{"with a pad", padded(audible, 1000, 500), 0.001, 10, 991, 1016},
+		{"pad past the ends", padded(audible, 5, 3), 0.001, 10, 0, 15},
+		{"lower threshold", padded(audible, 1000, 500), 0.0003, 0, 1000, 1006},
+		{"no silence", []float64{0.5, 0.1, -0.5}, 0.001, 0, 0, 3},
+		{"silent", make([]float64, 100), 0.001, 10, 0, 0},
+		{"empty", nil, 0.001, 10, 0, 0},
+	}
+
+	for _, tt := range tests {
+		from, to := silenceBounds(tt.samples, tt.threshold, tt.pad)
+		if from != tt.from || to != tt.to {
+			t.Errorf("%s: bounds %d to %d, want %d to %d", tt.name, from, to, tt.from, tt.to)
+		}
+
+		trimmed := trimSilence(tt.samples, tt.threshold, tt.pad)
+		if len(trimmed) != tt.to-tt.from {
+			t.Errorf("%s: trimmed to %d samples, want %d", tt.name, len(trimmed), tt.to-tt.from)
+			continue
+		}
+		for i, s := range trimmed {
+			if s != tt.samples[tt.from+i] {
+				t.Errorf("%s: trimmed sample %d is %v, want %v", tt.name, i, s, tt.samples[tt.from+i])
+				break
+			}
+		}
+	}
+}
+
+func TestTrimChannels(t *testing.T) {
+	// the left channel starts first and the right one ends last, both are
+	// cut to the region audible in either
+	left := padded([]float64{0.5, 0.5}, 100, 300)
+	right := padded([]float64{0.5, 0.5}, 200, 200)
+
+	trimmed, cut := trimChannels([][]float64{left, right}, 0.001, 5)
+	if cut != 95 {
+		t.Errorf("cut %d samples at the start, want 95", cut)
+	}
+	for ch, want := range [][]float64{left[95:207], right[95:207]} {
+		if len(trimmed[ch]) != len(want) {
+			t.Errorf("channel %d trimmed to %d samples, want %d", ch, len(trimmed[ch]), len(want))
+			continue
+		}
+		for i := range want {
+			if trimmed[ch][i] != want[i] {
+				t.Errorf("channel %d sample %d is %v, want %v", ch, i, trimmed[ch][i], want[i])
+				break
+			}
+		}
+	}
+
+	// all silent leaves nothing
+	trimmed, cut = trimChannels([][]float64{make([]float64, 50), make([]float64, 50)}, 0.001, 5)
+	if cut != 0 || len(trimmed[0]) != 0 || len(trimmed[1]) != 0 {
+		t.Errorf("silence trimmed to %d and %d samples with %d cut, want nothing", len(trimmed[0]), len(trimmed[1]), cut)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tecnologer/SoundOfCode/synth"
+)
+
+// ParseChord parses a comma separated list of note names or key numbers,
+// like "C4,E4,G4" or "40,44,47", into piano keys
+func ParseChord(list string) ([]int, error) {
+	if strings.TrimSpace(list) == "" {
+		return nil, errors.New("empty chord")
+	}
+
+	var keys []int
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			return nil, fmt.Errorf("empty note in chord %q", list)
+		}
+
+		key, err := strconv.Atoi(item)
+		if err != nil {
+			key, err = synth.ParseNote(item)
+			if err != nil {
+				return nil, err
+			}
+		} else if key < 1 || key > synth.TotalKeys {
+			return nil, fmt.Errorf("key %d is out of the piano range", key)
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// chordSong returns a song with one track per key, all held for duration
+// at the same time. Tracks are averaged when mixed, so the chord never
+// clips no matter how many voices it has
+func chordSong(keys []int, duration time.Duration) *Song {
+	song := &Song{}
+	for _, key := range keys {
+		song.Tracks = append(song.Tracks, Track{Notes: []Note{{Key: key, Duration: duration}}})
+	}
+	return song
+}
+
+// chordQualities are the semitones above the root of each chord quality
+var chordQualities = map[string][]int{
+	"":     {0, 4, 7},
+	"maj":  {0, 4, 7},
+	"m":    {0, 3, 7},
+	"min":  {0, 3, 7},
+	"7":    {0, 4, 7, 10},
+	"maj7": {0, 4, 7, 11},
+	"m7":   {0, 3, 7, 10},
+	"dim":  {0, 3, 6},
+	"aug":  {0, 4, 8},
+	"sus2": {0, 2, 7},
+	"sus4": {0, 5, 7},
+}
+
+// progressionOctave is the octave chord symbols are rooted in
+const progressionOctave = 4
+
+// ParseChordSymbol converts a chord symbol like Cmaj, Am, F#7 or Bbdim
+// into the keys of its notes, rooted in octave 4
+func ParseChordSymbol(symbol string) ([]int, error) {
+	if symbol == "" {
+		return nil, errors.New("empty chord symbol")
+	}
+
+	semitone, ok := synth.Semitone(symbol[0])
+	if !ok {
+		return nil, fmt.Errorf("invalid chord %q", symbol)
+	}
+
+	quality := symbol[1:]
+	if strings.HasPrefix(quality, "#") {
+		semitone++
+		quality = quality[1:]
+	} else if strings.HasPrefix(quality, "b") {
+		semitone--
+		quality = quality[1:]
+	}
+
+	intervals, ok := chordQualities[quality]
+	if !ok {
+		return nil, fmt.Errorf("unknown chord quality %q in %q", quality, symbol)
+	}
+
+	root := synth.KeyFor(semitone, progressionOctave)
+	keys := make([]int, len(intervals))
+	for i, interval := range intervals {
+		keys[i] = root + interval
+	}
+
+	return keys, nil
+}
+
+// progressionSong parses a comma separated list of chord symbols and
+// returns a song playing each chord for duration. Voice n of every chord
+// goes to track n, chords with fewer voices rest on the extra tracks
+func progressionSong(list string, duration time.Duration) (*Song, error) {
+	var chords [][]int
+	voices := 0
+	for _, symbol := range strings.Split(list, ",") {
+		keys, err := ParseChordSymbol(strings.TrimSpace(symbol))
+		if err != nil {
+			return nil, err
+		}
+
+		chords = append(chords, keys)
+		if len(keys) > voices {
+			voices = len(keys)
+		}
+	}
+
+	song := &Song{Tracks: make([]Track, voices)}
+	for _, keys := range chords {
+		for v := range song.Tracks {
+			note := Note{Duration: duration}
+			if v < len(keys) {
+				note.Key = keys[v]
+			}
+			song.Tracks[v].Notes = append(song.Tracks[v].Notes, note)
+		}
+	}
+
+	return song, nil
+}
+
+// strum staggers the voices of a chord song: voice n of every chord
+// starts n*offset later (counting from the last voice when down is set)
+// and is shortened by the same amount, so the chord still ends together
+func strum(song *Song, offset time.Duration, down bool) {
+	voices := len(song.Tracks)
+	for v := range song.Tracks {
+		order := v
+		if down {
+			order = voices - 1 - v
+		}
+
+		delay := time.Duration(order) * offset
+		if delay == 0 {
+			continue
+		}
+
+		var notes []Note
+		for _, note := range song.Tracks[v].Notes {
+			if note.Key == 0 || note.Duration <= delay {
+				notes = append(notes, note)
+				continue
+			}
+
+			note.Duration -= delay
+			notes = append(notes, Note{Duration: delay}, note)
+		}
+		song.Tracks[v].Notes = notes
+	}
+}
+
+// voicePans returns a pan position for every track of the song, from
+// -spread (left) for the lowest voice to +spread (right) for the highest,
+// evenly apart. Voices are ranked by their average key and a single voice
+// stays centered
+func voicePans(song *Song, spread float64) []float64 {
+	voices := len(song.Tracks)
+	pitch := make([]float64, voices)
+	for v, track := range song.Tracks {
+		n := 0
+		for _, note := range track.Notes {
+			if note.Key > 0 {
+				pitch[v] += float64(note.Key)
+				n++
+			}
+		}
+		if n > 0 {
+			pitch[v] /= float64(n)
+		}
+	}
+
+	order := make([]int, voices)
+	for v := range order {
+		order[v] = v
+	}
+	sort.SliceStable(order, func(i, j int) bool { return pitch[order[i]] < pitch[order[j]] })
+
+	pans := make([]float64, voices)
+	if voices < 2 {
+		return pans
+	}
+	for rank, v := range order {
+		pans[v] = spread * (2*float64(rank)/float64(voices-1) - 1)
+	}
+	return pans
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tecnologer/SoundOfCode/synth"
+)
+
+func TestParseChord(t *testing.T) {
+	tests := []struct {
+		list        string
+		frequencies []float64
+	}{
+		{"C4,E4,G4", []float64{261.63, 329.63, 392.00}},
+		{"40,44,47", []float64{261.63, 329.63, 392.00}},
+		{" A4 , 37 ,A5", []float64{440, 220, 880}},
+		{"F#3,Bb3", []float64{185.00, 233.08}},
+	}
+
+	for _, tt := range tests {
+		keys, err := ParseChord(tt.list)
+		if err != nil {
+			t.Errorf("ParseChord(%q): %v", tt.list, err)
+			continue
+		}
+		if len(keys) != len(tt.frequencies) {
+			t.Errorf("ParseChord(%q) = %v, want %d keys", tt.list, keys, len(tt.frequencies))
+			continue
+		}
+		for i, key := range keys {
+			if f := synth.KeyFrequency(key); math.Abs(f-tt.frequencies[i]) > 0.01 {
+				t.Errorf("ParseChord(%q): note %d at %.2fHz, want %.2fHz", tt.list, i, f, tt.frequencies[i])
+			}
+		}
+	}
+}
+
+func TestParseChordInvalid(t *testing.T) {
+	for _, list := range []string{"", "  ", "C4,,E4", "C4,", "H4", "0", "89", "C4,X"} {
+		if keys, err := ParseChord(list); err == nil {
+			t.Errorf("ParseChord(%q) = %v, should fail", list, keys)
+		}
+	}
+}
+
+func TestChordSong(t *testing.T) {
+	song := chordSong([]int{40, 44, 47}, time.Second)
+	want := &Song{Tracks: []Track{
+		{Notes: []Note{{Key: 40, Duration: time.Second}}},
+		{Notes: []Note{{Key: 44, Duration: time.Second}}},
+		{Notes: []Note{{Key: 47, Duration: time.Second}}},
+	}}
+	if !reflect.DeepEqual(song, want) {
+		t.Errorf("chordSong = %+v, want %+v", song, want)
+	}
+}
+
+func TestParseChordSymbol(t *testing.T) {
+	tests := []struct {
+		symbol string
+		want   []int
+	}{
+		{"C", []int{40, 44, 47}},
+		{"Cmaj", []int{40, 44, 47}},
+		{"Am", []int{49, 52, 56}},
+		{"Amin", []int{49, 52, 56}},
+		{"G7", []int{47, 51, 54, 57}},
+		{"F#7", []int{46, 50, 53, 56}},
+		{"Ebmaj7", []int{43, 47, 50, 54}},
+		{"Dm7", []int{42, 45, 49, 52}},
+		{"Bbdim", []int{50, 53, 56}},
+		{"Caug", []int{40, 44, 48}},
+		{"Dsus2", []int{42, 44, 49}},
+		{"Dsus4", []int{42, 47, 49}},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseChordSymbol(tt.symbol)
+		if err != nil {
+			t.Errorf("ParseChordSymbol(%q): %v", tt.symbol, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseChordSymbol(%q) = %v, want %v", tt.symbol, got, tt.want)
+		}
+	}
+
+	for _, symbol := range []string{"", "H", "Cx", "c", "C#9"} {
+		if _, err := ParseChordSymbol(symbol); err == nil {
+			t.Errorf("ParseChordSymbol(%q) should fail", symbol)
+		}
+	}
+}
+
+func TestProgressionSong(t *testing.T) {
+	song, err := progressionSong("C, Am,G7", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a voice per track, the triads leaving the fourth one resting
+	want := [][]int{
+		{40, 49, 47},
+		{44, 52, 51},
+		{47, 56, 54},
+		{0, 0, 57},
+	}
+	if len(song.Tracks) != len(want) {
+		t.Fatalf("%d tracks, want %d", len(song.Tracks), len(want))
+	}
+	for v, keys := range want {
+		notes := song.Tracks[v].Notes
+		if len(notes) != len(keys) {
+			t.Fatalf("voice %d has %d notes, want %d", v, len(notes), len(keys))
+		}
+		for i, key := range keys {
+			if notes[i].Key != key || notes[i].Duration != time.Second {
+				t.Errorf("voice %d chord %d: %+v, want key %d for 1s", v, i, notes[i], key)
+			}
+		}
+	}
+
+	if _, err := progressionSong("C,Xm", time.Second); err == nil {
+		t.Error("progressionSong should fail on an invalid chord")
+	}
+}
+
+func TestStrum(t *testing.T) {
+	const offset = 30 * time.Millisecond
+	tests := []struct {
+		down bool
+		// delays are when each voice starts
+		delays []time.Duration
+	}{
+		{false, []time.Duration{0, offset, 2 * offset}},
+		{true, []time.Duration{2 * offset, offset, 0}},
+	}
+
+	for _, tt := range tests {
+		song := chordSong([]int{40, 44, 47}, time.Second)
+		strum(song, offset, tt.down)
+
+		for v, delay := range tt.delays {
+			notes := song.Tracks[v].Notes
+			want := []Note{{Key: 40 + []int{0, 4, 7}[v], Duration: time.Second - delay}}
+			if delay > 0 {
+				want = append([]Note{{Duration: delay}}, want...)
+			}
+			if !reflect.DeepEqual(notes, want) {
+				t.Errorf("down %v voice %d: %+v, want %+v", tt.down, v, notes, want)
+			}
+		}
+	}
+}
+
+func TestStrumKeepsTheChordsInTime(t *testing.T) {
+	song, err := progressionSong("C,F,G7", 500*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	strum(song, 50*time.Millisecond, false)
+
+	// every voice still lasts the three chords, the rests don't move
+	for v, track := range song.Tracks {
+		if d := TotalDuration(&Song{Tracks: []Track{track}}, 0); d != 1500*time.Millisecond {
+			t.Errorf("voice %d lasts %v, want 1.5s", v, d)
+		}
+	}
+	if notes := song.Tracks[3].Notes; notes[0].Key != 0 || notes[0].Duration != 500*time.Millisecond {
+		t.Errorf("the rest of the fourth voice became %+v", notes[0])
+	}
+}
+
+func TestVoicePans(t *testing.T) {
+	tests := []struct {
+		keys   []int
+		spread float64
+		pans   []float64
+	}{
+		{[]int{40, 44, 47}, 1, []float64{-1, 0, 1}},
+		{[]int{40, 44, 47}, 0.5, []float64{-0.5, 0, 0.5}},
+		// ranked by pitch, not by track order
+		{[]int{47, 40, 44}, 1, []float64{1, -1, 0}},
+		{[]int{28, 40, 44, 47}, 0.9, []float64{-0.9, -0.3, 0.3, 0.9}},
+		{[]int{40}, 1, []float64{0}},
+	}
+
+	for _, tt := range tests {
+		pans := voicePans(chordSong(tt.keys, time.Second), tt.spread)
+		center := 0.0
+		for i, pan := range pans {
+			if math.Abs(pan-tt.pans[i]) > 1e-9 {
+				t.Errorf("keys %v, spread %g: voice %d at %g, want %g", tt.keys, tt.spread, i, pan, tt.pans[i])
+			}
+			center += pan
+		}
+
+		// symmetric around the middle
+		if math.Abs(center) > 1e-9 {
+			t.Errorf("keys %v, spread %g: the voices add up to %g, want them centered", tt.keys, tt.spread, center)
+		}
+	}
+}
+
+func TestPanStereoConstantPower(t *testing.T) {
+	for _, pan := range []float64{-1, -0.5, 0, 0.3, 1} {
+		channels := [][]float64{{1}, {1}}
+		panStereo(channels, pan)
+		left, right := channels[0][0], channels[1][0]
+		if power := left*left + right*right; math.Abs(power-1) > 1e-9 {
+			t.Errorf("pan %g: power %g, want 1", pan, power)
+		}
+		if pan == -1 && right > 1e-9 || pan == 1 && left > 1e-9 {
+			t.Errorf("pan %g: left %g, right %g, want it hard to one side", pan, left, right)
+		}
+	}
+}
+
+func TestChordSpreadRender(t *testing.T) {
+	setFlag(t, "chord", "C4,E4,G4")
+	keys, err := ParseChord("C4,E4,G4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	song := chordSong(keys, time.Second)
+
+	tests := []struct {
+		spread float64
+	}{
+		{0},
+		{0.5},
+		{1},
+	}
+	for _, tt := range tests {
+		var channels [][]float64
+		err := withFlags(fmt.Sprintf("channel-count=2,chord-spread=%g", tt.spread), func() { channels = renderSong(song) })
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the lowest voice leans left and the highest right as far, the
+		// middle one stays centered
+		level := func(ch, key int) float64 {
+			return partialLevel(channels[ch], synth.KeyFrequency(key), 0, SampleRate)
+		}
+		angle := (tt.spread + 1) * math.Pi / 4
+		if got, want := level(0, 47)/level(1, 47), math.Cos(angle)/math.Sin(angle); math.Abs(got-want) > 0.01 {
+			t.Errorf("spread %g: G4 left/right %.4f, want %.4f", tt.spread, got, want)
+		}
+		if math.Abs(level(0, 40)-level(1, 47)) > 0.01*level(1, 47) || math.Abs(level(1, 40)-level(0, 47)) > 0.01*level(1, 47) {
+			t.Errorf("spread %g: C4 at %.4f/%.4f and G4 at %.4f/%.4f aren't mirrored", tt.spread, level(0, 40), level(1, 40), level(0, 47), level(1, 47))
+		}
+		if math.Abs(level(0, 44)-level(1, 44)) > 0.01*level(1, 44) {
+			t.Errorf("spread %g: E4 at %.4f/%.4f, want it centered", tt.spread, level(0, 44), level(1, 44))
+		}
+		if balance := energy(channels[0]) / energy(channels[1]); math.Abs(balance-1) > 0.02 {
+			t.Errorf("spread %g: left has %.3f of the right energy, want it centered", tt.spread, balance)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/tecnologer/SoundOfCode/synth"
+)
+
+// cue marks the sample where a note starts
+type cue struct {
+	Position int
+	Label    string
+}
+
+// songCues returns a cue for every note of the song, rests excluded,
+// sorted by position. The positions come from the scheduler so they
+// match the rendering, shifted by offset samples (the count-in, less
+// -start-at). Notes starting before the shifted zero get no cue
+func songCues(song *Song, offset int) []cue {
+	var cues []cue
+	for t, track := range song.Tracks {
+		spans, _ := schedule(track.Notes, nil, timing{Gap: *noteGap, Articulation: *articulation, ZeroCross: *zeroCrossings})
+		for _, s := range spans {
+			if offset+s.start < 0 {
+				continue
+			}
+			cues = append(cues, cue{
+				Position: offset + s.start,
+				Label:    fmt.Sprintf("track %d %s", t+1, synth.KeyName(track.Notes[s.note].Key)),
+			})
+		}
+	}
+
+	sort.SliceStable(cues, func(i, j int) bool { return cues[i].Position < cues[j].Position })
+	return cues
+}
+
+// writeCues writes one cue per line as the sample position, the time in
+// seconds and the label, separated by tabs
+func writeCues(w io.Writer, cues []cue) error {
+	for _, c := range cues {
+		_, err := fmt.Fprintf(w, "%d\t%.6f\t%s\n", c.Position, float64(c.Position)/SampleRate, c.Label)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
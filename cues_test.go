@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestSongCues(t *testing.T) {
+	quarter := 500 * time.Millisecond
+	song := &Song{Tracks: []Track{
+		{Notes: []Note{{Key: 40, Duration: quarter}, {Key: 0, Duration: quarter}, {Key: 44, Duration: 2 * quarter}, {Key: 47, Duration: quarter}}},
+		{Notes: []Note{{Key: 28, Duration: 3 * quarter}, {Key: 35, Duration: quarter}}},
+	}}
+
+	tests := []struct {
+		gap       string
+		offset    int
+		positions []int
+		labels    []string
+	}{
+		{
+			"0s", 0,
+			[]int{0, 0, 44100, 66150, 88200},
+			[]string{"track 1 C4", "track 2 C3", "track 1 E4", "track 2 G3", "track 1 G4"},
+		},
+		{
+			// a gap between notes, rests included
+			"100ms", 0,
+			[]int{0, 0, 52920, 70560, 101430},
+			[]string{"track 1 C4", "track 2 C3", "track 1 E4", "track 2 G3", "track 1 G4"},
+		},
+		{
+			// a count-in moves them all
+			"0s", 1000,
+			[]int{1000, 1000, 45100, 67150, 89200},
+			[]string{"track 1 C4", "track 2 C3", "track 1 E4", "track 2 G3", "track 1 G4"},
+		},
+		{
+			// -start-at past the first notes drops their cues
+			"0s", -22050,
+			[]int{22050, 44100, 66150},
+			[]string{"track 1 E4", "track 2 G3", "track 1 G4"},
+		},
+	}
+
+	for _, tt := range tests {
+		setFlag(t, "note-gap", tt.gap)
+		cues := songCues(song, tt.offset)
+		if len(cues) != len(tt.positions) {
+			t.Errorf("gap %s, offset %d: %d cues, want %d", tt.gap, tt.offset, len(cues), len(tt.positions))
+			continue
+		}
+		for i, c := range cues {
+			if c.Position != tt.positions[i] || c.Label != tt.labels[i] {
+				t.Errorf("gap %s, offset %d: cue %d is %d %q, want %d %q", tt.gap, tt.offset, i, c.Position, c.Label, tt.positions[i], tt.labels[i])
+			}
+		}
+	}
+}
+
+func TestSongCuesMatchTheNoteCount(t *testing.T) {
+	tests := []struct {
+		score string
+		notes int
+	}{
+		{"C4:q D4:q E4:q F4:q G4:w", 5},
+		{"C4:e R:e C4:e R:e | E3:h R:h", 3},
+		{"R:w", 0},
+	}
+
+	for _, tt := range tests {
+		song, err := ParseSong(tt.score, 120)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cues := songCues(song, 0); len(cues) != tt.notes {
+			t.Errorf("%q: %d cues, want %d", tt.score, len(cues), tt.notes)
+		}
+	}
+}
+
+func TestWriteCues(t *testing.T) {
+	var b bytes.Buffer
+	err := writeCues(&b, []cue{{0, "track 1 C4"}, {22050, "track 2 A#3"}, {66150, "track 1 G4"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "0\t0.000000\ttrack 1 C4\n22050\t0.500000\ttrack 2 A#3\n66150\t1.500000\ttrack 1 G4\n"
+	if b.String() != want {
+		t.Errorf("writeCues wrote %q, want %q", b.String(), want)
+	}
+}
+
+func TestSongCuesSkipZeroLengthNotes(t *testing.T) {
+	// the grace note isn't played, the labels stay on the notes that are
+	song := &Song{Tracks: []Track{{Notes: []Note{
+		{Key: 40, Duration: 250 * time.Millisecond},
+		{Key: 42},
+		{Key: 44, Duration: 250 * time.Millisecond},
+	}}}}
+
+	want := []cue{{0, "track 1 C4"}, {11025, "track 1 E4"}}
+	cues := songCues(song, 0)
+	if len(cues) != len(want) {
+		t.Fatalf("cues %v, want %v", cues, want)
+	}
+	for i := range want {
+		if cues[i] != want[i] {
+			t.Errorf("cue %d is %v, want %v", i, cues[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,489 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+const (
+	chorusMinDelay = 5 * time.Millisecond
+	chorusMaxDelay = 30 * time.Millisecond
+)
+
+// Chorus mixes the dry signal with several short delayed copies whose
+// delay times are swept by independent slow LFOs
+type Chorus struct {
+	Voices int
+	// Depth is how far each voice's delay swings around its center
+	Depth time.Duration
+	// Rate is the LFO frequency in Hz of the first voice
+	Rate float64
+
+	buf []float64
+	pos int
+	n   int
+}
+
+// NewChorus returns a chorus with its delay line ready for SampleRate
+func NewChorus(voices int, depth time.Duration, rate float64) *Chorus {
+	return &Chorus{
+		Voices: voices,
+		Depth:  depth,
+		Rate:   rate,
+		buf:    make([]float64, int(chorusMaxDelay.Seconds()*SampleRate)+2),
+	}
+}
+
+// Process returns the sample mixed with the chorus voices
+func (c *Chorus) Process(sample float64) float64 {
+	c.buf[c.pos] = sample
+	t := float64(c.n) / SampleRate
+
+	minDelay := chorusMinDelay.Seconds() * SampleRate
+	maxDelay := chorusMaxDelay.Seconds() * SampleRate
+	depth := c.Depth.Seconds() * SampleRate
+
+	wet := 0.0
+	for v := 0; v < c.Voices; v++ {
+		// spread the voices over the delay range, each with its own LFO
+		center := minDelay + (maxDelay-minDelay)*(float64(v)+0.5)/float64(c.Voices)
+		rate := c.Rate * (1 + 0.17*float64(v))
+		phase := τ * float64(v) / float64(c.Voices)
+		delay := center + depth*math.Sin(τ*rate*t+phase)
+		delay = math.Max(minDelay, math.Min(maxDelay, delay))
+
+		wet += c.tap(delay)
+	}
+
+	c.pos = (c.pos + 1) % len(c.buf)
+	c.n++
+
+	if c.Voices == 0 {
+		return sample
+	}
+	return (sample + wet/float64(c.Voices)) / 2
+}
+
+// tap reads the delay line delay samples back, interpolating linearly
+// between the two closest samples
+func (c *Chorus) tap(delay float64) float64 {
+	whole := int(delay)
+	frac := delay - float64(whole)
+	size := len(c.buf)
+
+	a := c.buf[(c.pos-whole+size)%size]
+	b := c.buf[(c.pos-whole-1+size)%size]
+	return a + (b-a)*frac
+}
+
+// Reset clears the delay line and restarts the LFOs
+func (c *Chorus) Reset() {
+	for i := range c.buf {
+		c.buf[i] = 0
+	}
+	c.pos, c.n = 0, 0
+}
+
+// gateRamp is how long the gate takes to fully open or close
+const gateRamp = 5 * time.Millisecond
+
+// Gate switches the signal on and off following a step pattern synced
+// to the tempo, ramping the gain to avoid clicks
+type Gate struct {
+	Steps []bool
+	// StepLength is the length of each step in samples
+	StepLength int
+
+	gain float64
+	n    int
+}
+
+// NewGate returns a gate for the pattern, one step per sixteenth note
+// at the given bpm
+func NewGate(pattern string, bpm int) (*Gate, error) {
+	steps, err := parsePattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	beat := 60.0 / float64(bpm) * SampleRate
+	return &Gate{Steps: steps, StepLength: int(beat / 4), gain: 1}, nil
+}
+
+// Process returns the sample scaled by the current gate gain
+func (g *Gate) Process(sample float64) float64 {
+	target := 0.0
+	if g.Steps[g.n/g.StepLength%len(g.Steps)] {
+		target = 1
+	}
+
+	step := 1 / (gateRamp.Seconds() * SampleRate)
+	if g.gain < target {
+		g.gain = math.Min(target, g.gain+step)
+	} else {
+		g.gain = math.Max(target, g.gain-step)
+	}
+
+	g.n++
+	return sample * g.gain
+}
+
+// Reset opens the gate and restarts the pattern
+func (g *Gate) Reset() {
+	g.gain, g.n = 1, 0
+}
+
+// parsePattern reads a step pattern like "x.x.x.x.", where x is an
+// active step and . an inactive one
+func parsePattern(pattern string) ([]bool, error) {
+	if pattern == "" {
+		return nil, fmt.Errorf("empty pattern")
+	}
+
+	steps := make([]bool, len(pattern))
+	for i, c := range pattern {
+		switch c {
+		case 'x':
+			steps[i] = true
+		case '.':
+		default:
+			return nil, fmt.Errorf("invalid step %q in pattern %q", c, pattern)
+		}
+	}
+
+	return steps, nil
+}
+
+// AutoPan sweeps a mono signal between left and right with an LFO, using
+// constant power gains
+type AutoPan struct {
+	// Rate is the LFO frequency in Hz
+	Rate float64
+	// Depth is how far the sweep goes, 0 keeps it centered and 1 reaches
+	// both sides
+	Depth float64
+
+	n int
+}
+
+// Process returns the left and right samples panned for the current
+// position
+func (a *AutoPan) Process(left, right float64) (float64, float64) {
+	pan := a.Depth * math.Sin(τ*a.Rate*float64(a.n)/SampleRate)
+	a.n++
+
+	angle := (pan + 1) * π / 4
+	return left * math.Cos(angle), right * math.Sin(angle)
+}
+
+// CombFilter is a feedback comb filter, it resonates at SampleRate/DelaySamples
+// and its harmonics
+type CombFilter struct {
+	DelaySamples int
+	Feedback     float64
+
+	buf []float64
+	pos int
+}
+
+// NewCombFilter returns a comb filter tuned to frequency
+func NewCombFilter(frequency, feedback float64) *CombFilter {
+	delay := int(math.Round(SampleRate / frequency))
+	if delay < 1 {
+		delay = 1
+	}
+
+	return &CombFilter{DelaySamples: delay, Feedback: feedback, buf: make([]float64, delay)}
+}
+
+// Process returns the sample plus the output from DelaySamples ago
+// scaled by Feedback
+func (c *CombFilter) Process(sample float64) float64 {
+	out := sample + c.Feedback*c.buf[c.pos]
+	c.buf[c.pos] = out
+	c.pos = (c.pos + 1) % len(c.buf)
+	return out
+}
+
+// Reset clears the delay line
+func (c *CombFilter) Reset() {
+	for i := range c.buf {
+		c.buf[i] = 0
+	}
+	c.pos = 0
+}
+
+// WaveShaper distorts the signal through a soft clipping curve: tanh,
+// atan or cubic
+type WaveShaper struct {
+	Drive float64
+	Curve string
+}
+
+// shapers are the curves available to the WaveShaper
+var shapers = map[string]func(float64) float64{
+	"tanh": math.Tanh,
+	"atan": math.Atan,
+	"cubic": func(x float64) float64 {
+		if x > 1 {
+			return 2.0 / 3
+		}
+		if x < -1 {
+			return -2.0 / 3
+		}
+		return x - x*x*x/3
+	},
+}
+
+// NewWaveShaper validates the curve name
+func NewWaveShaper(drive float64, curve string) (*WaveShaper, error) {
+	if _, ok := shapers[curve]; !ok {
+		return nil, fmt.Errorf("unknown shaper curve %q", curve)
+	}
+	return &WaveShaper{Drive: drive, Curve: curve}, nil
+}
+
+// Process returns shape(drive*sample)/shape(drive), so a full scale input
+// stays at full scale
+func (w *WaveShaper) Process(sample float64) float64 {
+	if w.Drive <= 0 {
+		return sample
+	}
+
+	shape := shapers[w.Curve]
+	return shape(w.Drive*sample) / shape(w.Drive)
+}
+
+// Reset does nothing, the shaper keeps no state
+func (w *WaveShaper) Reset() {}
+
+// Delay is a feedback echo: the signal repeats every Samples samples,
+// each repeat Feedback times quieter, mixed with the dry signal by Mix
+type Delay struct {
+	Samples  int
+	Feedback float64
+	Mix      float64
+
+	buf []float64
+	pos int
+}
+
+// NewDelay returns a delay of length time
+func NewDelay(length time.Duration, feedback, mix float64) *Delay {
+	samples := int(math.Round(length.Seconds() * SampleRate))
+	if samples < 1 {
+		samples = 1
+	}
+	return &Delay{Samples: samples, Feedback: feedback, Mix: mix, buf: make([]float64, samples)}
+}
+
+// Process returns the sample mixed with its echoes
+func (d *Delay) Process(sample float64) float64 {
+	wet := d.buf[d.pos]
+	d.buf[d.pos] = sample + wet*d.Feedback
+	d.pos = (d.pos + 1) % len(d.buf)
+	return sample*(1-d.Mix) + wet*d.Mix
+}
+
+// Reset clears the echoes
+func (d *Delay) Reset() {
+	for i := range d.buf {
+		d.buf[i] = 0
+	}
+	d.pos = 0
+}
+
+const (
+	// noiseGateAttack is how fast the noise gate opens
+	noiseGateAttack = time.Millisecond
+	// noiseGateDetect is how fast the level the noise gate listens to
+	// falls, so it doesn't chatter on every zero crossing
+	noiseGateDetect = 10 * time.Millisecond
+)
+
+// NoiseGate silences the signal once its level stays below Threshold for
+// longer than Hold, fading it out over Release, and opens again as soon
+// as the level comes back
+type NoiseGate struct {
+	// Threshold is the linear level the signal has to reach
+	Threshold float64
+	// Hold and Release are in samples
+	Hold    int
+	Release int
+
+	level float64
+	gain  float64
+	quiet int
+}
+
+// NewNoiseGate returns a noise gate with its threshold in dBFS
+func NewNoiseGate(threshold float64, hold, release time.Duration) *NoiseGate {
+	return &NoiseGate{
+		Threshold: math.Pow(10, threshold/20),
+		Hold:      int(hold.Seconds() * SampleRate),
+		Release:   int(math.Max(1, release.Seconds()*SampleRate)),
+	}
+}
+
+// Process returns the sample scaled by the gate gain
+func (g *NoiseGate) Process(sample float64) float64 {
+	g.level = math.Max(math.Abs(sample), g.level*math.Exp(-1/(noiseGateDetect.Seconds()*SampleRate)))
+
+	if g.level >= g.Threshold {
+		g.quiet = 0
+		g.gain = math.Min(1, g.gain+1/(noiseGateAttack.Seconds()*SampleRate))
+	} else if g.quiet++; g.quiet > g.Hold {
+		g.gain = math.Max(0, g.gain-1/float64(g.Release))
+	}
+
+	return sample * g.gain
+}
+
+// Reset closes the gate with no level heard yet
+func (g *NoiseGate) Reset() {
+	g.level, g.gain, g.quiet = 0, 0, 0
+}
+
+// Glitch is a buffer repeat: the stream is cut in slices of random
+// length and, with Probability, a slice is replaced by the one just
+// played repeated Repeats times
+type Glitch struct {
+	Probability float64
+	// MinSlice and MaxSlice are in samples
+	MinSlice, MaxSlice int
+	Repeats            int
+	// Seed makes the random choices, the same again after every Reset
+	Seed int64
+
+	rng     *rand.Rand
+	history []float64
+	pos     int
+	filled  int
+	slice   []float64
+	played  int
+	left    int
+}
+
+// NewGlitch returns a glitch cutting slices from min to max long, its
+// random choices seeded with seed
+func NewGlitch(probability float64, min, max time.Duration, repeats int, seed int64) *Glitch {
+	lo := int(math.Max(1, min.Seconds()*SampleRate))
+	hi := int(math.Max(float64(lo), max.Seconds()*SampleRate))
+	g := &Glitch{
+		Probability: probability,
+		MinSlice:    lo,
+		MaxSlice:    hi,
+		Repeats:     repeats,
+		Seed:        seed,
+		history:     make([]float64, hi),
+	}
+	g.Reset()
+	return g
+}
+
+// Process returns the sample, or the repeated slice while a glitch plays
+func (g *Glitch) Process(sample float64) float64 {
+	if g.left == 0 {
+		g.nextSlice()
+	}
+	g.left--
+
+	g.history[g.pos] = sample
+	g.pos = (g.pos + 1) % len(g.history)
+	if g.filled < len(g.history) {
+		g.filled++
+	}
+
+	if g.slice == nil {
+		return sample
+	}
+
+	out := g.slice[g.played%len(g.slice)]
+	g.played++
+	return out
+}
+
+// nextSlice picks the length of the next slice and whether it repeats
+// the one before it
+func (g *Glitch) nextSlice() {
+	length := g.MinSlice + g.rng.Intn(g.MaxSlice-g.MinSlice+1)
+	g.slice, g.played, g.left = nil, 0, length
+
+	if g.rng.Float64() >= g.Probability || g.filled < length || g.Repeats < 1 {
+		return
+	}
+
+	g.slice = make([]float64, length)
+	for i := range g.slice {
+		g.slice[i] = g.history[(g.pos-length+i+len(g.history))%len(g.history)]
+	}
+	g.left = length * g.Repeats
+}
+
+// Reset forgets the history and starts the random choices over from Seed
+func (g *Glitch) Reset() {
+	for i := range g.history {
+		g.history[i] = 0
+	}
+	g.rng = rand.New(rand.NewSource(g.Seed))
+	g.pos, g.filled, g.slice, g.played, g.left = 0, 0, nil, 0, 0
+}
+
+// Allpass is a Schroeder allpass filter: it leaves the level of every
+// frequency alone and only shifts their phases
+type Allpass struct {
+	Gain float64
+
+	in, out []float64
+	pos     int
+}
+
+// NewAllpass returns an allpass over delay samples
+func NewAllpass(delay int, gain float64) *Allpass {
+	return &Allpass{Gain: gain, in: make([]float64, delay), out: make([]float64, delay)}
+}
+
+// Process returns the phase shifted sample
+func (a *Allpass) Process(sample float64) float64 {
+	y := -a.Gain*sample + a.in[a.pos] + a.Gain*a.out[a.pos]
+	a.in[a.pos], a.out[a.pos] = sample, y
+	a.pos = (a.pos + 1) % len(a.in)
+	return y
+}
+
+// decorrelationDelays are the allpass delays in samples of each channel,
+// all different primes under 5ms so no two channels smear the same way
+var decorrelationDelays = [][]int{
+	{37, 113, 199},
+	{53, 131, 173},
+	{61, 107, 211},
+	{43, 127, 191},
+	{47, 101, 181},
+	{59, 139, 167},
+}
+
+// Decorrelator runs a channel through its own chain of short allpasses,
+// so channels playing the same signal stop being identical without
+// changing their spectrum
+type Decorrelator []*Allpass
+
+// NewDecorrelator returns the decorrelator of channel ch, depth from 0 to
+// 1 setting how far the phases are smeared
+func NewDecorrelator(ch int, depth float64) Decorrelator {
+	delays := decorrelationDelays[ch%len(decorrelationDelays)]
+	d := make(Decorrelator, len(delays))
+	for i, delay := range delays {
+		d[i] = NewAllpass(delay, 0.7*depth)
+	}
+	return d
+}
+
+// Process returns the sample through every allpass of the chain
+func (d Decorrelator) Process(sample float64) float64 {
+	for _, a := range d {
+		sample = a.Process(sample)
+	}
+	return sample
+}
@@ -0,0 +1,704 @@
+package main
+
+import (
+	"flag"
+	"math"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// noise returns n samples of seeded white noise between -1 and 1
+func noise(n int, seed int64) []float64 {
+	rng := rand.New(rand.NewSource(seed))
+	samples := make([]float64, n)
+	for i := range samples {
+		samples[i] = rng.Float64()*2 - 1
+	}
+	return samples
+}
+
+// process runs the samples through the effect and returns the output
+func process(effect Effect, samples []float64) []float64 {
+	out := make([]float64, len(samples))
+	for i, s := range samples {
+		out[i] = effect.Process(s)
+	}
+	return out
+}
+
+// peakLag returns the lag between min and max samples at which out
+// around at correlates the most with in
+func peakLag(in, out []float64, at, window, min, max int) int {
+	best, bestLag := math.Inf(-1), 0
+	for lag := min; lag <= max; lag++ {
+		sum := 0.0
+		for i := at; i < at+window; i++ {
+			sum += out[i] * in[i-lag]
+		}
+		if sum > best {
+			best, bestLag = sum, lag
+		}
+	}
+	return bestLag
+}
+
+func TestChorusDelaySweeps(t *testing.T) {
+	in := noise(SampleRate, 1)
+	out := process(NewChorus(1, 3*time.Millisecond, 1), in)
+
+	// a single voice sweeps 17.5ms ± 3ms at 1Hz: longest at 0.25s and
+	// shortest at 0.75s
+	tests := []struct {
+		at   float64
+		want time.Duration
+	}{
+		{0.25, 20500 * time.Microsecond},
+		{0.75, 14500 * time.Microsecond},
+	}
+
+	min := int(chorusMinDelay.Seconds() * SampleRate)
+	max := int(chorusMaxDelay.Seconds() * SampleRate)
+	for _, tt := range tests {
+		at := int(tt.at * SampleRate)
+		lag := peakLag(in, out, at-1024, 2048, min, max)
+		want := int(tt.want.Seconds() * SampleRate)
+		if lag < want-20 || lag > want+20 {
+			t.Errorf("at %.2fs the chorus delay is %d samples, want about %d", tt.at, lag, want)
+		}
+	}
+}
+
+func TestChorusWithoutVoicesIsDry(t *testing.T) {
+	in := noise(1000, 2)
+	out := process(NewChorus(0, 3*time.Millisecond, 1), in)
+	for i := range in {
+		if out[i] != in[i] {
+			t.Fatalf("sample %d changed from %v to %v", i, in[i], out[i])
+		}
+	}
+}
+
+func TestGateFollowsThePattern(t *testing.T) {
+	gate, err := NewGate("x.xx", 120)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a sixteenth at 120 BPM
+	step := SampleRate / 8
+	if gate.StepLength != step {
+		t.Fatalf("step length %d samples, want %d", gate.StepLength, step)
+	}
+
+	in := make([]float64, 8*step)
+	for i := range in {
+		in[i] = 1
+	}
+	out := process(gate, in)
+
+	ramp := int(gateRamp.Seconds() * SampleRate)
+	for s, active := range []bool{true, false, true, true, true, false, true, true} {
+		want := 0.0
+		if active {
+			want = 1
+		}
+
+		// past the ramp the gate is fully open or closed for the step
+		for i := s*step + ramp + 1; i < (s+1)*step; i++ {
+			if math.Abs(out[i]-want) > 1e-9 {
+				t.Fatalf("step %d sample %d: %v, want %v", s, i, out[i], want)
+			}
+		}
+	}
+
+	// it closes at the start of the inactive step, not before
+	if out[step-1] != 1 || out[step+ramp/2] <= 0 || out[step+ramp/2] >= 1 {
+		t.Errorf("gate edges: %v before the step, %v in the ramp", out[step-1], out[step+ramp/2])
+	}
+}
+
+func TestParsePattern(t *testing.T) {
+	for _, pattern := range []string{"", "x-x", "X."} {
+		if _, err := parsePattern(pattern); err == nil {
+			t.Errorf("parsePattern(%q) should fail", pattern)
+		}
+	}
+}
+
+func TestAutoPanOscillates(t *testing.T) {
+	tests := []struct {
+		rate, depth float64
+	}{
+		{1, 1},
+		{2, 0.5},
+		{4, 1},
+	}
+
+	const seconds = 2
+	window := SampleRate / 100
+	for _, tt := range tests {
+		pan := &AutoPan{Rate: tt.rate, Depth: tt.depth}
+
+		// the balance between left and right energy, 10ms at a time
+		var balance []float64
+		var left, right float64
+		for i := 0; i < seconds*SampleRate; i++ {
+			l, r := pan.Process(1, 1)
+			if math.Abs(l*l+r*r-1) > 1e-9 {
+				t.Fatalf("rate %g: the power at sample %d is %v, want 1", tt.rate, i, l*l+r*r)
+			}
+
+			left += l * l
+			right += r * r
+			if (i+1)%window == 0 {
+				balance = append(balance, right-left)
+				left, right = 0, 0
+			}
+		}
+
+		// it goes right then left once per LFO period
+		crossings := 0
+		for i := 1; i < len(balance); i++ {
+			if (balance[i-1] < 0) != (balance[i] < 0) {
+				crossings++
+			}
+		}
+		if want := int(2 * tt.rate * seconds); crossings < want-1 || crossings > want {
+			t.Errorf("rate %g: the balance crosses the center %d times in %ds, want %d", tt.rate, crossings, seconds, want)
+		}
+
+		// and as far as the depth goes, at the peak of the LFO the right
+		// energy is over the left one by sin(depth·π/2)
+		peak := 0.0
+		for _, b := range balance {
+			peak = math.Max(peak, b/float64(window))
+		}
+		if want := math.Sin(tt.depth * π / 2); math.Abs(peak-want) > 0.01 {
+			t.Errorf("rate %g: the balance peaks at %.3f, want %.3f", tt.rate, peak, want)
+		}
+	}
+}
+
+func TestAutoPanWithoutDepthIsCentered(t *testing.T) {
+	pan := &AutoPan{Rate: 3}
+	for i := 0; i < SampleRate; i++ {
+		l, r := pan.Process(0.5, 0.5)
+		if math.Abs(l-r) > 1e-12 {
+			t.Fatalf("sample %d panned to %v, %v", i, l, r)
+		}
+	}
+}
+
+func TestCombFilterImpulse(t *testing.T) {
+	tests := []struct {
+		frequency, feedback float64
+		delay               int
+	}{
+		{441, 0.5, 100},
+		{220, 0.9, 200},
+		{1000, -0.7, 44},
+		{SampleRate * 2, 0.5, 1},
+	}
+
+	for _, tt := range tests {
+		comb := NewCombFilter(tt.frequency, tt.feedback)
+		if comb.DelaySamples != tt.delay {
+			t.Errorf("%gHz: delay of %d samples, want %d", tt.frequency, comb.DelaySamples, tt.delay)
+		}
+
+		impulse := make([]float64, 10*tt.delay+1)
+		impulse[0] = 1
+		out := process(comb, impulse)
+
+		// an echo every delay samples, each feedback times the previous one
+		for i, s := range out {
+			want := 0.0
+			if i%tt.delay == 0 {
+				want = math.Pow(tt.feedback, float64(i/tt.delay))
+			}
+			if math.Abs(s-want) > 1e-12 {
+				t.Errorf("%gHz: sample %d is %v, want %v", tt.frequency, i, s, want)
+				break
+			}
+		}
+	}
+}
+
+// harmonicRatio is the level of the odd harmonics 3 to 9 of a tone
+// falling on the bin of the spectrum, relative to its fundamental
+func harmonicRatio(samples []float64, bin int) float64 {
+	magnitudes := spectrum(samples)
+	level := func(b int) float64 {
+		return math.Max(magnitudes[b-1], math.Max(magnitudes[b], magnitudes[b+1]))
+	}
+
+	harmonics := 0.0
+	for h := 3; h <= 9; h += 2 {
+		harmonics += level(h * bin)
+	}
+	return harmonics / level(bin)
+}
+
+func TestWaveShaperAddsHarmonics(t *testing.T) {
+	// 64 bins up in a 8192 sample spectrum
+	const bin = 64
+	in := tone(bin*SampleRate/8192.0, 0.8, 8192.0/SampleRate)
+
+	for curve := range shapers {
+		previous := harmonicRatio(in, bin)
+		for _, drive := range []float64{0.5, 2, 5, 20} {
+			shaper, err := NewWaveShaper(drive, curve)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out := process(shaper, in)
+
+			for i, s := range out {
+				if math.Abs(s) > 1 {
+					t.Fatalf("%s drive %g: sample %d out of bounds at %v", curve, drive, i, s)
+				}
+			}
+
+			ratio := harmonicRatio(out, bin)
+			if ratio <= previous {
+				t.Errorf("%s drive %g: harmonics at %.4f of the fundamental, no more than %.4f at a lower drive", curve, drive, ratio, previous)
+			}
+			previous = ratio
+		}
+	}
+}
+
+func TestWaveShaperSmallDriveIsTransparent(t *testing.T) {
+	in := noise(1000, 4)
+	for curve := range shapers {
+		shaper, err := NewWaveShaper(0.01, curve)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := process(shaper, in)
+		for i := range in {
+			if math.Abs(out[i]-in[i]) > 1e-4 {
+				t.Fatalf("%s: sample %d went from %v to %v", curve, i, in[i], out[i])
+			}
+		}
+	}
+
+	if _, err := NewWaveShaper(1, "square"); err == nil {
+		t.Error("NewWaveShaper should reject an unknown curve")
+	}
+}
+
+func TestDelaySync(t *testing.T) {
+	tests := []struct {
+		config  string
+		samples int
+	}{
+		{"delay-sync=q,bpm=120", 22050},
+		{"delay-sync=e.,bpm=120", 16538},
+		{"delay-sync=q,bpm=90", 29400},
+		{"delay-sync=s,bpm=120,delay=1s", 5513},
+		{"delay=100ms", 4410},
+	}
+
+	for _, tt := range tests {
+		var effects map[string]Effect
+		var err error
+		if werr := withFlags(tt.config, func() { effects, err = trackEffects() }); werr != nil {
+			t.Fatal(werr)
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tt.config, err)
+		}
+
+		delay, ok := effects["delay"].(*Delay)
+		if !ok {
+			t.Fatalf("%s: no delay", tt.config)
+		}
+		if delay.Samples != tt.samples {
+			t.Errorf("%s: delay of %d samples, want %d", tt.config, delay.Samples, tt.samples)
+		}
+	}
+
+	// caught with the other flags, before anything renders
+	if err := withFlags("delay-sync=x", func() {}); err == nil {
+		t.Error("an unknown -delay-sync note should fail")
+	}
+}
+
+func TestDelayEchoes(t *testing.T) {
+	delay := NewDelay(10*time.Millisecond, 0.5, 0.4)
+	impulse := make([]float64, 5*delay.Samples)
+	impulse[0] = 1
+	out := process(delay, impulse)
+
+	// the dry impulse, then echoes every delay, each half the previous one
+	for i, s := range out {
+		want := 0.0
+		switch {
+		case i == 0:
+			want = 0.6
+		case i%delay.Samples == 0:
+			want = 0.4 * math.Pow(0.5, float64(i/delay.Samples-1))
+		}
+		if math.Abs(s-want) > 1e-12 {
+			t.Fatalf("sample %d is %v, want %v", i, s, want)
+		}
+	}
+}
+
+// setFlag sets the flag for the rest of the test, see withFlags for the
+// values without commas
+func setFlag(t *testing.T, name, value string) {
+	t.Helper()
+	previous := flag.Lookup(name).Value.String()
+	if err := flag.Set(name, value); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { flag.Set(name, previous) })
+}
+
+// runChain processes the samples through the effects one after the other
+func runChain(chain []Effect, samples []float64) []float64 {
+	out := make([]float64, len(samples))
+	for i, s := range samples {
+		for _, effect := range chain {
+			s = effect.Process(s)
+		}
+		out[i] = s
+	}
+	return out
+}
+
+func TestEffectOrder(t *testing.T) {
+	setFlag(t, "drive", "8")
+	setFlag(t, "delay", "10ms")
+	setFlag(t, "delay-mix", "0.5")
+	setFlag(t, "comb-pitch", "300")
+	in := noise(5000, 8)
+
+	outputs := map[string][]float64{}
+	for _, order := range []string{"drive,delay", "delay,drive", "comb,drive,delay", "drive,comb,delay"} {
+		setFlag(t, "effects", order)
+		chain, err := effectChain()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := len(strings.Split(order, ",")); len(chain) != want {
+			t.Fatalf("%s: %d effects, want %d", order, len(chain), want)
+		}
+		outputs[order] = runChain(chain, in)
+	}
+
+	// the same effects in another order sound different
+	for _, pair := range [][2]string{{"drive,delay", "delay,drive"}, {"comb,drive,delay", "drive,comb,delay"}} {
+		if reflect.DeepEqual(outputs[pair[0]], outputs[pair[1]]) {
+			t.Errorf("%s and %s give the same output", pair[0], pair[1])
+		}
+	}
+
+	// the effects left out or turned off by their flags are skipped
+	setFlag(t, "effects", "chorus,drive")
+	if chain, err := effectChain(); err != nil || len(chain) != 1 {
+		t.Errorf("chorus,drive without -chorus: %d effects, %v", len(chain), err)
+	}
+
+	setFlag(t, "effects", "drive,reverb")
+	if _, err := effectChain(); err == nil {
+		t.Error("an unknown effect should fail")
+	}
+}
+
+func TestEffectChainRepeats(t *testing.T) {
+	setFlag(t, "drive", "4")
+	setFlag(t, "delay", "10ms")
+	setFlag(t, "delay-mix", "0.5")
+	setFlag(t, "comb-pitch", "300")
+	in := noise(5000, 8)
+
+	tests := []struct {
+		order string
+		// once is the chain with each effect listed once, run twice over
+		once string
+	}{
+		{"delay,delay", "delay"},
+		{"comb,comb", "comb"},
+		{"drive,delay,drive,delay", "drive,delay"},
+	}
+
+	for _, tt := range tests {
+		setFlag(t, "effects", tt.order)
+		chain, err := effectChain()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := len(strings.Split(tt.order, ",")); len(chain) != want {
+			t.Fatalf("%s: %d effects, want %d", tt.order, len(chain), want)
+		}
+		for i := range chain {
+			for j := i + 1; j < len(chain); j++ {
+				if chain[i] == chain[j] {
+					t.Errorf("%s: effects %d and %d share one instance", tt.order, i, j)
+				}
+			}
+		}
+		got := runChain(chain, in)
+
+		// every repeat keeps its own delay line, so the chain sounds like
+		// the output of one pass going through fresh effects again
+		setFlag(t, "effects", tt.once)
+		first, err := effectChain()
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := effectChain()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := runChain(second, runChain(first, in))
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s doesn't sound like %s twice", tt.order, tt.once)
+		}
+	}
+}
+
+func TestEffectReset(t *testing.T) {
+	gate, err := NewGate("x.x.", 120)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shaper, err := NewWaveShaper(3, "tanh")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		effect Effect
+	}{
+		{"chorus", NewChorus(3, 3*time.Millisecond, 1)},
+		{"gate", gate},
+		{"comb", NewCombFilter(441, 0.5)},
+		{"drive", shaper},
+		{"delay", NewDelay(5*time.Millisecond, 0.5, 0.5)},
+		{"noisegate", NewNoiseGate(-20, 10*time.Millisecond, 10*time.Millisecond)},
+		{"glitch", NewGlitch(0.5, time.Millisecond, 5*time.Millisecond, 2, 9)},
+	}
+
+	// after Reset an effect processes the signal as if just created
+	in := noise(20000, 9)
+	for _, tt := range tests {
+		first := process(tt.effect, in)
+		tt.effect.Reset()
+		if again := process(tt.effect, in); !reflect.DeepEqual(first, again) {
+			t.Errorf("%s remembers the signal after Reset", tt.name)
+		}
+	}
+}
+
+func TestNoiseGate(t *testing.T) {
+	tests := []struct {
+		threshold     float64
+		hold, release time.Duration
+	}{
+		{-40, 50 * time.Millisecond, 20 * time.Millisecond},
+		{-30, 200 * time.Millisecond, 100 * time.Millisecond},
+		{-50, 0, 5 * time.Millisecond},
+	}
+
+	// a tone, a stretch of low noise under every threshold and the tone
+	// again, 500ms each
+	third := SampleRate / 2
+	in := make([]float64, 3*third)
+	hiss := noise(third, 5)
+	for i := range in {
+		in[i] = 0.5 * math.Sin(τ*440*float64(i)/SampleRate)
+		if i >= third && i < 2*third {
+			in[i] = 0.001 * hiss[i-third]
+		}
+	}
+
+	// how long the detected level takes to fall from the tone under the
+	// threshold
+	detect := int(noiseGateDetect.Seconds() * SampleRate * math.Log(0.5/0.001))
+	for _, tt := range tests {
+		gate := NewNoiseGate(tt.threshold, tt.hold, tt.release)
+		out := process(gate, in)
+		hold, release := int(tt.hold.Seconds()*SampleRate), int(tt.release.Seconds()*SampleRate)
+
+		// open through the tone and the hold after it
+		for i := SampleRate / 100; i < third+hold; i++ {
+			if out[i] != in[i] {
+				t.Fatalf("%gdB hold %v: sample %d is %v, want it untouched at %v", tt.threshold, tt.hold, i, out[i], in[i])
+			}
+		}
+
+		// silent once the hold and the release are over
+		for i := third + detect + hold + release; i < 2*third; i++ {
+			if out[i] != 0 {
+				t.Fatalf("%gdB hold %v: sample %d is %v, want it gated", tt.threshold, tt.hold, i, out[i])
+			}
+		}
+
+		// open again a couple of milliseconds after the tone returns
+		for i := 2*third + SampleRate/500; i < len(in); i++ {
+			if out[i] != in[i] {
+				t.Fatalf("%gdB hold %v: sample %d is %v, want it untouched at %v after reopening", tt.threshold, tt.hold, i, out[i], in[i])
+			}
+		}
+	}
+}
+
+func TestGlitchRepeatsTheSlice(t *testing.T) {
+	tests := []struct {
+		probability float64
+		min, max    time.Duration
+		repeats     int
+		seed        int64
+	}{
+		{0.5, 10 * time.Millisecond, 50 * time.Millisecond, 2, 1},
+		{1, 5 * time.Millisecond, 5 * time.Millisecond, 4, 2},
+		{0.3, time.Millisecond, 100 * time.Millisecond, 1, 3},
+	}
+
+	// a ramp, so every sample says where it came from
+	in := make([]float64, 2*SampleRate)
+	for i := range in {
+		in[i] = float64(i + 1)
+	}
+
+	for _, tt := range tests {
+		g := NewGlitch(tt.probability, tt.min, tt.max, tt.repeats, tt.seed)
+		out := process(g, in)
+
+		glitches := 0
+		for i := 0; i < len(out); {
+			if out[i] == in[i] {
+				i++
+				continue
+			}
+
+			// a glitch replays the slice that just went by, exactly, the
+			// repeats times
+			from := int(out[i]) - 1
+			length := i - from
+			if length < g.MinSlice || length > g.MaxSlice {
+				t.Fatalf("p=%g: sample %d replays a %d sample slice, want %d to %d", tt.probability, i, length, g.MinSlice, g.MaxSlice)
+			}
+			for k := 0; k < length*tt.repeats && i+k < len(out); k++ {
+				if want := in[from+k%length]; out[i+k] != want {
+					t.Fatalf("p=%g: sample %d of the glitch at %d is %v, want %v", tt.probability, k, i, out[i+k], want)
+				}
+			}
+			glitches++
+			i += length * tt.repeats
+		}
+
+		if glitches == 0 {
+			t.Errorf("p=%g: never glitched", tt.probability)
+		}
+
+		// the same seed glitches the same way again after a Reset
+		g.Reset()
+		again := process(g, in)
+		for i := range out {
+			if again[i] != out[i] {
+				t.Errorf("p=%g: sample %d is %v after Reset, was %v", tt.probability, i, again[i], out[i])
+				break
+			}
+		}
+	}
+}
+
+func TestGlitchWithoutProbabilityIsDry(t *testing.T) {
+	in := noise(SampleRate, 9)
+	for _, g := range []*Glitch{
+		NewGlitch(0, 10*time.Millisecond, 50*time.Millisecond, 3, 1),
+		NewGlitch(1, 10*time.Millisecond, 50*time.Millisecond, 0, 1),
+	} {
+		out := process(g, in)
+		for i := range in {
+			if out[i] != in[i] {
+				t.Fatalf("p=%g, %d repeats: sample %d is %v, want %v", g.Probability, g.Repeats, i, out[i], in[i])
+			}
+		}
+	}
+}
+
+// energy is the sum of the squared samples
+func energy(samples []float64) float64 {
+	sum := 0.0
+	for _, s := range samples {
+		sum += s * s
+	}
+	return sum
+}
+
+// correlation is the normalized cross-correlation of a and b at lag 0
+func correlation(a, b []float64) float64 {
+	var ab, aa, bb float64
+	for i := range a {
+		ab += a[i] * b[i]
+		aa += a[i] * a[i]
+		bb += b[i] * b[i]
+	}
+	return ab / math.Sqrt(aa*bb)
+}
+
+func TestDecorrelate(t *testing.T) {
+	tests := []struct {
+		depth float64
+		// maxCorrelation is the most the channels may still correlate
+		maxCorrelation float64
+	}{
+		{1, 0.3},
+		{0.5, 0.3},
+		{0.25, 0.3},
+	}
+
+	in := noise(SampleRate, 11)
+	for _, tt := range tests {
+		channels := make([][]float64, 2)
+		for ch := range channels {
+			d := NewDecorrelator(ch, tt.depth)
+			channels[ch] = make([]float64, len(in))
+			for i, s := range in {
+				channels[ch][i] = d.Process(s)
+			}
+
+			// an allpass keeps the energy of the channel
+			if ratio := energy(channels[ch]) / energy(in); math.Abs(ratio-1) > 0.02 {
+				t.Errorf("depth %g: channel %d keeps %.3f of the energy", tt.depth, ch, ratio)
+			}
+		}
+
+		c := correlation(channels[0], channels[1])
+		if math.Abs(c) > tt.maxCorrelation {
+			t.Errorf("depth %g: the channels correlate at %.3f, want at most %.2f", tt.depth, c, tt.maxCorrelation)
+		}
+
+		// uncorrelated channels keep half the energy in the mono sum, only
+		// channels cancelling each other would keep less
+		if m := monoCompatibility(channels); m < 0.45 {
+			t.Errorf("depth %g: the mono sum keeps %.3f of the energy, want at least 0.45", tt.depth, m)
+		}
+	}
+}
+
+func TestDecorrelateRender(t *testing.T) {
+	const score = "C4:q E4:q G4:q C5:q"
+	dry := renderWith(t, "channel-count=2", score)
+	wide := renderWith(t, "channel-count=2,decorrelate=1", score)
+
+	if c := correlation(dry[0], dry[1]); c < 1-1e-9 {
+		t.Errorf("without -decorrelate the channels correlate at %.3f, want them identical", c)
+	}
+	if c := correlation(wide[0], wide[1]); c > 0.99 {
+		t.Errorf("with -decorrelate the channels correlate at %.3f, want them apart", c)
+	}
+	if m := monoCompatibility(wide); m < 0.45 {
+		t.Errorf("with -decorrelate the mono sum keeps %.3f of the energy", m)
+	}
+}
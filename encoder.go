@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// AudioEncoder writes interleaved samples in some file format
+type AudioEncoder interface {
+	Encode(w io.Writer, samples []float64, channels, sampleRate int) error
+}
+
+// errNoVorbis is returned for .ogg output, there is no Vorbis encoder
+// available to this build
+var errNoVorbis = errors.New("OGG Vorbis export is not available: no Vorbis encoder is vendored in this build")
+
+// rawEncoder writes the samples as float32 in 8 byte slots, the original
+// out.bin format
+type rawEncoder struct{}
+
+func (rawEncoder) Encode(w io.Writer, samples []float64, channels, sampleRate int) error {
+	_, err := w.Write(encode(samples))
+	return err
+}
+
+// wavEncoder writes a RIFF/WAVE file
+type wavEncoder struct {
+	BitsPerSample int
+	// Clip is how PCM formats handle samples beyond full scale, one of
+	// clipModes
+	Clip string
+}
+
+func (e wavEncoder) Encode(w io.Writer, samples []float64, channels, sampleRate int) error {
+	return writeWAV(w, samples, channels, sampleRate, e.BitsPerSample, e.Clip)
+}
+
+// pcmEncoder writes headerless little endian PCM, for piping into other
+// tools
+type pcmEncoder struct {
+	BitsPerSample int
+	// Clip is how integer formats handle samples beyond full scale, one of
+	// clipModes
+	Clip string
+}
+
+func (e pcmEncoder) Encode(w io.Writer, samples []float64, channels, sampleRate int) error {
+	return newPCMWriter(w, e.BitsPerSample, e.Clip).Write(samples)
+}
+
+// sampleFormats maps the -sample-format names to WAV bits per sample
+var sampleFormats = map[string]int{"u8": 8, "s16": 16, "s24": 24, "f32": 32}
+
+// encoderFor picks the encoder from the output file extension, format
+// and clip being the WAV sample format and clip mode. The path "-" is
+// standard output, written as raw PCM in that format
+func encoderFor(path, format, clip string) (AudioEncoder, error) {
+	if path == "-" {
+		bits, ok := sampleFormats[format]
+		if !ok {
+			return nil, fmt.Errorf("unknown sample format %q, use u8, s16, s24 or f32", format)
+		}
+		if !clipModes[clip] {
+			return nil, fmt.Errorf("unknown clip mode %q, use clamp, soft or wrap", clip)
+		}
+		return pcmEncoder{BitsPerSample: bits, Clip: clip}, nil
+	}
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".wav":
+		bits, ok := sampleFormats[format]
+		if !ok {
+			return nil, fmt.Errorf("unknown sample format %q, use u8, s16, s24 or f32", format)
+		}
+		if !clipModes[clip] {
+			return nil, fmt.Errorf("unknown clip mode %q, use clamp, soft or wrap", clip)
+		}
+		return wavEncoder{BitsPerSample: bits, Clip: clip}, nil
+	case ".flac":
+		bits, ok := sampleFormats[format]
+		if !ok {
+			return nil, fmt.Errorf("unknown sample format %q, use u8, s16 or s24", format)
+		}
+		if bits == 32 {
+			return nil, errors.New("FLAC stores integers, use -sample-format u8, s16 or s24")
+		}
+		if !clipModes[clip] {
+			return nil, fmt.Errorf("unknown clip mode %q, use clamp, soft or wrap", clip)
+		}
+		return flacEncoder{BitsPerSample: bits, Clip: clip}, nil
+	case ".ogg":
+		return nil, errNoVorbis
+	case ".bin", "":
+		return rawEncoder{}, nil
+	default:
+		return nil, fmt.Errorf("unknown output format %q", ext)
+	}
+}
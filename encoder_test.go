@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestEncoderFor(t *testing.T) {
+	tests := []struct {
+		path, format, clip string
+		want               AudioEncoder
+	}{
+		{"out.bin", "f32", "clamp", rawEncoder{}},
+		{"out", "s16", "clamp", rawEncoder{}},
+		{"song.wav", "s16", "clamp", wavEncoder{BitsPerSample: 16, Clip: "clamp"}},
+		{"SONG.WAV", "u8", "soft", wavEncoder{BitsPerSample: 8, Clip: "soft"}},
+		{"song.wav", "f32", "wrap", wavEncoder{BitsPerSample: 32, Clip: "wrap"}},
+		{"song.flac", "s24", "clamp", flacEncoder{BitsPerSample: 24, Clip: "clamp"}},
+		{"-", "s16", "soft", pcmEncoder{BitsPerSample: 16, Clip: "soft"}},
+	}
+
+	for _, tt := range tests {
+		got, err := encoderFor(tt.path, tt.format, tt.clip)
+		if err != nil {
+			t.Errorf("encoderFor(%q, %q, %q): %v", tt.path, tt.format, tt.clip, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("encoderFor(%q, %q, %q) = %#v, want %#v", tt.path, tt.format, tt.clip, got, tt.want)
+		}
+	}
+}
+
+func TestEncoderForErrors(t *testing.T) {
+	tests := []struct {
+		path, format, clip string
+	}{
+		{"song.mp3", "s16", "clamp"},
+		{"song.wav", "s12", "clamp"},
+		{"song.wav", "s16", "fold"},
+		{"song.flac", "f32", "clamp"},
+		{"-", "s32", "clamp"},
+	}
+
+	for _, tt := range tests {
+		if _, err := encoderFor(tt.path, tt.format, tt.clip); err == nil {
+			t.Errorf("encoderFor(%q, %q, %q) should fail", tt.path, tt.format, tt.clip)
+		}
+	}
+
+	// without a Vorbis encoder .ogg says so rather than writing something
+	// else under that name
+	if _, err := encoderFor("song.ogg", "s16", "clamp"); err != errNoVorbis {
+		t.Errorf("encoderFor(song.ogg): %v, want %v", err, errNoVorbis)
+	}
+}
+
+func TestWAVEncoderHeader(t *testing.T) {
+	for _, channels := range []int{1, 2, 6} {
+		var buf bytes.Buffer
+		samples := make([]float64, 100*channels)
+		if err := (wavEncoder{BitsPerSample: 16, Clip: "clamp"}).Encode(&buf, samples, channels, 48000); err != nil {
+			t.Fatal(err)
+		}
+
+		h, err := readWAVHeader(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h.Channels != channels || h.SampleRate != 48000 || h.BitsPerSample != 16 || h.Frames() != 100 {
+			t.Errorf("%d channels: header %+v", channels, *h)
+		}
+	}
+}
+
+func TestPCMEncoder(t *testing.T) {
+	samples := []float64{0, 0.5, -0.5, 1, -1, 0.25, 0.1, -0.1}
+	for format, bits := range sampleFormats {
+		// the raw PCM is the data chunk of the same WAV, without a header
+		var pcm, wav bytes.Buffer
+		if err := (pcmEncoder{BitsPerSample: bits, Clip: "clamp"}).Encode(&pcm, samples, 2, SampleRate); err != nil {
+			t.Fatal(err)
+		}
+		if err := (wavEncoder{BitsPerSample: bits, Clip: "clamp"}).Encode(&wav, samples, 2, SampleRate); err != nil {
+			t.Fatal(err)
+		}
+
+		if pcm.Len() != len(samples)*bits/8 {
+			t.Errorf("%s: %d bytes of PCM for %d samples", format, pcm.Len(), len(samples))
+		}
+		if !bytes.HasSuffix(wav.Bytes(), pcm.Bytes()) {
+			t.Errorf("%s: the PCM isn't the WAV data", format)
+		}
+	}
+}
+
+func TestStdoutRejectsReplayGain(t *testing.T) {
+	tests := []struct {
+		config string
+		ok     bool
+	}{
+		{"stdout=true", true},
+		{"out=-", true},
+		{"replay-gain=true,out=song.wav", true},
+		// there is no file to write the sidecar next to
+		{"replay-gain=true,stdout=true", false},
+		{"replay-gain=true,out=-", false},
+	}
+
+	for _, tt := range tests {
+		if err := withFlags(tt.config, func() {}); (err == nil) != tt.ok {
+			t.Errorf("%s: error %v, want ok %v", tt.config, err, tt.ok)
+		}
+	}
+}
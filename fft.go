@@ -0,0 +1,60 @@
+package main
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// fft returns the discrete Fourier transform of x. len(x) must be a power
+// of two
+func fft(x []complex128) []complex128 {
+	n := len(x)
+	if n <= 1 {
+		return append([]complex128(nil), x...)
+	}
+
+	even := make([]complex128, n/2)
+	odd := make([]complex128, n/2)
+	for i := 0; i < n/2; i++ {
+		even[i] = x[2*i]
+		odd[i] = x[2*i+1]
+	}
+
+	even, odd = fft(even), fft(odd)
+	out := make([]complex128, n)
+	for k := 0; k < n/2; k++ {
+		t := cmplx.Rect(1, -τ*float64(k)/float64(n)) * odd[k]
+		out[k] = even[k] + t
+		out[k+n/2] = even[k] - t
+	}
+
+	return out
+}
+
+// spectrum returns the magnitude of the first half of the FFT of the
+// samples, Hann windowed and truncated to a power of two
+func spectrum(samples []float64) []float64 {
+	n := 1
+	for n*2 <= len(samples) {
+		n *= 2
+	}
+
+	x := make([]complex128, n)
+	for i := range x {
+		window := 0.5 - 0.5*math.Cos(τ*float64(i)/float64(n-1))
+		x[i] = complex(samples[i]*window, 0)
+	}
+
+	bins := fft(x)
+	magnitudes := make([]float64, n/2)
+	for i := range magnitudes {
+		magnitudes[i] = cmplx.Abs(bins[i])
+	}
+
+	return magnitudes
+}
+
+// binFrequency is the frequency in Hz of bin in a spectrum of size bins
+func binFrequency(bin float64, size, sampleRate int) float64 {
+	return bin * float64(sampleRate) / float64(2*size)
+}
@@ -0,0 +1,60 @@
+package main
+
+import "math"
+
+// biquad is a second order IIR filter (direct form I) with coefficients
+// already normalized by a0
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	x1, x2, y1, y2     float64
+}
+
+// newBiquad normalizes the coefficients by a0
+func newBiquad(b0, b1, b2, a0, a1, a2 float64) *biquad {
+	return &biquad{b0: b0 / a0, b1: b1 / a0, b2: b2 / a0, a1: a1 / a0, a2: a2 / a0}
+}
+
+// newHighShelf returns a high shelf with gain in dB above frequency
+func newHighShelf(frequency, gain, q float64, sampleRate int) *biquad {
+	a := math.Pow(10, gain/40)
+	w0 := τ * frequency / float64(sampleRate)
+	cos := math.Cos(w0)
+	alpha := math.Sin(w0) / (2 * q)
+	sqrtA := 2 * math.Sqrt(a) * alpha
+
+	return newBiquad(
+		a*((a+1)+(a-1)*cos+sqrtA),
+		-2*a*((a-1)+(a+1)*cos),
+		a*((a+1)+(a-1)*cos-sqrtA),
+		(a+1)-(a-1)*cos+sqrtA,
+		2*((a-1)-(a+1)*cos),
+		(a+1)-(a-1)*cos-sqrtA,
+	)
+}
+
+// newHighPass returns a high pass filter cutting below frequency
+func newHighPass(frequency, q float64, sampleRate int) *biquad {
+	w0 := τ * frequency / float64(sampleRate)
+	cos := math.Cos(w0)
+	alpha := math.Sin(w0) / (2 * q)
+
+	return newBiquad((1+cos)/2, -(1 + cos), (1+cos)/2, 1+alpha, -2*cos, 1-alpha)
+}
+
+// newLowPass returns a low pass filter cutting above frequency, a
+// Butterworth one with q 1/√2
+func newLowPass(frequency, q float64, sampleRate int) *biquad {
+	w0 := τ * frequency / float64(sampleRate)
+	cos := math.Cos(w0)
+	alpha := math.Sin(w0) / (2 * q)
+
+	return newBiquad((1-cos)/2, 1-cos, (1-cos)/2, 1+alpha, -2*cos, 1-alpha)
+}
+
+// Process filters one sample
+func (f *biquad) Process(x float64) float64 {
+	y := f.b0*x + f.b1*f.x1 + f.b2*f.x2 - f.a1*f.y1 - f.a2*f.y2
+	f.x2, f.x1 = f.x1, x
+	f.y2, f.y1 = f.y1, y
+	return y
+}
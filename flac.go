@@ -0,0 +1,309 @@
+package main
+
+import (
+	"crypto/md5"
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	// flacBlockSize is the number of samples per channel in a FLAC frame
+	flacBlockSize = 4096
+	// flacMaxRice is the largest Rice parameter of the 4-bit residual
+	// coding, beyond it a subframe is stored verbatim
+	flacMaxRice = 14
+)
+
+// flacEncoder writes a FLAC file, lossless at BitsPerSample, with fixed
+// linear predictors and Rice coded residuals
+type flacEncoder struct {
+	BitsPerSample int
+	// Clip is how samples beyond full scale are handled, one of clipModes
+	Clip string
+}
+
+func (e flacEncoder) Encode(w io.Writer, samples []float64, channels, sampleRate int) error {
+	return writeFLAC(w, samples, channels, sampleRate, e.BitsPerSample, e.Clip)
+}
+
+// writeFLAC quantizes the interleaved samples like writeWAV, with dither,
+// and writes them as FLAC. FLAC only stores integers, so 32-bit float
+// isn't supported
+func writeFLAC(w io.Writer, samples []float64, channels, sampleRate, bitsPerSample int, clip string) error {
+	switch bitsPerSample {
+	case 8, 16, 24:
+	default:
+		return fmt.Errorf("FLAC stores 8, 16 or 24-bit integers, not %d bits", bitsPerSample)
+	}
+	if channels < 1 || channels > 8 {
+		return fmt.Errorf("FLAC supports 1 to 8 channels, not %d", channels)
+	}
+	if !clipModes[clip] {
+		return fmt.Errorf("unknown clip mode %q, use clamp, soft or wrap", clip)
+	}
+
+	q := newQuantizer(bitsPerSample, clip)
+	ints := make([]int64, len(samples))
+	for i, s := range samples {
+		ints[i] = int64(q.Quantize(s))
+	}
+	frames := len(ints) / channels
+	ints = ints[:frames*channels]
+
+	// STREAMINFO keeps the MD5 of the samples, little endian
+	sum := md5.New()
+	raw := make([]byte, bitsPerSample/8)
+	for _, v := range ints {
+		for b := range raw {
+			raw[b] = byte(v >> (8 * b))
+		}
+		sum.Write(raw)
+	}
+
+	var frameData []byte
+	for start := 0; start < frames; start += flacBlockSize {
+		end := start + flacBlockSize
+		if end > frames {
+			end = frames
+		}
+		frameData = append(frameData, flacFrame(ints[start*channels:end*channels], channels, bitsPerSample, start/flacBlockSize)...)
+	}
+
+	bw := &bitWriter{}
+	bw.write(flacBlockSize, 16)
+	bw.write(flacBlockSize, 16)
+	bw.write(0, 24)
+	bw.write(0, 24)
+	bw.write(uint64(sampleRate), 20)
+	bw.write(uint64(channels-1), 3)
+	bw.write(uint64(bitsPerSample-1), 5)
+	bw.write(uint64(frames), 36)
+
+	header := []byte("fLaC")
+	// the last (and only) metadata block, STREAMINFO, 34 bytes long
+	header = append(header, 0x80, 0, 0, 34)
+	header = append(header, bw.bytes()...)
+	header = sum.Sum(header)
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(frameData)
+	return err
+}
+
+// flacFrame encodes one frame of interleaved samples, every channel coded
+// on its own
+func flacFrame(samples []int64, channels, bitsPerSample, number int) []byte {
+	blockSize := len(samples) / channels
+
+	bw := &bitWriter{}
+	bw.write(0x3FFE, 14) // sync code
+	bw.write(0, 1)       // reserved
+	bw.write(0, 1)       // fixed block size
+	bw.write(7, 4)       // block size in 16 bits after the frame number
+	bw.write(0, 4)       // sample rate from STREAMINFO
+	bw.write(uint64(channels-1), 4)
+	bw.write(map[int]uint64{8: 1, 16: 4, 24: 6}[bitsPerSample], 3)
+	bw.write(0, 1)
+	bw.writeUTF8(uint64(number))
+	bw.write(uint64(blockSize-1), 16)
+	bw.write(uint64(crc8(bw.bytes())), 8)
+
+	channel := make([]int64, blockSize)
+	for ch := 0; ch < channels; ch++ {
+		for i := range channel {
+			channel[i] = samples[i*channels+ch]
+		}
+		flacSubframe(bw, channel, bitsPerSample)
+	}
+
+	frame := bw.bytes()
+	crc := crc16(frame)
+	return append(frame, byte(crc>>8), byte(crc))
+}
+
+// flacSubframe codes a channel of the frame the smallest way among
+// constant, verbatim and the fixed predictors of order 0 to 4
+func flacSubframe(bw *bitWriter, x []int64, bps int) {
+	constant := true
+	for _, v := range x[1:] {
+		if v != x[0] {
+			constant = false
+			break
+		}
+	}
+	if constant {
+		bw.write(0, 8)
+		bw.writeSigned(x[0], bps)
+		return
+	}
+
+	bestOrder, bestBits, bestK := -1, len(x)*bps, 0
+	var bestResidual []int64
+	for order := 0; order <= 4 && order < len(x); order++ {
+		residual := fixedResidual(x, order)
+		k, bits := riceParameter(residual)
+		bits += order*bps + 10
+		if k <= flacMaxRice && bits < bestBits {
+			bestOrder, bestBits, bestK, bestResidual = order, bits, k, residual
+		}
+	}
+
+	if bestOrder < 0 {
+		bw.write(0x02, 8) // verbatim
+		for _, v := range x {
+			bw.writeSigned(v, bps)
+		}
+		return
+	}
+
+	bw.write(uint64(0x08|bestOrder)<<1, 8) // fixed predictor
+	for _, v := range x[:bestOrder] {
+		bw.writeSigned(v, bps)
+	}
+	bw.write(0, 2) // 4-bit Rice parameters
+	bw.write(0, 4) // a single partition
+	bw.write(uint64(bestK), 4)
+	for _, r := range bestResidual {
+		u := uint64(r<<1 ^ r>>63)
+		bw.writeUnary(u >> bestK)
+		bw.write(u&(1<<bestK-1), bestK)
+	}
+}
+
+// fixedResidual is what the fixed FLAC predictor of order leaves of the
+// samples after the first order ones
+func fixedResidual(x []int64, order int) []int64 {
+	residual := make([]int64, len(x)-order)
+	for i := order; i < len(x); i++ {
+		var r int64
+		switch order {
+		case 0:
+			r = x[i]
+		case 1:
+			r = x[i] - x[i-1]
+		case 2:
+			r = x[i] - 2*x[i-1] + x[i-2]
+		case 3:
+			r = x[i] - 3*x[i-1] + 3*x[i-2] - x[i-3]
+		case 4:
+			r = x[i] - 4*x[i-1] + 6*x[i-2] - 4*x[i-3] + x[i-4]
+		}
+		residual[i-order] = r
+	}
+	return residual
+}
+
+// riceParameter returns the Rice parameter coding the residual in the
+// fewest bits and that number of bits
+func riceParameter(residual []int64) (k, bits int) {
+	var sum uint64
+	for _, r := range residual {
+		sum += uint64(r<<1 ^ r>>63)
+	}
+
+	bits = math.MaxInt32
+	for p := 0; p <= flacMaxRice+1; p++ {
+		// every value takes p bits, a stop bit and its quotient in unary,
+		// the quotients adding up to about sum>>p
+		b := len(residual)*(p+1) + int(sum>>p)
+		if b < bits {
+			k, bits = p, b
+		}
+	}
+	return k, bits
+}
+
+// bitWriter packs values most significant bit first
+type bitWriter struct {
+	buf   []byte
+	acc   uint64
+	nbits uint
+}
+
+// write appends the low n bits of v
+func (bw *bitWriter) write(v uint64, n int) {
+	for n > 32 {
+		bw.write(v>>32, n-32)
+		v, n = v&0xFFFFFFFF, 32
+	}
+	bw.acc = bw.acc<<uint(n) | v&(1<<uint(n)-1)
+	bw.nbits += uint(n)
+	for bw.nbits >= 8 {
+		bw.nbits -= 8
+		bw.buf = append(bw.buf, byte(bw.acc>>bw.nbits))
+	}
+}
+
+// writeSigned appends v in two's complement on n bits
+func (bw *bitWriter) writeSigned(v int64, n int) {
+	bw.write(uint64(v), n)
+}
+
+// writeUnary appends v zeros and a one
+func (bw *bitWriter) writeUnary(v uint64) {
+	for ; v >= 32; v -= 32 {
+		bw.write(0, 32)
+	}
+	bw.write(1, int(v)+1)
+}
+
+// writeUTF8 appends v coded like a UTF-8 character, as FLAC frame numbers
+// are
+func (bw *bitWriter) writeUTF8(v uint64) {
+	if v < 0x80 {
+		bw.write(v, 8)
+		return
+	}
+
+	n := 2
+	for v >= 1<<(5*n+1) {
+		n++
+	}
+	bw.write(uint64(0xFF00>>n)&0xFF|v>>(6*(n-1)), 8)
+	for i := n - 2; i >= 0; i-- {
+		bw.write(0x80|(v>>(6*i))&0x3F, 8)
+	}
+}
+
+// bytes returns what was written, the last byte padded with zeros
+func (bw *bitWriter) bytes() []byte {
+	if bw.nbits > 0 {
+		bw.write(0, int(8-bw.nbits))
+	}
+	return bw.buf
+}
+
+// crc8 is the CRC-8 of FLAC frame headers, polynomial 0x07
+func crc8(data []byte) byte {
+	var crc byte
+	for _, b := range data {
+		crc ^= b
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x07
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// crc16 is the CRC-16 of FLAC frames, polynomial 0x8005
+func crc16(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x8005
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
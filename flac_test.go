@@ -0,0 +1,418 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// bitReader reads values most significant bit first
+type bitReader struct {
+	data []byte
+	pos  int // in bits
+}
+
+func (br *bitReader) read(n int) uint64 {
+	var v uint64
+	for i := 0; i < n; i++ {
+		bit := br.data[br.pos/8] >> (7 - br.pos%8) & 1
+		v = v<<1 | uint64(bit)
+		br.pos++
+	}
+	return v
+}
+
+func (br *bitReader) readSigned(n int) int64 {
+	v := br.read(n)
+	return int64(v<<(64-n)) >> (64 - n)
+}
+
+func (br *bitReader) readUnary() uint64 {
+	var n uint64
+	for br.read(1) == 0 {
+		n++
+	}
+	return n
+}
+
+// flacInfo is the STREAMINFO of a FLAC file
+type flacInfo struct {
+	minBlock, maxBlock     int
+	sampleRate, channels   int
+	bitsPerSample, samples int
+	md5                    []byte
+}
+
+// readFLAC decodes the FLAC files writeFLAC writes, checking the CRC of
+// every frame, and returns the interleaved samples
+func readFLAC(tb testing.TB, data []byte) (flacInfo, []int64) {
+	tb.Helper()
+	if len(data) < 42 || string(data[:4]) != "fLaC" || data[4] != 0x80 || data[7] != 34 {
+		tb.Fatalf("no STREAMINFO as the last metadata block: % x", data[:8])
+	}
+
+	br := &bitReader{data: data[8:42]}
+	info := flacInfo{
+		minBlock: int(br.read(16)),
+		maxBlock: int(br.read(16)),
+	}
+	br.read(48) // frame sizes
+	info.sampleRate = int(br.read(20))
+	info.channels = int(br.read(3)) + 1
+	info.bitsPerSample = int(br.read(5)) + 1
+	info.samples = int(br.read(36))
+	info.md5 = data[26:42]
+
+	var out []int64
+	frames := data[42:]
+	for number := 0; len(frames) > 0; number++ {
+		br := &bitReader{data: frames}
+		if sync := br.read(14); sync != 0x3FFE {
+			tb.Fatalf("frame %d: sync code %#x", number, sync)
+		}
+		br.read(2)
+		if code := br.read(4); code != 7 {
+			tb.Fatalf("frame %d: block size code %d, want 7", number, code)
+		}
+		br.read(4)
+		channels := int(br.read(4)) + 1
+		bps := map[uint64]int{1: 8, 4: 16, 6: 24}[br.read(3)]
+		br.read(1)
+		// the frame number, UTF-8 coded
+		got := br.read(8)
+		if got >= 0x80 {
+			length := 0
+			for got&(0x80>>length) != 0 {
+				length++
+			}
+			got &= 0x7F >> length
+			for i := 1; i < length; i++ {
+				got = got<<6 | br.read(8)&0x3F
+			}
+		}
+		if int(got) != number {
+			tb.Fatalf("frame %d: numbered %d", number, got)
+		}
+		blockSize := int(br.read(16)) + 1
+		if crc := byte(br.read(8)); crc != crc8(frames[:br.pos/8-1]) {
+			tb.Fatalf("frame %d: header CRC %#x, want %#x", number, crc, crc8(frames[:br.pos/8-1]))
+		}
+
+		block := make([][]int64, channels)
+		for ch := range block {
+			block[ch] = readSubframe(tb, br, blockSize, bps)
+		}
+		if br.pos%8 != 0 {
+			br.read(8 - br.pos%8)
+		}
+		end := br.pos / 8
+		if crc := uint16(br.read(16)); crc != crc16(frames[:end]) {
+			tb.Fatalf("frame %d: CRC %#x, want %#x", number, crc, crc16(frames[:end]))
+		}
+		frames = frames[end+2:]
+
+		for i := 0; i < blockSize; i++ {
+			for ch := range block {
+				out = append(out, block[ch][i])
+			}
+		}
+	}
+	return info, out
+}
+
+// readSubframe decodes one channel of a frame
+func readSubframe(tb testing.TB, br *bitReader, blockSize, bps int) []int64 {
+	tb.Helper()
+	header := br.read(8)
+	x := make([]int64, blockSize)
+	switch kind := header >> 1; {
+	case kind == 0:
+		v := br.readSigned(bps)
+		for i := range x {
+			x[i] = v
+		}
+	case kind == 1:
+		for i := range x {
+			x[i] = br.readSigned(bps)
+		}
+	case kind&0x38 == 0x08:
+		order := int(kind & 0x07)
+		for i := 0; i < order; i++ {
+			x[i] = br.readSigned(bps)
+		}
+		if method := br.read(2); method != 0 {
+			tb.Fatalf("residual coding method %d, want 4-bit Rice", method)
+		}
+		if partitions := br.read(4); partitions != 0 {
+			tb.Fatalf("partition order %d, want a single partition", partitions)
+		}
+		k := int(br.read(4))
+		for i := order; i < blockSize; i++ {
+			u := br.readUnary()<<k | br.read(k)
+			r := int64(u>>1) ^ -int64(u&1)
+			switch order {
+			case 0:
+				x[i] = r
+			case 1:
+				x[i] = r + x[i-1]
+			case 2:
+				x[i] = r + 2*x[i-1] - x[i-2]
+			case 3:
+				x[i] = r + 3*x[i-1] - 3*x[i-2] + x[i-3]
+			case 4:
+				x[i] = r + 4*x[i-1] - 6*x[i-2] + 4*x[i-3] - x[i-4]
+			}
+		}
+	default:
+		tb.Fatalf("unexpected subframe header %#x", header)
+	}
+	return x
+}
+
+func TestFLACRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	tone := func(n int) []float64 {
+		s := make([]float64, n)
+		for i := range s {
+			s[i] = 0.8 * math.Sin(τ*440*float64(i)/SampleRate)
+		}
+		return s
+	}
+	noise := func(n int) []float64 {
+		s := make([]float64, n)
+		for i := range s {
+			s[i] = 2*rng.Float64() - 1
+		}
+		return s
+	}
+
+	tests := []struct {
+		name     string
+		samples  []float64
+		channels int
+		bits     int
+	}{
+		{"silence", make([]float64, 5000), 1, 16},
+		{"one sample", []float64{0.5}, 1, 16},
+		{"tone", tone(10000), 1, 16},
+		{"stereo tone", tone(2 * 9000), 2, 16},
+		{"8-bit tone", tone(5000), 1, 8},
+		{"24-bit tone", tone(2 * 5000), 2, 24},
+		{"5.1 tone", tone(6 * 4200), 6, 16},
+		// full scale noise doesn't predict, it is stored verbatim
+		{"noise", noise(4096), 1, 16},
+		{"24-bit noise", noise(3000), 1, 24},
+		{"clipped", []float64{2, -2, 1.5, -1.5, 0, 3}, 1, 16},
+		// past frame 127 the frame numbers take two bytes
+		{"130 frames", make([]float64, 130*flacBlockSize), 1, 16},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := writeFLAC(&buf, tt.samples, tt.channels, 48000, tt.bits, "clamp"); err != nil {
+			t.Fatal(err)
+		}
+		info, got := readFLAC(t, buf.Bytes())
+
+		frames := len(tt.samples) / tt.channels
+		if info.minBlock != flacBlockSize || info.maxBlock != flacBlockSize || info.sampleRate != 48000 || info.channels != tt.channels || info.bitsPerSample != tt.bits || info.samples != frames {
+			t.Errorf("%s: STREAMINFO %+v", tt.name, info)
+		}
+
+		// the decoded samples are the dithered quantization, exactly
+		q := newQuantizer(tt.bits, "clamp")
+		if len(got) != len(tt.samples) {
+			t.Errorf("%s: decoded %d samples, want %d", tt.name, len(got), len(tt.samples))
+			continue
+		}
+		sum := md5.New()
+		raw := make([]byte, tt.bits/8)
+		for i, s := range tt.samples {
+			want := int64(q.Quantize(s))
+			if got[i] != want {
+				t.Errorf("%s: sample %d decoded as %d, want %d", tt.name, i, got[i], want)
+				break
+			}
+			for b := range raw {
+				raw[b] = byte(got[i] >> (8 * b))
+			}
+			sum.Write(raw)
+		}
+		if !bytes.Equal(sum.Sum(nil), info.md5) {
+			t.Errorf("%s: MD5 %x, the decoded samples have %x", tt.name, info.md5, sum.Sum(nil))
+		}
+	}
+}
+
+func TestFLACIsSmallerThanWAV(t *testing.T) {
+	samples := make([]float64, 2*SampleRate)
+	for i := range samples {
+		samples[i] = 0.5 * math.Sin(τ*220*float64(i/2)/SampleRate)
+	}
+
+	var flac, wav bytes.Buffer
+	if err := writeFLAC(&flac, samples, 2, SampleRate, 16, "clamp"); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeWAV(&wav, samples, 2, SampleRate, 16, "clamp"); err != nil {
+		t.Fatal(err)
+	}
+	if flac.Len() > wav.Len()/2 {
+		t.Errorf("a tone takes %d bytes as FLAC and %d as WAV, want under half", flac.Len(), wav.Len())
+	}
+}
+
+func TestWriteFLACErrors(t *testing.T) {
+	tests := []struct {
+		channels, bits int
+		clip           string
+	}{
+		{1, 32, "clamp"},
+		{1, 12, "clamp"},
+		{0, 16, "clamp"},
+		{9, 16, "clamp"},
+		{2, 16, "fold"},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := writeFLAC(&buf, make([]float64, 18), tt.channels, SampleRate, tt.bits, tt.clip); err == nil {
+			t.Errorf("writeFLAC with %d channels of %d bits, clip %s should fail", tt.channels, tt.bits, tt.clip)
+		}
+	}
+}
+
+func TestBitWriter(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(bw *bitWriter)
+		want  []byte
+	}{
+		{"byte", func(bw *bitWriter) { bw.write(0xA5, 8) }, []byte{0xA5}},
+		{"across bytes", func(bw *bitWriter) { bw.write(5, 3); bw.write(0x1FF, 9); bw.write(1, 4) }, []byte{0xBF, 0xF1}},
+		{"padded", func(bw *bitWriter) { bw.write(1, 1) }, []byte{0x80}},
+		{"high bits dropped", func(bw *bitWriter) { bw.write(0xFF, 4) }, []byte{0xF0}},
+		{"36 bits", func(bw *bitWriter) { bw.write(0x123456789, 36); bw.write(0, 4) }, []byte{0x12, 0x34, 0x56, 0x78, 0x90}},
+		{"signed", func(bw *bitWriter) { bw.writeSigned(-1, 4); bw.writeSigned(-8, 4) }, []byte{0xF8}},
+		{"unary", func(bw *bitWriter) { bw.writeUnary(3); bw.writeUnary(0); bw.writeUnary(2) }, []byte{0x19}},
+		{"long unary", func(bw *bitWriter) { bw.writeUnary(40) }, []byte{0, 0, 0, 0, 0, 0x80}},
+	}
+
+	for _, tt := range tests {
+		bw := &bitWriter{}
+		tt.write(bw)
+		if got := bw.bytes(); !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: % x, want % x", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWriteUTF8(t *testing.T) {
+	tests := []struct {
+		v    uint64
+		want []byte
+	}{
+		{0, []byte{0x00}},
+		{0x7F, []byte{0x7F}},
+		{0x80, []byte{0xC2, 0x80}},
+		{0x7FF, []byte{0xDF, 0xBF}},
+		{0x800, []byte{0xE0, 0xA0, 0x80}},
+		{0xFFFF, []byte{0xEF, 0xBF, 0xBF}},
+		{0x10000, []byte{0xF0, 0x90, 0x80, 0x80}},
+		{0x7FFFFFFF, []byte{0xFD, 0xBF, 0xBF, 0xBF, 0xBF, 0xBF}},
+	}
+
+	for _, tt := range tests {
+		bw := &bitWriter{}
+		bw.writeUTF8(tt.v)
+		if got := bw.bytes(); !bytes.Equal(got, tt.want) {
+			t.Errorf("writeUTF8(%#x) = % x, want % x", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestFLACCRCs(t *testing.T) {
+	tests := []struct {
+		data  string
+		crc8  byte
+		crc16 uint16
+	}{
+		// the standard check values of CRC-8 and CRC-16/BUYPASS
+		{"123456789", 0xF4, 0xFEE8},
+		{"", 0, 0},
+		{"\x00", 0, 0},
+	}
+
+	for _, tt := range tests {
+		if got := crc8([]byte(tt.data)); got != tt.crc8 {
+			t.Errorf("crc8(%q) = %#x, want %#x", tt.data, got, tt.crc8)
+		}
+		if got := crc16([]byte(tt.data)); got != tt.crc16 {
+			t.Errorf("crc16(%q) = %#x, want %#x", tt.data, got, tt.crc16)
+		}
+	}
+}
+
+func TestFixedResidual(t *testing.T) {
+	// a polynomial of degree order-1 leaves nothing to the predictor of
+	// that order
+	x := make([]int64, 20)
+	for i := range x {
+		n := int64(i)
+		x[i] = 3*n*n*n - 2*n*n + 5*n - 7
+	}
+
+	tests := []struct {
+		order int
+		want  func(i int) int64
+	}{
+		{0, func(i int) int64 { return x[i] }},
+		{1, func(i int) int64 { return x[i] - x[i-1] }},
+		{3, func(i int) int64 { return 18 }},
+		{4, func(i int) int64 { return 0 }},
+	}
+
+	for _, tt := range tests {
+		residual := fixedResidual(x, tt.order)
+		if len(residual) != len(x)-tt.order {
+			t.Errorf("order %d: %d residuals for %d samples", tt.order, len(residual), len(x))
+			continue
+		}
+		for i, r := range residual {
+			if want := tt.want(i + tt.order); r != want {
+				t.Errorf("order %d: residual %d is %d, want %d", tt.order, i, r, want)
+				break
+			}
+		}
+	}
+}
+
+func TestRiceParameter(t *testing.T) {
+	repeat := func(v int64, n int) []int64 {
+		r := make([]int64, n)
+		for i := range r {
+			r[i] = v
+		}
+		return r
+	}
+
+	tests := []struct {
+		name     string
+		residual []int64
+		k, bits  int
+	}{
+		{"zeros", repeat(0, 100), 0, 100},
+		// 8 zigzags to 16: 3 bits, a stop bit and 2 in unary
+		{"eights", repeat(8, 100), 3, 600},
+		{"minus eights", repeat(-8, 100), 3, 587},
+		{"large", repeat(1<<12, 10), 12, 150},
+	}
+
+	for _, tt := range tests {
+		if k, bits := riceParameter(tt.residual); k != tt.k || bits != tt.bits {
+			t.Errorf("%s: parameter %d in %d bits, want %d in %d", tt.name, k, bits, tt.k, tt.bits)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+	"unicode"
+)
+
+// melodyDurations are the note lengths a generated melody picks from,
+// quarter notes are the most common
+var melodyDurations = []string{"e", "e", "q", "q", "q", "q.", "h"}
+
+// generateMelody returns a one track song of count random notes of the
+// scale, from its root up to octaves above it
+func generateMelody(scale *Scale, count, octaves, bpm int, rng *rand.Rand) (*Song, error) {
+	keys := scaleRange(scale, octaves)
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no keys of the scale from its root up to %d octaves above it", octaves)
+	}
+
+	notes := make([]Note, count)
+	for i := range notes {
+		duration, err := noteDuration(melodyDurations[rng.Intn(len(melodyDurations))], bpm)
+		if err != nil {
+			return nil, err
+		}
+
+		notes[i] = Note{Key: keys[rng.Intn(len(keys))], Duration: duration}
+	}
+
+	return &Song{Tracks: []Track{{Notes: notes}}}, nil
+}
+
+// scaleRange returns the keys of the scale from its root up to octaves
+// above it
+func scaleRange(scale *Scale, octaves int) []int {
+	var keys []int
+	for _, key := range scale.Keys() {
+		if key >= scale.Root && key <= scale.Root+12*octaves {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// textNote is how long every character of -text plays
+const textNote = 250 * time.Millisecond
+
+// textToSong returns a one track song playing a note of scaleKeys for
+// every character of text. Letters pick the degree by their place in the
+// alphabet, ignoring case, digits by their value and any other symbol by
+// its code point, wrapping around scaleKeys. White space is a rest
+func textToSong(text string, scaleKeys []int) *Song {
+	var notes []Note
+	for _, r := range text {
+		note := Note{Duration: textNote}
+		if !unicode.IsSpace(r) && len(scaleKeys) > 0 {
+			degree := int(r)
+			switch {
+			case r >= 'a' && r <= 'z':
+				degree = int(r - 'a')
+			case r >= 'A' && r <= 'Z':
+				degree = int(r - 'A')
+			case r >= '0' && r <= '9':
+				degree = int(r - '0')
+			}
+			note.Key = scaleKeys[degree%len(scaleKeys)]
+		}
+		notes = append(notes, note)
+	}
+
+	return &Song{Tracks: []Track{{Notes: notes}}}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestGenerateMelody(t *testing.T) {
+	tests := []struct {
+		root, scale string
+		octaves     int
+	}{
+		{"C4", "major", 1},
+		{"C4", "major", 2},
+		{"A3", "minor", 1},
+		{"E4", "pentatonic", 2},
+	}
+
+	for _, tt := range tests {
+		scale, err := ParseScale(tt.root, tt.scale)
+		if err != nil {
+			t.Fatal(err)
+		}
+		inScale := map[int]bool{}
+		for _, key := range scale.Keys() {
+			inScale[key] = true
+		}
+
+		song, err := generateMelody(scale, 200, tt.octaves, 120, rand.New(rand.NewSource(7)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		notes := song.Tracks[0].Notes
+		if len(notes) != 200 {
+			t.Fatalf("%s %s: %d notes, want 200", tt.root, tt.scale, len(notes))
+		}
+		for i, note := range notes {
+			if !inScale[note.Key] || note.Key < scale.Root || note.Key > scale.Root+12*tt.octaves {
+				t.Errorf("%s %s: note %d is key %d, out of the scale or its %d octaves", tt.root, tt.scale, i, note.Key, tt.octaves)
+			}
+		}
+
+		// the same seed gives the same melody
+		again, _ := generateMelody(scale, 200, tt.octaves, 120, rand.New(rand.NewSource(7)))
+		if !reflect.DeepEqual(song, again) {
+			t.Errorf("%s %s: the melody changes with the same seed", tt.root, tt.scale)
+		}
+		other, _ := generateMelody(scale, 200, tt.octaves, 120, rand.New(rand.NewSource(8)))
+		if reflect.DeepEqual(song, other) {
+			t.Errorf("%s %s: another seed gives the same melody", tt.root, tt.scale)
+		}
+	}
+}
+
+func TestGenerateMelodyWithoutKeys(t *testing.T) {
+	scale, err := ParseScale("C4", "major")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// no octave at all leaves nothing above the root to pick from
+	for _, octaves := range []int{-1, -4} {
+		if _, err := generateMelody(scale, 4, octaves, 120, rand.New(rand.NewSource(7))); err == nil {
+			t.Errorf("%d octaves: a melody without keys to pick from", octaves)
+		}
+	}
+}
+
+func TestTextToSong(t *testing.T) {
+	// one octave of C major from C4
+	cMajor := []int{40, 42, 44, 45, 47, 49, 51}
+
+	tests := []struct {
+		text string
+		keys []int
+	}{
+		{"abc", []int{40, 42, 44}},
+		// case doesn't matter and the degrees wrap around the scale
+		{"Hello", []int{40, 47, 47, 47, 40}},
+		{"a b", []int{40, 0, 42}},
+		{"0 7 9", []int{40, 0, 40, 0, 44}},
+		{"!?", []int{49, 40}},
+	}
+
+	for _, tt := range tests {
+		song := textToSong(tt.text, cMajor)
+		var keys []int
+		for _, note := range song.Tracks[0].Notes {
+			if note.Duration != textNote {
+				t.Errorf("%q: a note lasts %v, want %v", tt.text, note.Duration, textNote)
+			}
+			keys = append(keys, note.Key)
+		}
+		if !reflect.DeepEqual(keys, tt.keys) {
+			t.Errorf("textToSong(%q) plays %v, want %v", tt.text, keys, tt.keys)
+		}
+	}
+}
+
+func TestTextToSongStaysInScale(t *testing.T) {
+	const text = "The quick brown fox jumps over the lazy dog, 1234567890 times!"
+	for _, name := range []string{"major", "minor", "pentatonic", "blues"} {
+		scale, err := ParseScale("A3", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys := scaleRange(scale, 2)
+		inScale := map[int]bool{0: true}
+		for _, key := range keys {
+			inScale[key] = true
+		}
+
+		song := textToSong(text, keys)
+		for i, note := range song.Tracks[0].Notes {
+			if !inScale[note.Key] {
+				t.Errorf("%s: character %d plays key %d, out of the scale", name, i, note.Key)
+			}
+		}
+
+		// the same text always gives the same melody
+		if again := textToSong(text, keys); !reflect.DeepEqual(again, song) {
+			t.Errorf("%s: the same text gave two melodies", name)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tecnologer/SoundOfCode/synth"
+)
+
+// Layer is one voice of an instrument. Every note triggers all the
+// layers, each with its own envelope, level and frequency Ratio to the
+// note pitch
+type Layer struct {
+	Envelope synth.Envelope
+	Level    float64
+	Ratio    float64
+	// Harmonics are the amplitudes of the fundamental and its overtones,
+	// empty is a pure sine
+	Harmonics []float64
+	// Shape is the waveform of a layer without Harmonics or Partials, one
+	// of synth.Shapes. nil is a sine
+	Shape synth.Shape
+	// Partials, when set, replace Harmonics with partials at any ratio of
+	// the note frequency, like the inharmonic ones of a bell, each with its
+	// own amplitude and decay
+	Partials []synth.Partial
+	// HarmonicDecay makes the overtones fade over the note, the partial at
+	// ratio r being scaled by exp(-HarmonicDecay*(r-1)*t) at t seconds, so
+	// the higher they are the faster they go. 0 keeps the profile constant
+	HarmonicDecay float64
+	// Inharmonicity stretches the harmonics like the stiff strings of a
+	// piano, harmonic n sounding at n*(1+Inharmonicity*n²) times the note
+	// frequency. 0 keeps them exact multiples
+	Inharmonicity float64
+	// FM phase modulates the layer with operators, each with its own
+	// envelope, nil leaves the waveform as is
+	FM synth.FM
+	// String, when set, plays the layer as a Karplus-Strong plucked string
+	// keeping that much of its level every period, see synth.NewPluck.
+	// Its waveform settings and pitch bends don't apply
+	String float64
+	// OvertoneCeiling thins out the overtones of high notes: overtones
+	// fade from half the ceiling up to the ceiling, in Hz, so bass notes
+	// keep many of them and treble notes few. 0 keeps them all
+	OvertoneCeiling float64
+}
+
+// wave returns the layer waveform at phase for a note at frequency, t
+// seconds after it started. Harmonics above Nyquist are skipped and the
+// sum is scaled so it never exceeds full scale
+func (l Layer) wave(phase, frequency, t float64) float64 {
+	count := len(l.Harmonics)
+	if len(l.Partials) > 0 {
+		count = len(l.Partials)
+	}
+
+	if count == 0 && l.Shape != nil {
+		return l.Shape(phase, frequency/SampleRate)
+	}
+	if count == 0 {
+		return math.Sin(phase)
+	}
+
+	sum, total := 0.0, 0.0
+	for i := 0; i < count; i++ {
+		ratio, amplitude := float64(i+1), 0.0
+		if len(l.Partials) > 0 {
+			ratio, amplitude = l.Partials[i].Ratio, l.Partials[i].Amplitude
+		} else {
+			amplitude = l.Harmonics[i]
+			ratio *= 1 + l.Inharmonicity*ratio*ratio
+		}
+
+		total += math.Abs(amplitude)
+		if ratio*frequency >= SampleRate/2 {
+			continue
+		}
+		if len(l.Partials) > 0 {
+			amplitude = l.Partials[i].Gain(t)
+		}
+		if l.HarmonicDecay > 0 {
+			amplitude *= math.Exp(-l.HarmonicDecay * (ratio - 1) * t)
+		}
+		if l.OvertoneCeiling > 0 && i > 0 {
+			amplitude *= registerGain(ratio*frequency, l.OvertoneCeiling)
+		}
+		sum += amplitude * math.Sin(ratio*phase)
+	}
+
+	if total == 0 {
+		return 0
+	}
+	return sum / total
+}
+
+// registerGain is 1 up to half the ceiling and falls linearly to 0 at the
+// ceiling
+func registerGain(frequency, ceiling float64) float64 {
+	return math.Max(0, math.Min(1, 2*(ceiling-frequency)/ceiling))
+}
+
+// Instrument is a set of layers summed per note
+type Instrument []Layer
+
+// presets are the instruments selectable with -instrument
+var presets = map[string]Instrument{
+	// a bright fast-decaying pluck on top of a slower sustained body
+	"pluck": {
+		{Envelope: synth.Envelope{Decay: 80 * time.Millisecond, Release: 20 * time.Millisecond}, Level: 0.5, Ratio: 2},
+		{Envelope: synth.Envelope{Attack: 40 * time.Millisecond, Decay: 250 * time.Millisecond, Sustain: 0.6, Release: 300 * time.Millisecond}, Level: 0.5, Ratio: 1},
+	},
+	// a struck bell, its inharmonic upper partials dying out first
+	"bell": {
+		{
+			Envelope: synth.Envelope{Attack: 2 * time.Millisecond, Decay: 3 * time.Second, Release: 1500 * time.Millisecond},
+			Level:    1,
+			Ratio:    1,
+			Partials: []synth.Partial{
+				{Ratio: 1, Amplitude: 1},
+				{Ratio: 2.76, Amplitude: 0.6},
+				{Ratio: 5.40, Amplitude: 0.4},
+				{Ratio: 8.93, Amplitude: 0.25},
+			},
+			HarmonicDecay: 0.5,
+		},
+	},
+	// Karplus-Strong strings, damped by the hand when released
+	"guitar": {
+		{Envelope: synth.Envelope{Sustain: 1, Release: 80 * time.Millisecond}, Level: 0.5, Ratio: 1, String: 0.996},
+	},
+	"harp": {
+		{Envelope: synth.Envelope{Sustain: 1, Release: 1500 * time.Millisecond}, Level: 0.5, Ratio: 1, String: 0.999},
+	},
+	// FM electric piano: a soft bell like body with a short metallic tine
+	// click on the attack
+	"epiano": {
+		{
+			Envelope: synth.Envelope{Attack: 2 * time.Millisecond, Decay: 1500 * time.Millisecond, Sustain: 0.2, Release: 300 * time.Millisecond},
+			Level:    0.6,
+			Ratio:    1,
+			FM: synth.FM{
+				{Ratio: 1, Index: 1.8, Envelope: synth.Envelope{Decay: 400 * time.Millisecond, Sustain: 0.1, Release: 300 * time.Millisecond}},
+				{Ratio: 14, Index: 0.4, Envelope: synth.Envelope{Decay: 50 * time.Millisecond, Release: 50 * time.Millisecond}},
+			},
+		},
+	},
+	// FM bell, a modulator at an inharmonic ratio ringing as long as the
+	// carrier
+	"fm-bell": {
+		{
+			Envelope: synth.Envelope{Attack: time.Millisecond, Decay: 4 * time.Second, Release: 2 * time.Second},
+			Level:    1,
+			Ratio:    1,
+			FM: synth.FM{
+				{Ratio: 3.5, Index: 3, Envelope: synth.Envelope{Decay: 3 * time.Second, Release: 2 * time.Second}},
+			},
+		},
+	},
+	// FM bass, a stack of two operators whose brightness falls quickly
+	// from the pluck to a round sustain
+	"fm-bass": {
+		{
+			Envelope: synth.Envelope{Attack: 2 * time.Millisecond, Decay: 300 * time.Millisecond, Sustain: 0.7, Release: 80 * time.Millisecond},
+			Level:    0.5,
+			Ratio:    1,
+			FM: synth.FM{
+				{Ratio: 1, Index: 2.5, Envelope: synth.Envelope{Decay: 150 * time.Millisecond, Sustain: 0.3, Release: 80 * time.Millisecond}},
+				{Ratio: 1, Index: 0.8, Envelope: synth.Envelope{Decay: 100 * time.Millisecond, Release: 80 * time.Millisecond}, Into: 1},
+			},
+		},
+	},
+}
+
+// instrument returns the preset by name, an empty name is a single layer
+// using env
+func instrument(name string, env synth.Envelope) (Instrument, error) {
+	if name == "" {
+		return Instrument{{Envelope: env, Level: 1, Ratio: 1}}, nil
+	}
+
+	in, ok := presets[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown instrument %q", name)
+	}
+	return in, nil
+}
+
+// Release is the longest release of the layers
+func (in Instrument) Release() time.Duration {
+	var release time.Duration
+	for _, l := range in {
+		if l.Envelope.Release > release {
+			release = l.Envelope.Release
+		}
+	}
+	return release
+}
+
+// parseHarmonics parses a comma separated list of harmonic amplitudes,
+// starting with the fundamental, e.g. "1,0,0.5,0,0.25"
+func parseHarmonics(list string) ([]float64, error) {
+	var harmonics []float64
+	for _, item := range strings.Split(list, ",") {
+		amplitude, err := strconv.ParseFloat(strings.TrimSpace(item), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid harmonic amplitude %q", item)
+		}
+		harmonics = append(harmonics, amplitude)
+	}
+	return harmonics, nil
+}
+
+// WithHarmonics returns a copy of the instrument with every layer using
+// the harmonic profile
+func (in Instrument) WithHarmonics(harmonics []float64) Instrument {
+	out := make(Instrument, len(in))
+	for i, l := range in {
+		l.Harmonics, l.Partials = harmonics, nil
+		out[i] = l
+	}
+	return out
+}
+
+// WithHarmonicDecay returns a copy of the instrument with every layer
+// fading its overtones at slope
+func (in Instrument) WithHarmonicDecay(slope float64) Instrument {
+	out := make(Instrument, len(in))
+	for i, l := range in {
+		l.HarmonicDecay = slope
+		out[i] = l
+	}
+	return out
+}
+
+// WithOvertoneCeiling returns a copy of the instrument with every layer
+// thinning out its overtones toward ceiling
+func (in Instrument) WithOvertoneCeiling(ceiling float64) Instrument {
+	out := make(Instrument, len(in))
+	for i, l := range in {
+		l.OvertoneCeiling = ceiling
+		out[i] = l
+	}
+	return out
+}
+
+// WithInharmonicity returns a copy of the instrument with every layer
+// stretching its harmonics by b
+func (in Instrument) WithInharmonicity(b float64) Instrument {
+	out := make(Instrument, len(in))
+	for i, l := range in {
+		l.Inharmonicity = b
+		out[i] = l
+	}
+	return out
+}
+
+// WithShape returns a copy of the instrument with every layer playing the
+// waveform shape
+func (in Instrument) WithShape(shape synth.Shape) Instrument {
+	out := make(Instrument, len(in))
+	for i, l := range in {
+		l.Shape = shape
+		out[i] = l
+	}
+	return out
+}
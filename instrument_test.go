@@ -0,0 +1,422 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/tecnologer/SoundOfCode/synth"
+)
+
+// partialLevel is the amplitude of the sine at frequency in the samples
+// from..from+n
+func partialLevel(samples []float64, frequency float64, from, n int) float64 {
+	var re, im float64
+	for i := from; i < from+n; i++ {
+		angle := τ * frequency * float64(i) / SampleRate
+		re += samples[i] * math.Cos(angle)
+		im += samples[i] * math.Sin(angle)
+	}
+	return 2 * math.Hypot(re, im) / float64(n)
+}
+
+func TestPluckHasTwoOnsets(t *testing.T) {
+	in, err := instrument("pluck", synth.Envelope{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	spans, total := scheduleScore(t, "A4:h", in)
+	out := make([]float64, total)
+	renderChannel(spans, in, 0, out, 1, 1, 0, nil)
+
+	// the transient plays an octave up and the body on the note, follow
+	// each one 5ms at a time
+	window := SampleRate / 200
+	peak := func(frequency float64) (at int, level float64) {
+		for w := 0; (w+1)*window < total; w++ {
+			if l := partialLevel(out, frequency, w*window, window); l > level {
+				at, level = w*window, l
+			}
+		}
+		return at, level
+	}
+
+	transient, transientLevel := peak(880)
+	body, bodyLevel := peak(440)
+
+	if ms := transient * 1000 / SampleRate; ms > 5 {
+		t.Errorf("the transient peaks after %dms, want right away", ms)
+	}
+	if ms := body * 1000 / SampleRate; ms < 30 || ms > 50 {
+		t.Errorf("the body peaks after %dms, want about 40ms", ms)
+	}
+
+	// the transient is gone long before the body
+	late := int(0.2 * SampleRate)
+	if l := partialLevel(out, 880, late, window); l > transientLevel/10 {
+		t.Errorf("the transient is still at %.3f after 200ms, it peaked at %.3f", l, transientLevel)
+	}
+	if l := partialLevel(out, 440, late, window); l < bodyLevel/2 {
+		t.Errorf("the body fell to %.3f after 200ms, it peaked at %.3f", l, bodyLevel)
+	}
+}
+
+// layerTone renders 8192 samples of the layer at frequency, from start
+// seconds into the note
+func layerTone(l Layer, frequency, start float64) []float64 {
+	samples := make([]float64, 8192)
+	for i := range samples {
+		t := start + float64(i)/SampleRate
+		samples[i] = l.wave(τ*frequency*t, frequency, t)
+	}
+	return samples
+}
+
+func TestHarmonicProfile(t *testing.T) {
+	// 32 bins up in a 8192 sample spectrum
+	const bin = 32
+	frequency := bin * SampleRate / 8192.0
+
+	tests := []string{
+		"1,0,0.5,0,0.25",
+		"1,1,1",
+		"0.5,0.25",
+		"1, 0.3, 0.2, 0.1",
+	}
+
+	for _, profile := range tests {
+		harmonics, err := parseHarmonics(profile)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		samples := layerTone(Layer{Level: 1, Ratio: 1, Harmonics: harmonics}, frequency, 0)
+		magnitudes := spectrum(samples)
+		for h, amplitude := range harmonics {
+			got := magnitudes[(h+1)*bin] / magnitudes[bin]
+			if want := amplitude / harmonics[0]; math.Abs(got-want) > 0.01 {
+				t.Errorf("%q: harmonic %d at %.3f of the fundamental, want %.3f", profile, h+1, got, want)
+			}
+		}
+
+		// the sum is scaled to never clip
+		for i, s := range samples {
+			if math.Abs(s) > 1 {
+				t.Fatalf("%q: sample %d at %v", profile, i, s)
+			}
+		}
+	}
+}
+
+func TestHarmonicProfileSkipsAboveNyquist(t *testing.T) {
+	// the 3rd harmonic of 8kHz is past Nyquist, only 2 of the 3 are heard
+	l := Layer{Level: 1, Ratio: 1, Harmonics: []float64{1, 1, 1}}
+	samples := layerTone(l, 8000, 0)
+
+	peak := 0.0
+	for _, s := range samples {
+		peak = math.Max(peak, math.Abs(s))
+	}
+	if peak > 2.0/3+1e-9 {
+		t.Errorf("peaks at %.3f, only 2 of 3 equal harmonics should sound", peak)
+	}
+}
+
+func TestParseHarmonicsInvalid(t *testing.T) {
+	for _, list := range []string{"", "1,,2", "1,x"} {
+		if _, err := parseHarmonics(list); err == nil {
+			t.Errorf("parseHarmonics(%q) should fail", list)
+		}
+	}
+}
+
+func TestHarmonicDecay(t *testing.T) {
+	const bin = 32
+	frequency := bin * SampleRate / 8192.0
+
+	for _, slope := range []float64{0.5, 1, 2} {
+		l := Layer{Level: 1, Ratio: 1, Harmonics: []float64{1, 1, 1, 1, 1, 1, 1, 1}, HarmonicDecay: slope}
+		start := spectrum(layerTone(l, frequency, 0))
+		end := spectrum(layerTone(l, frequency, 0.5))
+
+		// half a second later every harmonic keeps less of its level than the one under it,
+		// the fundamental keeps it all
+		previous := end[bin] / start[bin]
+		if math.Abs(previous-1) > 1e-6 {
+			t.Errorf("slope %g: the fundamental went from %.4f to %.4f", slope, start[bin], end[bin])
+		}
+		for h := 2; h <= 8; h++ {
+			kept := end[h*bin] / start[h*bin]
+			if want := math.Exp(-slope * float64(h-1) * 0.5); math.Abs(kept-want) > want*0.01 || kept >= previous {
+				t.Errorf("slope %g: harmonic %d keeps %.4f of its level, harmonic %d %.4f", slope, h, kept, h-1, previous)
+			}
+			previous = kept
+		}
+	}
+
+	// without a slope the profile stays put
+	l := Layer{Level: 1, Ratio: 1, Harmonics: []float64{1, 0.5, 0.25}}
+	start, end := spectrum(layerTone(l, frequency, 0)), spectrum(layerTone(l, frequency, 1))
+	for h := 1; h <= 3; h++ {
+		if math.Abs(end[h*bin]/start[h*bin]-1) > 1e-6 {
+			t.Errorf("no slope: harmonic %d went from %.4f to %.4f", h, start[h*bin], end[h*bin])
+		}
+	}
+}
+
+func TestRegisterGain(t *testing.T) {
+	tests := []struct {
+		frequency, ceiling, want float64
+	}{
+		{100, 4000, 1},
+		{2000, 4000, 1},
+		{3000, 4000, 0.5},
+		{3600, 4000, 0.2},
+		{4000, 4000, 0},
+		{9000, 4000, 0},
+	}
+
+	for _, tt := range tests {
+		if got := registerGain(tt.frequency, tt.ceiling); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("registerGain(%g, %g) = %g, want %g", tt.frequency, tt.ceiling, got, tt.want)
+		}
+	}
+}
+
+func TestOvertoneCeiling(t *testing.T) {
+	harmonics := make([]float64, 16)
+	for i := range harmonics {
+		harmonics[i] = 1
+	}
+
+	tests := []struct {
+		bin     int
+		ceiling float64
+		heard   int
+	}{
+		// 43Hz keeps all 16 under a 5kHz ceiling, 689Hz the 7 under it
+		{8, 5000, 16},
+		{128, 5000, 7},
+		{128, 10000, 14},
+		{128, 0, 16},
+	}
+
+	for _, tt := range tests {
+		frequency := float64(tt.bin) * SampleRate / 8192.0
+		l := Layer{Level: 1, Ratio: 1, Harmonics: harmonics, OvertoneCeiling: tt.ceiling}
+		magnitudes := spectrum(layerTone(l, frequency, 0))
+
+		heard := 0
+		for h := 1; h <= len(harmonics); h++ {
+			level := magnitudes[h*tt.bin] / magnitudes[tt.bin]
+			want := 1.0
+			if tt.ceiling > 0 && h > 1 {
+				want = registerGain(float64(h)*frequency, tt.ceiling)
+			}
+			if math.Abs(level-want) > 0.01 {
+				t.Errorf("%.0fHz, ceiling %g: harmonic %d at %.3f of the fundamental, want %.3f", frequency, tt.ceiling, h, level, want)
+			}
+			if level > 0.01 {
+				heard++
+			}
+		}
+		if heard != tt.heard {
+			t.Errorf("%.0fHz, ceiling %g: %d harmonics heard, want %d", frequency, tt.ceiling, heard, tt.heard)
+		}
+	}
+}
+
+func TestBellPartials(t *testing.T) {
+	in, err := instrument("bell", synth.Envelope{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// with the fundamental on bin 100 every bell ratio lands on a bin too.
+	// The overtones fading would lower them over the window, TestHarmonicDecay
+	// covers that
+	const bin = 100
+	frequency := bin * SampleRate / 8192.0
+	bell := in[0]
+	bell.HarmonicDecay = 0
+	magnitudes := spectrum(layerTone(bell, frequency, 0))
+
+	tests := []struct {
+		ratio, level float64
+	}{
+		{1, 1},
+		{2.76, 0.6},
+		{5.40, 0.4},
+		{8.93, 0.25},
+	}
+	for _, tt := range tests {
+		got := magnitudes[int(math.Round(tt.ratio*bin))] / magnitudes[bin]
+		if math.Abs(got-tt.level) > 0.01 {
+			t.Errorf("partial at %gx is %.3f of the fundamental, want %.3f", tt.ratio, got, tt.level)
+		}
+	}
+
+	// nothing at the integer multiples a harmonic tone would have
+	for h := 2; h <= 9; h++ {
+		if got := magnitudes[h*bin] / magnitudes[bin]; got > 0.001 {
+			t.Errorf("harmonic %d is %.4f of the fundamental, a bell has none", h, got)
+		}
+	}
+}
+
+func TestBellPartialsFadeByRatio(t *testing.T) {
+	in, err := instrument("bell", synth.Envelope{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the higher the partial the faster it fades, by its ratio rather than
+	// its place in the list
+	const bin = 100
+	frequency := bin * SampleRate / 8192.0
+	start := spectrum(layerTone(in[0], frequency, 0))
+	end := spectrum(layerTone(in[0], frequency, 0.5))
+	for _, p := range in[0].Partials {
+		b := int(math.Round(p.Ratio * bin))
+		kept := end[b] / start[b]
+		if want := math.Exp(-in[0].HarmonicDecay * (p.Ratio - 1) * 0.5); math.Abs(kept-want) > want*0.01 {
+			t.Errorf("partial at %gx keeps %.4f of its level after 0.5s, want %.4f", p.Ratio, kept, want)
+		}
+	}
+}
+
+func TestInharmonicity(t *testing.T) {
+	const frequency = 220.0
+	harmonics := []float64{1, 1, 1, 1, 1, 1}
+
+	for _, b := range []float64{0, 0.0005, 0.002} {
+		l := Layer{Level: 1, Ratio: 1, Harmonics: harmonics, Inharmonicity: b}
+		samples := make([]float64, SampleRate)
+		for i := range samples {
+			t := float64(i) / SampleRate
+			samples[i] = l.wave(τ*frequency*t, frequency, t)
+		}
+
+		// harmonic n sounds at n*(1+b*n²) times the fundamental, sharper
+		// the higher it is, and no longer at the exact multiple
+		for n := 1; n <= len(harmonics); n++ {
+			exact := float64(n) * frequency
+			stretched := exact * (1 + b*float64(n*n))
+			if got := partialLevel(samples, stretched, 0, len(samples)); math.Abs(got-1.0/6) > 0.005 {
+				t.Errorf("b=%g: harmonic %d at %.2fHz has level %.4f, want %.4f", b, n, stretched, got, 1.0/6)
+			}
+			if stretched-exact > 5 {
+				if got := partialLevel(samples, exact, 0, len(samples)); got > 0.02 {
+					t.Errorf("b=%g: harmonic %d still has level %.4f at the exact multiple %.2fHz", b, n, got, exact)
+				}
+			}
+		}
+	}
+}
+
+func TestInharmonicityLeavesPartials(t *testing.T) {
+	bell := presets["bell"].WithInharmonicity(0.01)
+	plain := presets["bell"]
+	for i := 0; i < 1000; i++ {
+		t0 := float64(i) / SampleRate
+		if a, b := bell[0].wave(τ*440*t0, 440, t0), plain[0].wave(τ*440*t0, 440, t0); a != b {
+			t.Fatalf("sample %d of the bell is %v with inharmonicity, %v without", i, a, b)
+		}
+	}
+}
+
+func TestFMPresets(t *testing.T) {
+	tests := []struct {
+		name string
+		// sideband is the ratio of a sideband the operators put on the note
+		sideband float64
+	}{
+		{"epiano", 2},
+		{"fm-bell", 2.5},
+		{"fm-bass", 2},
+	}
+
+	for _, tt := range tests {
+		in, err := instrument(tt.name, synth.Envelope{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		spans, total := scheduleScore(t, "A4:w", in)
+		out := make([]float64, total)
+		renderChannel(spans, in, 0, out, 1, 1, 0, nil)
+
+		// the sideband against the note, 100ms at a time
+		brightness := func(at float64) float64 {
+			from := int(at * SampleRate)
+			return partialLevel(out, 440*tt.sideband, from, 4410) / partialLevel(out, 440, from, 4410)
+		}
+		if early, late := brightness(0.01), brightness(1.5); early < 1 || late > early/2 {
+			t.Errorf("%s: sideband at %.2f of the note on the attack and %.2f later, want it bright then fading", tt.name, early, late)
+		}
+	}
+
+	// the bell's operator at 3.5 puts its sidebands between the harmonics
+	in, _ := instrument("fm-bell", synth.Envelope{})
+	spans, total := scheduleScore(t, "A4:w", in)
+	out := make([]float64, total)
+	renderChannel(spans, in, 0, out, 1, 1, 0, nil)
+	if level := partialLevel(out, 880, 4410, 4410) / partialLevel(out, 1100, 4410, 4410); level > 0.01 {
+		t.Errorf("fm-bell has a second harmonic at %.3f of its 2.5 sideband, want none", level)
+	}
+}
+
+func TestPartialsDecay(t *testing.T) {
+	// with the fundamental on bin 100 every partial lands on a bin
+	const bin = 100
+	frequency := bin * SampleRate / 8192.0
+	partials := []synth.Partial{{Ratio: 1, Amplitude: 1}, {Ratio: 2.5, Amplitude: 0.5, Decay: 3}, {Ratio: 4, Amplitude: 0.3, Decay: 1}}
+
+	// each partial fades at its own rate, on top of HarmonicDecay
+	for _, harmonicDecay := range []float64{0, 1} {
+		l := Layer{Level: 1, Ratio: 1, Partials: partials, HarmonicDecay: harmonicDecay}
+		start := spectrum(layerTone(l, frequency, 0))
+		end := spectrum(layerTone(l, frequency, 0.5))
+		for _, p := range partials {
+			b := int(math.Round(p.Ratio * bin))
+			kept := end[b] / start[b]
+			if want := math.Exp(-(p.Decay + harmonicDecay*(p.Ratio-1)) * 0.5); math.Abs(kept-want) > want*0.01 {
+				t.Errorf("harmonic decay %g: partial at %gx keeps %.4f of its level after 0.5s, want %.4f", harmonicDecay, p.Ratio, kept, want)
+			}
+		}
+	}
+}
+
+func TestStringPresets(t *testing.T) {
+	tests := []struct {
+		name string
+		// rings is whether the string still sounds 200ms after the note
+		// ends
+		rings bool
+	}{
+		{"guitar", false},
+		{"harp", true},
+	}
+
+	for _, tt := range tests {
+		in, err := instrument(tt.name, synth.Envelope{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		spans, total := scheduleScore(t, "A4:q R:h", in)
+		out := make([]float64, total)
+		renderChannel(spans, in, 0, out, 1, 1, 0, nil)
+
+		// the string plays the scheduled key, not its neighbours
+		held := partialLevel(out, 440, 5512, 11025)
+		for _, neighbour := range []float64{415.30, 466.16} {
+			if l := partialLevel(out, neighbour, 5512, 11025); l > held/10 {
+				t.Errorf("%s: %.2f Hz at %.4f against %.4f at 440 Hz, want the string on A4", tt.name, neighbour, l, held)
+			}
+		}
+
+		// the hand damps the guitar on release, the harp rings on
+		after := partialLevel(out, 440, 22050+8820, 11025)
+		if rings := after > held/4; rings != tt.rings {
+			t.Errorf("%s: at %.4f 200ms after the note, %.4f while held, want ringing %v", tt.name, after, held, tt.rings)
+		}
+	}
+}
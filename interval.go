@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/tecnologer/SoundOfCode/synth"
+)
+
+// intervals are the semitones spanned by each interval name, up to a
+// twelfth
+var intervals = map[string]int{
+	"P1": 0, "m2": 1, "M2": 2, "m3": 3, "M3": 4, "P4": 5,
+	"A4": 6, "d5": 6, "TT": 6, "P5": 7, "m6": 8, "M6": 9,
+	"m7": 10, "M7": 11, "P8": 12, "m9": 13, "M9": 14, "m10": 15,
+	"M10": 16, "P11": 17, "P12": 19,
+}
+
+// IntervalSemitones returns the semitones of an interval name like P5,
+// M3 or m7
+func IntervalSemitones(name string) (int, error) {
+	semitones, ok := intervals[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown interval %q", name)
+	}
+	return semitones, nil
+}
+
+// intervalSong plays the root, then the note the interval above it and
+// then both together, each for duration. It describes the interval to w
+func intervalSong(w io.Writer, root int, name string, duration time.Duration) (*Song, error) {
+	semitones, err := IntervalSemitones(name)
+	if err != nil {
+		return nil, err
+	}
+
+	top := root + semitones
+	if top > synth.TotalKeys {
+		return nil, fmt.Errorf("%s above %s is out of the piano range", name, synth.KeyName(root))
+	}
+
+	fmt.Fprintf(w, "%s: %s (%.2f Hz) to %s (%.2f Hz), %d semitones\n",
+		name, synth.KeyName(root), tuning.Frequency(root), synth.KeyName(top), tuning.Frequency(top), semitones)
+
+	return &Song{Tracks: []Track{
+		{Notes: []Note{{Key: root, Duration: duration}, {Duration: duration}, {Key: root, Duration: duration}}},
+		{Notes: []Note{{Duration: duration}, {Key: top, Duration: duration}, {Key: top, Duration: duration}}},
+	}}, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tecnologer/SoundOfCode/synth"
+)
+
+func TestIntervalSemitones(t *testing.T) {
+	tests := []struct {
+		name      string
+		semitones int
+		ratio     float64
+	}{
+		{"P1", 0, 1},
+		{"m3", 3, 1.1892},
+		{"M3", 4, 1.2599},
+		{"P4", 5, 1.3348},
+		{"TT", 6, 1.4142},
+		{"P5", 7, 1.4983},
+		{"m7", 10, 1.7818},
+		{"P8", 12, 2},
+		{"P12", 19, 2.9966},
+	}
+
+	for _, tt := range tests {
+		semitones, err := IntervalSemitones(tt.name)
+		if err != nil {
+			t.Errorf("IntervalSemitones(%q): %v", tt.name, err)
+			continue
+		}
+		if semitones != tt.semitones {
+			t.Errorf("IntervalSemitones(%q) = %d, want %d", tt.name, semitones, tt.semitones)
+		}
+
+		// the two notes played are that far apart in frequency
+		song, err := intervalSong(&bytes.Buffer{}, 40, tt.name, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		root, top := song.Tracks[0].Notes[0].Key, song.Tracks[1].Notes[1].Key
+		if ratio := tuning.Frequency(top) / tuning.Frequency(root); math.Abs(ratio-tt.ratio) > 1e-4 {
+			t.Errorf("%s: frequency ratio %.4f, want %.4f", tt.name, ratio, tt.ratio)
+		}
+	}
+
+	for _, name := range []string{"", "p5", "M5", "P13", "5"} {
+		if _, err := IntervalSemitones(name); err == nil {
+			t.Errorf("IntervalSemitones(%q) should fail", name)
+		}
+	}
+}
+
+func TestIntervalSong(t *testing.T) {
+	var b bytes.Buffer
+	song, err := intervalSong(&b, 49, "M3", 500*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "M3: A4 (440.00 Hz) to C#5 (554.37 Hz), 4 semitones\n"; b.String() != want {
+		t.Errorf("printed %q, want %q", b.String(), want)
+	}
+
+	// the root, then the top note, then both together
+	var played []string
+	for step := 0; step < 3; step++ {
+		var keys []string
+		for _, track := range song.Tracks {
+			if note := track.Notes[step]; note.Key > 0 {
+				keys = append(keys, synth.KeyName(note.Key))
+			}
+			if d := track.Notes[step].Duration; d != 500*time.Millisecond {
+				t.Errorf("step %d lasts %v, want 500ms", step, d)
+			}
+		}
+		played = append(played, strings.Join(keys, "+"))
+	}
+	if got := strings.Join(played, " "); got != "A4 C#5 A4+C#5" {
+		t.Errorf("played %s, want A4 C#5 A4+C#5", got)
+	}
+
+	if _, err := intervalSong(&b, 85, "P5", time.Second); err == nil {
+		t.Error("a fifth above key 85 should be out of range")
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import "math"
+
+// loopMatchWindow is how many samples after each loop point are compared
+// to choose the best loop end
+const loopMatchWindow = 64
+
+// findLoopPoints picks a loop from the first rising zero crossing to the
+// rising zero crossing within a quarter of hint samples from hint whose
+// following waveform best matches the one at the start
+func findLoopPoints(samples []float64, hint int) (start, end int) {
+	crossings := risingZeroCrossings(samples)
+	if len(crossings) < 2 {
+		return 0, len(samples)
+	}
+
+	start = crossings[0]
+	end = len(samples)
+	best := math.Inf(1)
+	for _, c := range crossings[1:] {
+		if abs(c-hint) > hint/4 {
+			continue
+		}
+
+		diff := 0.0
+		for i := 0; i < loopMatchWindow && c+i < len(samples); i++ {
+			d := samples[start+i] - samples[c+i]
+			diff += d * d
+		}
+
+		if diff < best {
+			best, end = diff, c
+		}
+	}
+
+	return start, end
+}
+
+// risingZeroCrossings returns the indexes where the signal goes from
+// negative to zero or positive
+func risingZeroCrossings(samples []float64) []int {
+	var crossings []int
+	for i := 1; i < len(samples); i++ {
+		if samples[i-1] < 0 && samples[i] >= 0 {
+			crossings = append(crossings, i)
+		}
+	}
+	return crossings
+}
+
+// crossfadeLoop fades the length samples before end into the samples
+// before start, so jumping from end back to start continues seamlessly
+func crossfadeLoop(samples []float64, start, end, length int) {
+	if length > start {
+		length = start
+	}
+
+	for i := 0; i < length; i++ {
+		// equal power fade from the loop tail to the audio before start
+		x := float64(i) / float64(length) * π / 2
+		tail := end - length + i
+		samples[tail] = samples[tail]*math.Cos(x) + samples[start-length+i]*math.Sin(x)
+	}
+}
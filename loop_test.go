@@ -0,0 +1,86 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// maxStep is the largest jump between consecutive samples
+func maxStep(samples []float64) float64 {
+	step := 0.0
+	for i := 1; i < len(samples); i++ {
+		step = math.Max(step, math.Abs(samples[i]-samples[i-1]))
+	}
+	return step
+}
+
+func TestFindLoopPoints(t *testing.T) {
+	tests := []struct {
+		frequency float64
+		hint      int
+	}{
+		{440, 10000},
+		{330.7, 10000},
+		{97.1, 20000},
+		{1234.5, 5000},
+	}
+
+	for _, tt := range tests {
+		// a periodic tone, with a phase offset and an overtone so its zero
+		// crossings aren't on whole samples
+		samples := make([]float64, 3*tt.hint)
+		for i := range samples {
+			p := τ * tt.frequency * float64(i) / SampleRate
+			samples[i] = 0.6*math.Sin(p+1) + 0.3*math.Sin(2*p+0.5)
+		}
+
+		start, end := findLoopPoints(samples, tt.hint)
+		if end-start < tt.hint*3/4 || end-start > tt.hint*5/4 {
+			t.Errorf("%gHz: loop from %d to %d, hint %d", tt.frequency, start, end, tt.hint)
+		}
+
+		// the loop is a whole number of periods, give or take a sample
+		period := SampleRate / tt.frequency
+		periods := float64(end-start) / period
+		if off := math.Abs(periods-math.Round(periods)) * period; off > 1 {
+			t.Errorf("%gHz: the loop is %.3f periods, %.2f samples from a whole number", tt.frequency, periods, off)
+		}
+
+		if samples[start-1] >= 0 || samples[start] < 0 || samples[end-1] >= 0 || samples[end] < 0 {
+			t.Errorf("%gHz: %d and %d aren't rising zero crossings", tt.frequency, start, end)
+		}
+	}
+}
+
+func TestFindLoopPointsWithoutCrossings(t *testing.T) {
+	samples := []float64{0.5, 0.4, 0.3, 0.2}
+	if start, end := findLoopPoints(samples, 2); start != 0 || end != len(samples) {
+		t.Errorf("loop from %d to %d, want the whole buffer", start, end)
+	}
+}
+
+func TestCrossfadeLoop(t *testing.T) {
+	// a tone that changes over time, so without a crossfade the end of the
+	// loop doesn't match its start
+	samples := make([]float64, SampleRate)
+	for i := range samples {
+		p := τ * 220 * float64(i) / SampleRate
+		bright := float64(i) / SampleRate
+		samples[i] = (1-bright)*math.Sin(p) + bright*math.Sin(3*p)
+	}
+	step := maxStep(samples)
+
+	start, end := findLoopPoints(samples, SampleRate/2)
+	length := 2000
+	crossfadeLoop(samples, start, end, length)
+
+	// playing end-1 then start sounds like playing start-1 then start,
+	// the fade is all but done at the last sample
+	if d := math.Abs(samples[end-1] - samples[start-1]); d > step/10 {
+		t.Errorf("the loop ends %.2e away from the sample before its start", d)
+	}
+	loop := append(append([]float64(nil), samples[end-length-10:end]...), samples[start:start+10]...)
+	if s := maxStep(loop); s > step*1.01 {
+		t.Errorf("the seam jumps %.4f, the signal at most %.4f", s, step)
+	}
+}
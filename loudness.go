@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	// lufsBlock and lufsStep are the gating block length and hop in seconds
+	lufsBlock = 0.4
+	lufsStep  = 0.1
+	// lufsAbsoluteGate drops blocks quieter than this, in LUFS
+	lufsAbsoluteGate = -70.0
+	// lufsRelativeGate drops blocks this many LU below the ungated level
+	lufsRelativeGate = -10.0
+)
+
+// loudnessWeights are the BS.1770 channel weights of the layouts that
+// don't weigh every channel the same: the rear channels count 1.41 times
+// and the LFE not at all
+var loudnessWeights = map[int][]float64{
+	4: {1, 1, 1.41, 1.41},
+	6: {1, 1, 1, 0, 1.41, 1.41},
+}
+
+// measureLUFS returns the integrated loudness of the channels following a
+// simplified ITU-R BS.1770: K-weighting, 400ms blocks whose power is
+// summed over the weighted channels, and two gates
+func measureLUFS(channels [][]float64, sampleRate int) float64 {
+	if len(channels) == 0 {
+		return math.Inf(-1)
+	}
+
+	n := len(channels[0])
+	block := int(lufsBlock * float64(sampleRate))
+	step := int(lufsStep * float64(sampleRate))
+	if n < block {
+		block = n
+	}
+
+	var powers []float64
+	if block > 0 {
+		powers = make([]float64, (n-block)/step+1)
+	}
+	for ch, samples := range channels {
+		weight := 1.0
+		if weights, ok := loudnessWeights[len(channels)]; ok {
+			weight = weights[ch]
+		}
+		if weight == 0 {
+			continue
+		}
+
+		shelf := newHighShelf(1500, 4, 1/math.Sqrt2, sampleRate)
+		highPass := newHighPass(38, 0.5, sampleRate)
+		weighted := make([]float64, len(samples))
+		for i, s := range samples {
+			weighted[i] = highPass.Process(shelf.Process(s))
+		}
+
+		for b := range powers {
+			sum := 0.0
+			for _, s := range weighted[b*step : b*step+block] {
+				sum += s * s
+			}
+			powers[b] += weight * sum / float64(block)
+		}
+	}
+
+	absolute := gatedMean(powers, lufsPower(lufsAbsoluteGate))
+	if absolute == 0 {
+		return math.Inf(-1)
+	}
+
+	relative := lufsPower(powerLUFS(absolute) + lufsRelativeGate)
+	return powerLUFS(gatedMean(powers, math.Max(relative, lufsPower(lufsAbsoluteGate))))
+}
+
+// normalizeLoudness scales all the channels in place so together they
+// reach target LUFS
+func normalizeLoudness(channels [][]float64, target float64) {
+	measured := measureLUFS(channels, SampleRate)
+	if math.IsInf(measured, -1) {
+		return
+	}
+
+	gain := math.Pow(10, (target-measured)/20)
+	for _, samples := range channels {
+		for i := range samples {
+			samples[i] *= gain
+		}
+	}
+}
+
+// gatedMean is the mean of the powers above the gate
+func gatedMean(powers []float64, gate float64) float64 {
+	sum, n := 0.0, 0
+	for _, p := range powers {
+		if p > gate {
+			sum += p
+			n++
+		}
+	}
+
+	if n == 0 {
+		return 0
+	}
+	return sum / float64(n)
+}
+
+func powerLUFS(power float64) float64 {
+	return -0.691 + 10*math.Log10(power)
+}
+
+func lufsPower(lufs float64) float64 {
+	return math.Pow(10, (lufs+0.691)/10)
+}
+
+// replayGainReference is the ReplayGain 2.0 reference loudness in LUFS
+const replayGainReference = -18.0
+
+// computeReplayGain returns the ReplayGain track gain in dB of the
+// channels: how much to turn it up (positive) or down (negative) to play at
+// the reference loudness. Silence gets no gain
+func computeReplayGain(channels [][]float64, sampleRate int) float64 {
+	loudness := measureLUFS(channels, sampleRate)
+	if math.IsInf(loudness, -1) {
+		return 0
+	}
+	return replayGainReference - loudness
+}
+
+// writeReplayGain writes the track gain and peak of the channels as
+// REPLAYGAIN_* tags, one per line
+func writeReplayGain(w io.Writer, channels [][]float64) error {
+	peak := 0.0
+	for _, samples := range channels {
+		for _, s := range samples {
+			peak = math.Max(peak, math.Abs(s))
+		}
+	}
+
+	gain := computeReplayGain(channels, SampleRate)
+	_, err := fmt.Fprintf(w, "REPLAYGAIN_TRACK_GAIN=%.2f dB\nREPLAYGAIN_TRACK_PEAK=%.6f\n", gain, peak)
+	return err
+}
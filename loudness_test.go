@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"testing"
+)
+
+// tone returns length seconds of a sine at frequency with the amplitude
+func tone(frequency, amplitude, length float64) []float64 {
+	samples := make([]float64, int(length*SampleRate))
+	for i := range samples {
+		samples[i] = amplitude * math.Sin(τ*frequency*float64(i)/SampleRate)
+	}
+	return samples
+}
+
+// gainDB is the level in dB of out relative to in, skipping the first
+// skip samples while the filters settle
+func gainDB(in, out []float64, skip int) float64 {
+	var a, b float64
+	for i := skip; i < len(in); i++ {
+		a += in[i] * in[i]
+		b += out[i] * out[i]
+	}
+	return 10 * math.Log10(b/a)
+}
+
+func TestKWeighting(t *testing.T) {
+	// the BS.1770 pre-filter: a +4dB shelf over the highs and a high pass
+	// under 38Hz, flat through the mids
+	tests := []struct {
+		frequency float64
+		min, max  float64
+	}{
+		{20, -15, -10},
+		{38, -6.5, -5.5},
+		{100, -1.5, -0.8},
+		{500, -0.1, 0.1},
+		{997, 0.4, 0.9},
+		{3000, 3.4, 4},
+		{8000, 3.9, 4.1},
+		{15000, 3.9, 4.1},
+	}
+
+	for _, tt := range tests {
+		shelf := newHighShelf(1500, 4, 1/math.Sqrt2, SampleRate)
+		highPass := newHighPass(38, 0.5, SampleRate)
+
+		in := tone(tt.frequency, 1, 2)
+		out := make([]float64, len(in))
+		for i, s := range in {
+			out[i] = highPass.Process(shelf.Process(s))
+		}
+
+		if g := gainDB(in, out, SampleRate); g < tt.min || g > tt.max {
+			t.Errorf("K-weighting at %gHz: %.2fdB, want %g to %gdB", tt.frequency, g, tt.min, tt.max)
+		}
+	}
+}
+
+func TestMeasureLUFS(t *testing.T) {
+	silence := make([]float64, SampleRate)
+
+	tests := []struct {
+		name      string
+		channels  [][]float64
+		want      float64
+		tolerance float64
+	}{
+		// BS.1770 calibration: a full scale 997Hz sine reads -3.01 LUFS
+		{"full scale sine", [][]float64{tone(997, 1, 3)}, -3.01, 0.1},
+		{"-20dB sine", [][]float64{tone(997, 0.1, 3)}, -23.01, 0.1},
+		// the gates leave the silence out, only the blocks over the end of
+		// the sine count a bit of it
+		{"sine then silence", [][]float64{append(tone(997, 1, 3), silence...)}, -3.01, 0.5},
+		// the channels add up their power, not their average
+		{"stereo", [][]float64{tone(997, 0.1, 3), tone(997, 0.1, 3)}, -20, 0.1},
+		{"one side", [][]float64{tone(997, 0.1, 3), make([]float64, 3*SampleRate)}, -23.01, 0.1},
+		// rear channels weigh 1.41, +1.5dB, and the LFE nothing
+		{"quad rear", [][]float64{nil, nil, tone(997, 0.1, 3), nil}, -21.52, 0.1},
+		{"5.1 LFE", [][]float64{nil, nil, tone(997, 0.1, 3), tone(997, 1, 3), nil, nil}, -23.01, 0.1},
+	}
+
+	for _, tt := range tests {
+		for ch := range tt.channels {
+			if tt.channels[ch] == nil {
+				tt.channels[ch] = make([]float64, 3*SampleRate)
+			}
+		}
+		if got := measureLUFS(tt.channels, SampleRate); math.Abs(got-tt.want) > tt.tolerance {
+			t.Errorf("%s: %.2f LUFS, want %.2f", tt.name, got, tt.want)
+		}
+	}
+
+	if got := measureLUFS([][]float64{silence}, SampleRate); !math.IsInf(got, -1) {
+		t.Errorf("silence: %v LUFS, want -Inf", got)
+	}
+}
+
+func TestNormalizeLoudness(t *testing.T) {
+	for _, target := range []float64{-14, -23, -30} {
+		channels := [][]float64{tone(440, 0.3, 2), tone(660, 0.2, 2)}
+		normalizeLoudness(channels, target)
+
+		got := measureLUFS(channels, SampleRate)
+		if math.Abs(got-target) > 0.01 {
+			t.Errorf("normalized to %g: measured %.3f LUFS", target, got)
+		}
+	}
+}
+
+func TestRenderLoudness(t *testing.T) {
+	// the loudness is measured on what gets written, after the master
+	// stage and the mono fold
+	for _, config := range []string{
+		"lufs=-14",
+		"lufs=-14,channel-count=2",
+		"lufs=-23,channel-count=2,autopan-depth=0.5",
+		"lufs=-14,channel-count=2,decorrelate=1",
+		"lufs=-14,channel-count=2,mono-compatible=true",
+		"lufs=-16,channel-count=6",
+		"lufs=-16,fade-in=1s",
+	} {
+		channels := renderWith(t, config, "C4:q E4:q G4:q C5:q | C4:w")
+		target := -14.0
+		fmt.Sscanf(config, "lufs=%g", &target)
+		if got := measureLUFS(channels, SampleRate); math.Abs(got-target) > 0.01 {
+			t.Errorf("%s: written at %.2f LUFS", config, got)
+		}
+	}
+}
+
+func TestComputeReplayGain(t *testing.T) {
+	tests := []struct {
+		name     string
+		channels [][]float64
+		want     float64
+	}{
+		// a quiet file gets turned up and a loud one down, to -18 LUFS
+		{"-40dB sine", [][]float64{tone(997, 0.01, 3)}, 25.01},
+		{"-20dB sine", [][]float64{tone(997, 0.1, 3)}, 5.01},
+		{"full scale sine", [][]float64{tone(997, 1, 3)}, -14.99},
+		{"silence", [][]float64{make([]float64, SampleRate)}, 0},
+		// the same sine on both sides plays 3dB louder than on one
+		{"stereo -20dB sine", [][]float64{tone(997, 0.1, 3), tone(997, 0.1, 3)}, 2},
+	}
+
+	for _, tt := range tests {
+		if got := computeReplayGain(tt.channels, SampleRate); math.Abs(got-tt.want) > 0.1 {
+			t.Errorf("%s: gain %.2f dB, want %.2f", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWriteReplayGain(t *testing.T) {
+	tests := []struct {
+		name     string
+		channels [][]float64
+		gain     float64
+		// peak isn't checked below 0
+		peak float64
+	}{
+		{"stereo", [][]float64{tone(997, 0.5, 3), tone(997, 0.25, 3)}, -9.94, 0.5},
+		// rendered at the reference loudness, nothing to correct
+		{"rendered", renderWith(t, "lufs=-18,channel-count=2,autopan-depth=0.5", "C4:q E4:q G4:h"), 0, -1},
+	}
+
+	for _, tt := range tests {
+		var b bytes.Buffer
+		if err := writeReplayGain(&b, tt.channels); err != nil {
+			t.Fatal(err)
+		}
+
+		var gain, peak float64
+		if _, err := fmt.Sscanf(b.String(), "REPLAYGAIN_TRACK_GAIN=%f dB\nREPLAYGAIN_TRACK_PEAK=%f\n", &gain, &peak); err != nil {
+			t.Fatalf("%s: %q: %v", tt.name, b.String(), err)
+		}
+		if math.Abs(gain-tt.gain) > 0.1 {
+			t.Errorf("%s: gain %.2f dB, want %.2f", tt.name, gain, tt.gain)
+		}
+		if tt.peak >= 0 && math.Abs(peak-tt.peak) > 1e-6 {
+			t.Errorf("%s: peak %f, want %f", tt.name, peak, tt.peak)
+		}
+	}
+}
@@ -4,14 +4,25 @@ package main
 
 import (
 	"encoding/binary"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"math"
+	"math/rand"
 	"os"
+	"time"
+
+	"github.com/tecnologer/SoundOfCode/synth"
 )
 
 const (
 	// Duration   = 1
-	SampleRate = 44100
+	SampleRate = synth.SampleRate
+
+	// monoCancellationWarning is the mono compatibility below which the
+	// downmix is reported as cancelling
+	monoCancellationWarning = 0.5
 	// Frequency  = 4186
 	// nsamps = 44100 // samples to generate
 )
@@ -21,38 +32,607 @@ var (
 	π = math.Pi
 	//τ is tau (from Greek alphabet) constant for π*2
 	τ = π * 2
+
+	score           = flag.String("score", "", "notes to play, tracks separated by |, e.g. \"C4:q E4:q. G4:et R:q | C3:w\"")
+	bpm             = flag.Int("bpm", 120, "tempo in beats per minute used by -score")
+	renderThreads   = flag.Int("render-threads", 1, "goroutines used to render the song")
+	chorus          = flag.Int("chorus", 0, "number of chorus voices, 0 disables the chorus")
+	chorusDepth     = flag.Duration("chorus-depth", 3*time.Millisecond, "delay swing of each chorus voice")
+	chorusRate      = flag.Float64("chorus-rate", 0.25, "chorus LFO rate in Hz")
+	quantizePitch   = flag.Bool("quantize-pitch", false, "snap every note to the nearest degree of -scale")
+	scaleRoot       = flag.String("scale-root", "C4", "root note of the scale")
+	scaleName       = flag.String("scale", "major", "scale pattern: major, minor, pentatonic, blues or chromatic")
+	lufs            = flag.Float64("lufs", 0, "normalize the output to this integrated loudness, e.g. -14; 0 disables it")
+	countInBars     = flag.Int("count-in", 0, "bars of metronome clicks before the song starts")
+	analyzeFile     = flag.String("analyze", "", "print level, DC offset, clipping and pitch stats of a WAV file and exit")
+	channelCount    = flag.Int("channel-count", 1, "number of output channels: 1, 2, 4 (quad, notes on the fronts) or 6 (5.1, notes on the center)")
+	autopanRate     = flag.Float64("autopan-rate", 0.5, "auto-pan LFO rate in Hz")
+	autopanDepth    = flag.Float64("autopan-depth", 0, "auto-pan sweep width from 0 (off) to 1, needs 2 channels")
+	gatePattern     = flag.String("gate-pattern", "", "rhythmic gate, one sixteenth per step, e.g. \"x.x.xx..\"")
+	attack          = flag.Duration("attack", 0, "envelope attack time")
+	hold            = flag.Duration("hold", 0, "envelope hold time at full level between attack and decay")
+	decay           = flag.Duration("decay", 0, "envelope decay time")
+	sustain         = flag.Float64("sustain", 1, "envelope sustain level, 0 to 1")
+	release         = flag.Duration("release", 0, "envelope release time, it rings past the end of the note")
+	octaveUp        = flag.Int("octave-up", 0, "octaves to shift the song up")
+	octaveDown      = flag.Int("octave-down", 0, "octaves to shift the song down")
+	plotEnvelopeFor = flag.Duration("plot-envelope", 0, "plot the envelope of a note held this long and exit")
+	stemsDir        = flag.String("stems", "", "render every track to its own WAV file in this directory instead of mixing")
+	outFile         = flag.String("out", "out.bin", "output file, the format follows the extension: .bin (raw float32), .wav or .flac; - is standard output as raw PCM")
+	noteGap         = flag.Duration("note-gap", 0, "silence between consecutive notes")
+	generateCount   = flag.Int("generate", 0, "play this many random notes of -scale instead of -score")
+	generateOctaves = flag.Int("octaves", 1, "octaves above -scale-root used by -generate and -text")
+	seed            = flag.Int64("seed", 1, "random seed")
+	stereoDetune    = flag.Float64("stereo-detune", 0, "cents between the left and right channel pitch, 0 keeps both identical")
+	validate        = flag.Bool("validate", false, "report every problem in -score and exit, with status 1 if any")
+	printFreqsOnly  = flag.Bool("print-freqs-only", false, "print the frequency of every note and exit")
+	combPitch       = flag.Float64("comb-pitch", 0, "tune a feedback comb filter to this frequency in Hz, 0 disables it")
+	combFeedback    = flag.Float64("comb-feedback", 0.7, "comb filter feedback, below 1")
+	chordList       = flag.String("chord", "", "play these notes together, e.g. C4,E4,G4 or 40,44,47")
+	duration        = flag.Duration("duration", time.Second, "length of the -chord, of every -progression chord and of every -interval step")
+	progression     = flag.String("progression", "", "play a chord progression, e.g. Cmaj,Am,F,G7")
+	strumOffset     = flag.Duration("strum", 0, "delay between the voices of -chord and -progression chords")
+	strumDirection  = flag.String("strum-direction", "up", "strum from the first voice (up) or from the last one (down)")
+	speedTest       = flag.Bool("speed-test", false, "render as fast as possible without writing and report the real-time factor")
+	musicXMLFile    = flag.String("musicxml", "", "play the first part of a MusicXML score instead of -score")
+	drive           = flag.Float64("drive", 0, "wave shaper distortion drive, 0 disables it")
+	shaperCurve     = flag.String("shaper", "tanh", "wave shaper curve: tanh, atan or cubic")
+	abMode          = flag.Bool("ab", false, "render the song with -a settings and then with -b settings to compare them")
+	configA         = flag.String("a", "", "settings for the first -ab rendering, e.g. \"attack=10ms,release=200ms\"")
+	configB         = flag.String("b", "", "settings for the second -ab rendering, e.g. \"instrument=pluck\"")
+	harmonicProfile = flag.String("harmonic-profile", "", "amplitudes of the fundamental and its overtones, e.g. 1,0,0.5,0,0.25")
+	fadeInFor       = flag.Duration("fade-in", 0, "ramp the output up from silence over this long")
+	delayTime       = flag.Duration("delay", 0, "echo delay time, 0 disables the echo")
+	delaySync       = flag.String("delay-sync", "", "echo delay as a note length at -bpm, e.g. q or e., overrides -delay")
+	delayFeedback   = flag.Float64("delay-feedback", 0.4, "level of every echo repeat relative to the previous one, below 1")
+	delayMix        = flag.Float64("delay-mix", 0.3, "echo level in the output, 0 (dry) to 1 (wet)")
+	monoCompatible  = flag.Bool("mono-compatible", false, "fold the output to a single channel, warning when the channels cancel")
+	veloFilter      = flag.Float64("velo-filter", 0, "how much softer notes (score @velocity) close their low pass, 0 disables it")
+	renderTail      = flag.Duration("render-tail", 0, "extra time rendered after the song so echoes and resonances fade out")
+	quantizeTime    = flag.String("quantize-time", "", "snap note starts to a grid at -bpm, e.g. 1/16")
+	sampleFormat    = flag.String("sample-format", "f32", "WAV sample format: u8, s16, s24 (dithered) or f32")
+	effectOrder     = flag.String("effects", "drive,gate,chorus,delay,comb,glitch,noisegate", "order of the track effects, the ones left out are not applied")
+	articulation    = flag.Float64("articulation", 1, "fraction of every note that is held, e.g. 0.3 for staccato, keeping the rhythm")
+	batchDir        = flag.String("batch", "", "render every song file (.musicxml, .xml, .score, .txt) in this directory and exit")
+	outDir          = flag.String("out-dir", ".", "directory the -batch renderings are written to")
+	scalaFile       = flag.String("scala", "", "tune the notes with a Scala .scl scale, A4 stays at 440Hz as its first degree")
+	harmonicDecay   = flag.Float64("harmonic-decay", 0, "how fast the -harmonic-profile overtones fade, per second and harmonic number; 0 keeps them constant")
+	wavInfoFile     = flag.String("print-wav-info", "", "print the header of a WAV file (format, channels, rate, length) and exit")
+	zeroCrossings   = flag.Bool("click-at-zero-crossings", false, "start every note at a zero crossing and round its length to whole periods so it ends on one too")
+	sidechain       = flag.String("sidechain", "", "duck one track while another plays, tracks numbered from 1, e.g. \"from=1,to=2,amount=0.6\"")
+	pitchJitter     = flag.Float64("pitch-jitter", 0, "let the tuning wander slowly up to this many cents, like an analog synth; 0 disables it")
+	cuesFile        = flag.String("cues", "", "write the sample position of every note start to this file, for DAW markers")
+	clipMode        = flag.String("clip", "clamp", "how u8/s16/s24 output handles samples beyond full scale: clamp, soft or wrap")
+	replayGain      = flag.Bool("replay-gain", false, "write the ReplayGain track gain and peak of the output to <out>.replaygain")
+	transposeFit    = flag.Bool("transpose-to-fit", false, "shift the song by whole octaves so as many notes as possible fit the piano, clamping the rest")
+	gateThreshold   = flag.Float64("gate-threshold", 0, "noise gate threshold in dBFS, e.g. -50; 0 disables the noise gate")
+	gateHold        = flag.Duration("gate-hold", 50*time.Millisecond, "how long the level has to stay under -gate-threshold before the noise gate closes")
+	gateRelease     = flag.Duration("gate-release", 20*time.Millisecond, "how long the noise gate takes to close")
+	intervalName    = flag.String("interval", "", "play -interval-root, the interval above it and both together, e.g. P5, M3 or m7")
+	intervalRoot    = flag.String("interval-root", "C4", "root note of -interval")
+	glitch          = flag.Float64("glitch", 0, "probability of every slice being replaced by a stutter of the one before, 0 disables it")
+	glitchMin       = flag.Duration("glitch-slice-min", 30*time.Millisecond, "shortest -glitch slice")
+	glitchMax       = flag.Duration("glitch-slice-max", 120*time.Millisecond, "longest -glitch slice")
+	glitchRepeats   = flag.Int("glitch-repeats", 3, "times a -glitch slice is repeated")
+	overtoneCeiling = flag.Float64("overtone-ceiling", 0, "fade the -harmonic-profile overtones out toward this frequency in Hz so high notes get fewer; 0 keeps them all")
+	startAt         = flag.Duration("start-at", 0, "start the song this far in, skipping the notes before and joining the one playing there")
+	loudnessMatch   = flag.Bool("loudness-match", false, "scale the -ab B rendering to the loudness of the A one")
+	seedMode        = flag.String("seed-mode", "global", "-pitch-jitter randomness: global, one drift for the whole song, or per-note, every note drifting on its own from -seed plus its index")
+	bankFile        = flag.String("bank", "", "load the presets of a JSON preset bank, selected with -instrument")
+	decorrelate     = flag.Float64("decorrelate", 0, "widen the channels with a different short allpass chain on each, depth 0 (off) to 1")
+	noteCount       = flag.Int("count", 0, "play only the first notes of every track, this many; 0 plays them all")
+	harmonicDetune  = flag.Float64("harmonic-detune", 0, "stretch the -harmonic-profile harmonics, n at n*(1+detune*n²) times the pitch, e.g. 0.0004; 0 keeps them exact")
+	text            = flag.String("text", "", "play a melody spelled by the text, every character a note of -scale and every space a rest")
+	trim            = flag.Bool("trim-silence", false, "cut the silence before the first and after the last sample above -trim-threshold")
+	trimThreshold   = flag.Float64("trim-threshold", -60, "level in dBFS under which -trim-silence takes samples for silence")
+	trimPad         = flag.Duration("trim-pad", 10*time.Millisecond, "silence -trim-silence keeps on each side")
+	chordSpread     = flag.Float64("chord-spread", 0, "pan the -chord and -progression voices across the stereo field, lowest left and highest right, from 0 (centered) to 1 (hard left and right)")
+	dumpSamples     = flag.String("dump-samples", "", "write the rendered samples to this CSV file, a row per frame and a column per channel, instead of -out")
+	midiFile        = flag.String("midi", "", "write the song as a Standard MIDI File at -bpm and exit")
+	waveName        = flag.String("wave", "sine", "oscillator waveform: sine, square, triangle, saw, or white or pink noise; -harmonic-profile overrides it")
+	wavetableFile   = flag.String("wavetable", "", "play a single cycle waveform looped at every pitch, read from a WAV file or a text file of comma or line separated samples; replaces -wave")
+	toStdout        = flag.Bool("stdout", false, "write raw interleaved little endian PCM to standard output instead of -out, in -sample-format with -channel-count channels at 44100Hz; same as -out -")
+	instrumentName  = flag.String("instrument", "", "preset instrument (pluck, bell, guitar, harp, epiano, fm-bell or fm-bass), overrides the envelope flags")
 )
 
+func init() {
+	flag.StringVar(outFile, "o", *outFile, "shorthand for -out")
+}
+
 func main() {
+	flag.Parse()
+
+	if *bankFile != "" {
+		bank, err := LoadPresetBank(*bankFile)
+		check(err)
+
+		for name, p := range bank {
+			presets[name] = p.Instrument()
+		}
+		if p, ok := bank[*instrumentName]; ok {
+			check(p.apply())
+		}
+	}
+
+	check(validateFlags())
+
+	if *analyzeFile != "" {
+		in, err := os.Open(*analyzeFile)
+		check(err)
+		defer in.Close()
+
+		samples, header, err := readWAV(in)
+		check(err)
+
+		printAnalysis(os.Stdout, analyze(downmix(samples, header.Channels), header.SampleRate))
+		return
+	}
+
+	if *wavInfoFile != "" {
+		in, err := os.Open(*wavInfoFile)
+		check(err)
+		defer in.Close()
+
+		header, err := readWAVHeader(in)
+		check(err)
+
+		printWAVInfo(os.Stdout, header)
+		return
+	}
+
+	if *plotEnvelopeFor > 0 {
+		plotEnvelope(os.Stdout, envelope(), plotEnvelopeFor.Seconds())
+		return
+	}
+
+	if *scalaFile != "" {
+		in, err := os.Open(*scalaFile)
+		check(err)
+
+		tuning, err = parseScala(in)
+		in.Close()
+		check(err)
+	}
+
+	if *wavetableFile != "" {
+		// fail before rendering, the tracks look the table up themselves
+		_, err := waveShape()
+		check(err)
+	}
+
+	if *batchDir != "" {
+		failed, err := batchRender(*batchDir, *outDir, *sampleFormat, *clipMode, os.Stderr)
+		check(err)
+
+		if failed > 0 {
+			check(fmt.Errorf("%d songs failed", failed))
+		}
+		return
+	}
+
+	if *validate {
+		errs := ValidateScore(*score, *bpm, envelope())
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+
+		if len(errs) > 0 {
+			os.Exit(1)
+		}
+
+		fmt.Fprintf(os.Stderr, "score is valid\n")
+		return
+	}
+
+	song, err := ParseSong(*score, *bpm)
+	check(err)
+
+	if *musicXMLFile != "" {
+		in, err := os.Open(*musicXMLFile)
+		check(err)
+
+		song, err = parseMusicXML(in)
+		in.Close()
+		check(err)
+	}
+
+	if *chordList != "" {
+		keys, err := ParseChord(*chordList)
+		check(err)
+
+		song = chordSong(keys, *duration)
+	}
+
+	if *progression != "" {
+		song, err = progressionSong(*progression, *duration)
+		check(err)
+	}
+
+	if *intervalName != "" {
+		root, err := synth.ParseNote(*intervalRoot)
+		check(err)
+
+		song, err = intervalSong(os.Stderr, root, *intervalName, *duration)
+		check(err)
+	}
+
+	if *strumOffset > 0 && (*chordList != "" || *progression != "") {
+		if *strumDirection != "up" && *strumDirection != "down" {
+			check(fmt.Errorf("invalid strum direction %q", *strumDirection))
+		}
+
+		strum(song, *strumOffset, *strumDirection == "down")
+	}
+
+	if *generateCount > 0 {
+		scale, err := ParseScale(*scaleRoot, *scaleName)
+		check(err)
+
+		song, err = generateMelody(scale, *generateCount, *generateOctaves, *bpm, rand.New(rand.NewSource(*seed)))
+		check(err)
+	}
+
+	if *text != "" {
+		scale, err := ParseScale(*scaleRoot, *scaleName)
+		check(err)
+
+		song = textToSong(*text, scaleRange(scale, *generateOctaves))
+	}
+
+	if *noteCount > 0 {
+		song.Limit(*noteCount)
+	}
+
+	if *quantizeTime != "" {
+		division, err := parseDivision(*quantizeTime)
+		check(err)
+
+		song = quantizeTiming(song, division, *bpm)
+	}
+
+	if len(song.Tracks) == 0 {
+		song.Tracks = []Track{{Notes: []Note{{Key: synth.RefKey, Duration: 300 * time.Millisecond}}}}
+	}
+
+	if *quantizePitch {
+		scale, err := ParseScale(*scaleRoot, *scaleName)
+		check(err)
+
+		keys := scale.Keys()
+		song.MapKeys(func(key int) int { return snapToScale(key, keys) })
+	}
+
+	for i := 0; i < *octaveUp; i++ {
+		song.MapKeys(OctaveUp)
+	}
+
+	for i := 0; i < *octaveDown; i++ {
+		song.MapKeys(OctaveDown)
+	}
+
+	if *transposeFit {
+		fitted, octaves := transposeToFit(song)
+		if n := outOfRange(song, octaves*12); n > 0 {
+			fmt.Fprintf(os.Stderr, "warning: %d notes still out of the piano range after transposing, clamped\n", n)
+		}
+		if octaves != 0 {
+			fmt.Fprintf(os.Stderr, "transposed %+d octaves to fit\n", octaves)
+		}
+		song = fitted
+	} else if n := outOfRange(song, 0); n > 0 {
+		check(fmt.Errorf("%d notes are out of the piano range, -transpose-to-fit can move them in", n))
+	}
+
+	if *printFreqsOnly {
+		printFrequencies(os.Stdout, song)
+		return
+	}
+
+	if *midiFile != "" {
+		f, err := os.Create(*midiFile)
+		check(err)
+		check(writeMIDI(f, song, *bpm))
+		check(f.Close())
+		return
+	}
+
+	if n := song.ShortNotes(envelope()); n > 0 {
+		fmt.Fprintf(os.Stderr, "warning: %d notes are shorter than attack+hold+decay, their envelope is shrunk to fit\n", n)
+	}
+
+	if *stemsDir != "" {
+		check(writeStems(song, *stemsDir, *channelCount))
+		return
+	}
+
+	if *speedTest {
+		_, err := measureSpeed(os.Stdout, song)
+		check(err)
+		return
+	}
+
+	if *dumpSamples != "" {
+		f, err := os.Create(*dumpSamples)
+		check(err)
+		check(writeSamplesCSV(f, renderSong(song)))
+		check(f.Close())
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "song length: %v\n", TotalDuration(song, *noteGap))
 	fmt.Fprintf(os.Stderr, "generating sine wave..\n")
-	file := "out.bin"
-	f, _ := os.Create(file)
-	// sound := make([]byte, 0)
-	sound := generate(float32(0.3), float32(440))
-	// for f := float32(27.5); f < 4186; f += 5 {
-	// 	sound = append(sound, generate(0.01, f)...)
-	// }
-
-	_, _ = f.Write(sound)
-	// fmt.Printf("\rWrote: %v bytes to %s\n", bw, file)
-	// fmt.Fprintf(os.Stderr, "done")
-}
-
-func generate(duration, frequency float32) (sound []byte) {
-	// var (
-	// 	start float64 = 1.0
-	// 	end   float64 = 1.0e-4
-	// )
-	sound = make([]byte, 0)
-	nsamps := duration * SampleRate
-	var angle float64 = τ / float64(nsamps)
-
-	// decayfac := math.Pow(end/start, 1.0/float64(nsamps))
-	for i := float32(0); i < nsamps; i++ {
-		sample := math.Sin(angle * float64(frequency) * float64(i))
-		// sample *= start
-		// start *= decayfac
-		fmt.Printf("%.8f\n", sample)
+	if *toStdout {
+		*outFile = "-"
+	}
+	encoder, err := encoderFor(*outFile, *sampleFormat, *clipMode)
+	check(err)
+
+	f := os.Stdout
+	if *outFile != "-" {
+		f, err = os.Create(*outFile)
+		check(err)
+		defer f.Close()
+	}
+
+	trimmed := 0
+	if streamable(encoder) {
+		check(streamSong(f, encoder, song))
+	} else {
+		var channels [][]float64
+		if *abMode {
+			channels, err = renderAB(song, *configA, *configB, *loudnessMatch)
+			check(err)
+		} else {
+			channels = renderSong(song)
+		}
+
+		if *trim {
+			channels, trimmed = trimChannels(channels, math.Pow(10, *trimThreshold/20), int(trimPad.Seconds()*SampleRate))
+		}
+
+		check(encoder.Encode(f, interleave(channels), len(channels), SampleRate))
+
+		if *replayGain {
+			tags, err := os.Create(*outFile + ".replaygain")
+			check(err)
+			check(writeReplayGain(tags, channels))
+			check(tags.Close())
+		}
+	}
+
+	if *cuesFile != "" {
+		offset := -int(startAt.Seconds()*SampleRate) - trimmed
+		if *countInBars > 0 {
+			offset += len(countIn(*countInBars, *bpm))
+		}
+
+		cues, err := os.Create(*cuesFile)
+		check(err)
+		check(writeCues(cues, songCues(song, offset)))
+		check(cues.Close())
+	}
+}
+
+// renderSong renders and mixes every track of the song and runs the mix
+// through the master stage: sidechain ducking, count-in, fade-in,
+// auto-pan, decorrelation, mono folding and loudness
+func renderSong(song *Song) [][]float64 {
+	tracks := make([][][]float64, len(song.Tracks))
+	for i, track := range song.Tracks {
+		tracks[i] = renderTrack(track, *channelCount)
+	}
+
+	if *chordSpread > 0 && *channelCount == 2 && (*chordList != "" || *progression != "") {
+		for i, pan := range voicePans(song, *chordSpread) {
+			panStereo(tracks[i], pan)
+		}
+	}
+
+	if *sidechain != "" {
+		duck, err := ParseSidechain(*sidechain)
+		check(err)
+		check(duck.Apply(tracks))
+	}
+	channels := mixChannels(tracks, *channelCount)
+
+	if *countInBars > 0 {
+		clicks := countIn(*countInBars, *bpm)
+		for ch := range channels {
+			channels[ch] = append(append([]float64(nil), clicks...), channels[ch]...)
+		}
+	}
+
+	newMasterStage(len(channels)).Process(channels)
+
+	if *monoCompatible && len(channels) > 1 {
+		// the channels the layout leaves silent would only lower the level
+		sources := channelLayouts[*channelCount].sources(channels)
+		if c := monoCompatibility(sources); c < monoCancellationWarning {
+			fmt.Fprintf(os.Stderr, "warning: the mono downmix keeps only %.0f%% of the energy, the channels cancel each other\n", c*100)
+		}
+		channels = [][]float64{monoSum(sources)}
+	}
+
+	// measured last, on what gets written
+	if *lufs != 0 {
+		normalizeLoudness(channels, *lufs)
+	}
+
+	return channels
+}
+
+// measureSpeed renders the song with all its effects, encodes it to
+// nowhere and reports to w how many seconds of audio that took per second
+// of wall-clock time, the real-time factor it returns
+func measureSpeed(w io.Writer, song *Song) (float64, error) {
+	start := time.Now()
+	channels := renderSong(song)
+	if err := (wavEncoder{BitsPerSample: 32, Clip: "clamp"}).Encode(io.Discard, interleave(channels), len(channels), SampleRate); err != nil {
+		return 0, err
+	}
+
+	audio := float64(len(channels[0])) / SampleRate
+	elapsed := time.Since(start).Seconds()
+	fmt.Fprintf(w, "rendered %.2fs of audio in %.3fs, real-time factor %.1fx\n", audio, elapsed, audio/elapsed)
+	return audio / elapsed, nil
+}
+
+// renderTrack synthesizes the notes of the track, one buffer per channel,
+// and runs them through the track effects
+func renderTrack(track Track, channelCount int) [][]float64 {
+	s := newTrackStream(track, channelCount)
+	channels := make([][]float64, channelCount)
+	for ch := range channels {
+		channels[ch] = make([]float64, s.end-s.position)
+	}
+
+	s.Read(channels)
+	return channels
+}
+
+// trackEffects returns every track effect by its -effects name, nil when
+// its flags leave it off
+func trackEffects() (map[string]Effect, error) {
+	effects := map[string]Effect{
+		"drive":     nil,
+		"gate":      nil,
+		"chorus":    nil,
+		"delay":     nil,
+		"comb":      nil,
+		"glitch":    nil,
+		"noisegate": nil,
+	}
+
+	if *drive > 0 {
+		shaper, err := NewWaveShaper(*drive, *shaperCurve)
+		if err != nil {
+			return nil, err
+		}
+
+		effects["drive"] = shaper
+	}
+
+	if *gatePattern != "" {
+		gate, err := NewGate(*gatePattern, *bpm)
+		if err != nil {
+			return nil, err
+		}
+
+		effects["gate"] = gate
+	}
+
+	if *chorus > 0 {
+		effects["chorus"] = NewChorus(*chorus, *chorusDepth, *chorusRate)
+	}
+
+	delay := *delayTime
+	if *delaySync != "" {
+		var err error
+		delay, err = noteDuration(*delaySync, *bpm)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if delay > 0 {
+		effects["delay"] = NewDelay(delay, *delayFeedback, *delayMix)
+	}
+
+	if *combPitch > 0 {
+		effects["comb"] = NewCombFilter(*combPitch, *combFeedback)
+	}
+
+	if *glitch > 0 {
+		// reset to the same seed on every channel so they stutter together
+		effects["glitch"] = NewGlitch(*glitch, *glitchMin, *glitchMax, *glitchRepeats, *seed)
+	}
+
+	if *gateThreshold < 0 {
+		effects["noisegate"] = NewNoiseGate(*gateThreshold, *gateHold, *gateRelease)
+	}
+
+	return effects, nil
+}
+
+// validateFlags checks the flags the renderer relies on, it runs again on
+// the -ab overrides
+func validateFlags() error {
+	if *bpm <= 0 {
+		return fmt.Errorf("invalid tempo %d bpm, it must be above 0", *bpm)
+	}
+
+	if _, ok := channelLayouts[*channelCount]; !ok {
+		return fmt.Errorf("invalid channel count %d, use 1, 2, 4 (quad) or 6 (5.1)", *channelCount)
+	}
+
+	if *seedMode != "global" && *seedMode != "per-note" {
+		return fmt.Errorf("invalid seed mode %q, use global or per-note", *seedMode)
+	}
+
+	if *articulation <= 0 || *articulation > 1 {
+		return fmt.Errorf("invalid articulation %g, it must be above 0 and up to 1", *articulation)
+	}
+
+	// the tracks build these once the output file is already created,
+	// resolved here a bad name doesn't cost the previous output
+	if _, err := instrument(*instrumentName, envelope()); err != nil {
+		return err
+	}
+
+	if *harmonicProfile != "" {
+		if _, err := parseHarmonics(*harmonicProfile); err != nil {
+			return err
+		}
+	}
+
+	if _, err := waveShape(); err != nil {
+		return err
+	}
+
+	if _, err := effectChain(); err != nil {
+		return err
+	}
+
+	if *generateOctaves < 1 {
+		return fmt.Errorf("invalid octave count %d, it must be at least 1", *generateOctaves)
+	}
+
+	// at 1 or beyond the echoes never die out, or grow
+	if math.Abs(*combFeedback) >= 1 {
+		return fmt.Errorf("invalid comb filter feedback %g, it must be between -1 and 1", *combFeedback)
+	}
+
+	if math.Abs(*delayFeedback) >= 1 {
+		return fmt.Errorf("invalid delay feedback %g, it must be between -1 and 1", *delayFeedback)
+	}
+
+	if *decorrelate < 0 || *decorrelate > 1 {
+		return fmt.Errorf("invalid decorrelation depth %g, it must be from 0 to 1", *decorrelate)
+	}
+
+	if *replayGain && (*toStdout || *outFile == "-") {
+		return errors.New("-replay-gain needs an output file to write its sidecar next to")
+	}
+
+	return nil
+}
+
+// envelope builds the note envelope from the flags
+func envelope() synth.Envelope {
+	return synth.Envelope{Attack: *attack, Hold: *hold, Decay: *decay, Sustain: *sustain, Release: *release}
+}
+
+// check exits with the error message when err is not nil
+func check(err error) {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+func encode(samples []float64) (sound []byte) {
+	sound = make([]byte, 0, len(samples)*8)
+	for _, sample := range samples {
 		var buf [8]byte
 		binary.LittleEndian.PutUint32(buf[:],
 			math.Float32bits(float32(sample)))
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	beatsPerBar = 4
+	clickLength = 30 * time.Millisecond
+	// clickAccent is the pitch of the first click of every bar, the
+	// others use clickPitch
+	clickAccent = 1760.0
+	clickPitch  = 1320.0
+)
+
+// beatSamples is the length of one beat in samples at bpm
+func beatSamples(bpm int) int {
+	return int(math.Round(60 / float64(bpm) * SampleRate))
+}
+
+// click renders a short decaying metronome click into out
+func click(out []float64, frequency float64) {
+	length := int(clickLength.Seconds() * SampleRate)
+	for i := 0; i < length && i < len(out); i++ {
+		decay := math.Exp(-6 * float64(i) / float64(length))
+		out[i] = 0.5 * decay * math.Sin(τ*frequency*float64(i)/SampleRate)
+	}
+}
+
+// countIn renders bars of metronome clicks, one per beat
+func countIn(bars, bpm int) []float64 {
+	beat := beatSamples(bpm)
+	out := make([]float64, bars*beatsPerBar*beat)
+	for b := 0; b < bars*beatsPerBar; b++ {
+		frequency := clickPitch
+		if b%beatsPerBar == 0 {
+			frequency = clickAccent
+		}
+		click(out[b*beat:], frequency)
+	}
+
+	return out
+}
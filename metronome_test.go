@@ -0,0 +1,75 @@
+package main
+
+import "testing"
+
+// onsets returns where the sound starts again after at least gap samples
+// of silence
+func onsets(samples []float64, gap int) []int {
+	var found []int
+	silent := gap
+	for i, s := range samples {
+		if s == 0 {
+			silent++
+			continue
+		}
+		if silent >= gap {
+			found = append(found, i)
+		}
+		silent = 0
+	}
+	return found
+}
+
+func TestCountIn(t *testing.T) {
+	tests := []struct {
+		bars, bpm int
+	}{
+		{1, 120},
+		{2, 120},
+		{1, 90},
+	}
+
+	for _, tt := range tests {
+		clicks := countIn(tt.bars, tt.bpm)
+		beat := beatSamples(tt.bpm)
+		if len(clicks) != tt.bars*beatsPerBar*beat {
+			t.Errorf("%d bars at %d BPM: %d samples, want %d", tt.bars, tt.bpm, len(clicks), tt.bars*beatsPerBar*beat)
+		}
+
+		got := onsets(clicks, 100)
+		if len(got) != tt.bars*beatsPerBar {
+			t.Fatalf("%d bars at %d BPM: %d clicks, want %d", tt.bars, tt.bpm, len(got), tt.bars*beatsPerBar)
+		}
+		for i, at := range got {
+			// the sine of the click starts at 0, its first sample is the next one
+			if at != i*beat+1 {
+				t.Errorf("%d bars at %d BPM: click %d at %d, want %d", tt.bars, tt.bpm, i, at, i*beat+1)
+			}
+		}
+	}
+}
+
+func TestCountInDelaysTheSong(t *testing.T) {
+	const score = "C4:q E4:q G4:h"
+	song := renderWith(t, "", score)
+	counted := renderWith(t, "count-in=2", score)
+
+	lead := 2 * beatsPerBar * beatSamples(120)
+	if len(counted[0]) != lead+len(song[0]) {
+		t.Fatalf("%d samples with the count-in, want %d", len(counted[0]), lead+len(song[0]))
+	}
+
+	// the clicks come first and then the song, unchanged
+	clicks := countIn(2, 120)
+	for i := range counted[0] {
+		want := 0.0
+		if i < lead {
+			want = clicks[i]
+		} else {
+			want = song[0][i-lead]
+		}
+		if counted[0][i] != want {
+			t.Fatalf("sample %d is %v, want %v", i, counted[0][i], want)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	// midiDivision is the number of ticks per quarter note
+	midiDivision = 480
+	// midiKeyOffset turns a piano key into a MIDI note number, A0 being 21
+	midiKeyOffset = 20
+)
+
+// midiEvent is a note on or off at tick, on is false for a note off
+type midiEvent struct {
+	tick     int
+	on       bool
+	channel  int
+	note     int
+	velocity int
+}
+
+// writeMIDI writes the song as a format 0 Standard MIDI File at bpm, every
+// track on its own channel (wrapping after 16). Note velocities are
+// scaled to 1-127, unset ones playing at 127. Notes lasting no tick at
+// all, like MusicXML grace notes, are left out as schedule does
+func writeMIDI(w io.Writer, song *Song, bpm int) error {
+	ticks := func(d time.Duration) int {
+		return int(math.Round(d.Minutes() * float64(bpm) * midiDivision))
+	}
+
+	var events []midiEvent
+	for t, track := range song.Tracks {
+		var position time.Duration
+		for _, note := range track.Notes {
+			// a note off on the tick of its note on would sort before it
+			// and leave the note playing
+			start, end := ticks(position), ticks(position+note.Duration)
+			if note.Key > 0 && end > start {
+				velocity := int(math.Max(1, math.Round(note.strength()*127)))
+				events = append(events,
+					midiEvent{tick: start, on: true, channel: t % 16, note: note.Key + midiKeyOffset, velocity: velocity},
+					midiEvent{tick: end, channel: t % 16, note: note.Key + midiKeyOffset})
+			}
+			position += note.Duration
+		}
+	}
+
+	// note offs go first so a repeated note isn't cut right after it starts
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].tick != events[j].tick {
+			return events[i].tick < events[j].tick
+		}
+		return !events[i].on && events[j].on
+	})
+
+	var track bytes.Buffer
+	tempo := 60000000 / bpm
+	track.Write([]byte{0, 0xFF, 0x51, 3, byte(tempo >> 16), byte(tempo >> 8), byte(tempo)})
+
+	last := 0
+	for _, e := range events {
+		writeVarLen(&track, e.tick-last)
+		last = e.tick
+		status := byte(0x80)
+		if e.on {
+			status = 0x90
+		}
+		track.Write([]byte{status | byte(e.channel), byte(e.note), byte(e.velocity)})
+	}
+	track.Write([]byte{0, 0xFF, 0x2F, 0})
+
+	header := make([]byte, 22)
+	copy(header[0:4], "MThd")
+	binary.BigEndian.PutUint32(header[4:8], 6)
+	binary.BigEndian.PutUint16(header[8:10], 0)
+	binary.BigEndian.PutUint16(header[10:12], 1)
+	binary.BigEndian.PutUint16(header[12:14], midiDivision)
+	copy(header[14:18], "MTrk")
+	binary.BigEndian.PutUint32(header[18:22], uint32(track.Len()))
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := track.WriteTo(w)
+	return err
+}
+
+// writeVarLen writes n as a MIDI variable length quantity, 7 bits per byte
+// with the high bit set on all but the last
+func writeVarLen(buf *bytes.Buffer, n int) {
+	var b [4]byte
+	i := len(b) - 1
+	b[i] = byte(n & 0x7F)
+	for n >>= 7; n > 0 && i > 0; n >>= 7 {
+		i--
+		b[i] = byte(n&0x7F) | 0x80
+	}
+	buf.Write(b[i:])
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// midiNote is a note read back from a MIDI file, in ticks
+type midiNote struct {
+	channel, note, velocity int
+	start, length           int
+}
+
+// readMIDI decodes the format 0 files writeMIDI writes: the tempo in
+// microseconds per quarter and the notes ordered by their note on
+func readMIDI(tb testing.TB, data []byte) (tempo int, notes []midiNote) {
+	tb.Helper()
+	if len(data) < 22 || string(data[0:4]) != "MThd" || string(data[14:18]) != "MTrk" {
+		tb.Fatalf("not a MIDI file: % x", data[:22])
+	}
+	if format, tracks, division := binary.BigEndian.Uint16(data[8:10]), binary.BigEndian.Uint16(data[10:12]), binary.BigEndian.Uint16(data[12:14]); format != 0 || tracks != 1 || division != midiDivision {
+		tb.Fatalf("format %d with %d tracks at %d ticks per quarter, want format 0, 1 track at %d", format, tracks, division, midiDivision)
+	}
+	track := data[22:]
+	if size := int(binary.BigEndian.Uint32(data[18:22])); size != len(track) {
+		tb.Fatalf("track size %d, %d bytes follow", size, len(track))
+	}
+
+	varLen := func() int {
+		n := 0
+		for {
+			b := track[0]
+			track = track[1:]
+			n = n<<7 | int(b&0x7F)
+			if b&0x80 == 0 {
+				return n
+			}
+		}
+	}
+
+	playing := map[[2]int]int{}
+	tick := 0
+	for len(track) > 0 {
+		tick += varLen()
+		status := track[0]
+		switch {
+		case status == 0xFF:
+			kind, size := track[1], int(track[2])
+			if kind == 0x51 {
+				tempo = int(track[3])<<16 | int(track[4])<<8 | int(track[5])
+			}
+			track = track[3+size:]
+			if kind == 0x2F && len(track) > 0 {
+				tb.Fatalf("%d bytes after the end of the track", len(track))
+			}
+		case status&0xF0 == 0x90:
+			key := [2]int{int(status & 0x0F), int(track[1])}
+			playing[key] = len(notes)
+			notes = append(notes, midiNote{channel: key[0], note: key[1], velocity: int(track[2]), start: tick})
+			track = track[3:]
+		case status&0xF0 == 0x80:
+			key := [2]int{int(status & 0x0F), int(track[1])}
+			i, ok := playing[key]
+			if !ok {
+				tb.Fatalf("note off for %v at tick %d, it isn't playing", key, tick)
+			}
+			notes[i].length = tick - notes[i].start
+			delete(playing, key)
+			track = track[3:]
+		default:
+			tb.Fatalf("unexpected status %#x at tick %d", status, tick)
+		}
+	}
+
+	if len(playing) > 0 {
+		tb.Fatalf("%d notes never released", len(playing))
+	}
+	return tempo, notes
+}
+
+func TestWriteMIDIRoundTrip(t *testing.T) {
+	tests := []struct {
+		score string
+		bpm   int
+	}{
+		{"C4:q E4:e R:e G4:h", 120},
+		{"C4:q@0.5 C4:q@1 C4:et C4:et C4:et | A0:w | C8:h. R:q", 90},
+		{"A4:1.5b D4:250ms", 140},
+	}
+
+	for _, tt := range tests {
+		song, err := ParseSong(tt.score, tt.bpm)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var b bytes.Buffer
+		if err := writeMIDI(&b, song, tt.bpm); err != nil {
+			t.Fatal(err)
+		}
+		tempo, notes := readMIDI(t, b.Bytes())
+		if want := 60000000 / tt.bpm; tempo != want {
+			t.Errorf("%q: tempo %dµs per quarter, want %d", tt.score, tempo, want)
+		}
+
+		// every note of every track comes back on the track's channel, at
+		// the same key, time, length and velocity
+		var want []midiNote
+		for ch, track := range song.Tracks {
+			var position time.Duration
+			for _, note := range track.Notes {
+				if note.Key > 0 {
+					want = append(want, midiNote{
+						channel:  ch,
+						note:     note.Key + midiKeyOffset,
+						velocity: int(math.Round(note.strength() * 127)),
+						start:    int(math.Round(position.Minutes() * float64(tt.bpm) * midiDivision)),
+						length:   int(math.Round(note.Duration.Minutes() * float64(tt.bpm) * midiDivision)),
+					})
+				}
+				position += note.Duration
+			}
+		}
+
+		seconds := func(ticks int) float64 { return float64(ticks) * 60 / float64(tt.bpm*midiDivision) }
+		matched := 0
+		for _, w := range want {
+			for _, got := range notes {
+				if got.channel != w.channel || got.note != w.note || math.Abs(seconds(got.start)-seconds(w.start)) > 0.001 {
+					continue
+				}
+				matched++
+				if math.Abs(seconds(got.length)-seconds(w.length)) > 0.001 || got.velocity != w.velocity {
+					t.Errorf("%q: channel %d note %d at tick %d lasts %d ticks at velocity %d, want %d at %d", tt.score, w.channel, w.note, w.start, got.length, got.velocity, w.length, w.velocity)
+				}
+			}
+		}
+		if matched != len(want) || len(notes) != len(want) {
+			t.Errorf("%q: read back %d notes, %d of the %d written match", tt.score, len(notes), matched, len(want))
+		}
+	}
+}
+
+func TestWriteMIDINoteRange(t *testing.T) {
+	song := &Song{Tracks: []Track{{Notes: []Note{{Key: 1, Duration: time.Second}, {Key: 49, Duration: time.Second}, {Key: 88, Duration: time.Second}}}}}
+	var b bytes.Buffer
+	if err := writeMIDI(&b, song, 120); err != nil {
+		t.Fatal(err)
+	}
+
+	// A0, A4 and C8 are MIDI notes 21, 69 and 108
+	_, notes := readMIDI(t, b.Bytes())
+	for i, want := range []int{21, 69, 108} {
+		if notes[i].note != want {
+			t.Errorf("note %d is MIDI %d, want %d", i, notes[i].note, want)
+		}
+	}
+}
+
+func TestWriteVarLen(t *testing.T) {
+	tests := []struct {
+		n    int
+		want []byte
+	}{
+		{0, []byte{0x00}},
+		{0x40, []byte{0x40}},
+		{0x7F, []byte{0x7F}},
+		{0x80, []byte{0x81, 0x00}},
+		{0x2000, []byte{0xC0, 0x00}},
+		{0x3FFF, []byte{0xFF, 0x7F}},
+		{0x4000, []byte{0x81, 0x80, 0x00}},
+		{0x0FFFFFFF, []byte{0xFF, 0xFF, 0xFF, 0x7F}},
+	}
+
+	for _, tt := range tests {
+		var b bytes.Buffer
+		writeVarLen(&b, tt.n)
+		if !bytes.Equal(b.Bytes(), tt.want) {
+			t.Errorf("writeVarLen(%#x) = % x, want % x", tt.n, b.Bytes(), tt.want)
+		}
+	}
+}
+
+func TestWriteMIDIGraceNotes(t *testing.T) {
+	// a grace note has no duration, it isn't exported rather than left
+	// sounding forever
+	const score = `<?xml version="1.0" encoding="UTF-8"?>
+<score-partwise version="3.1">
+  <part-list><score-part id="P1"><part-name>Piano</part-name></score-part></part-list>
+  <part id="P1">
+    <measure number="1">
+      <attributes><divisions>1</divisions></attributes>
+      <direction><sound tempo="120"/></direction>
+      <note><pitch><step>C</step><octave>4</octave></pitch><duration>1</duration></note>
+      <note><grace/><pitch><step>D</step><octave>4</octave></pitch></note>
+      <note><pitch><step>E</step><octave>4</octave></pitch><duration>1</duration></note>
+      <note><grace/><pitch><step>D</step><octave>4</octave></pitch></note>
+      <note><pitch><step>D</step><octave>4</octave></pitch><duration>2</duration></note>
+    </measure>
+  </part>
+</score-partwise>`
+
+	song, err := parseMusicXML(strings.NewReader(score))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	if err := writeMIDI(&b, song, 120); err != nil {
+		t.Fatal(err)
+	}
+	_, notes := readMIDI(t, b.Bytes())
+
+	want := []midiNote{
+		{note: 60, velocity: 127, start: 0, length: 480},
+		{note: 64, velocity: 127, start: 480, length: 480},
+		{note: 62, velocity: 127, start: 960, length: 960},
+	}
+	if len(notes) != len(want) {
+		t.Fatalf("read back %+v, want %+v", notes, want)
+	}
+	for i := range want {
+		if notes[i] != want[i] {
+			t.Errorf("note %d is %+v, want %+v", i, notes[i], want[i])
+		}
+	}
+}
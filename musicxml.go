@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/tecnologer/SoundOfCode/synth"
+)
+
+// defaultTempo is used when a MusicXML file has no tempo marking
+const defaultTempo = 120
+
+type xmlSound struct {
+	Tempo float64 `xml:"tempo,attr"`
+}
+
+type xmlScore struct {
+	Parts []struct {
+		Measures []struct {
+			Divisions  int `xml:"attributes>divisions"`
+			Directions []struct {
+				Sound []xmlSound `xml:"sound"`
+			} `xml:"direction"`
+			Sound []xmlSound `xml:"sound"`
+			Notes []struct {
+				Chord *struct{} `xml:"chord"`
+				Rest  *struct{} `xml:"rest"`
+				Pitch struct {
+					Step   string  `xml:"step"`
+					Alter  float64 `xml:"alter"`
+					Octave int     `xml:"octave"`
+				} `xml:"pitch"`
+				Duration int `xml:"duration"`
+			} `xml:"note"`
+		} `xml:"measure"`
+	} `xml:"part"`
+}
+
+// parseMusicXML reads the first part of a partwise MusicXML score as a
+// monophonic track. Chord notes other than the first are dropped
+func parseMusicXML(r io.Reader) (*Song, error) {
+	var score xmlScore
+	if err := xml.NewDecoder(r).Decode(&score); err != nil {
+		return nil, err
+	}
+
+	if len(score.Parts) == 0 {
+		return nil, errors.New("MusicXML score has no parts")
+	}
+
+	divisions, tempo := 1, float64(defaultTempo)
+	var track Track
+	for m, measure := range score.Parts[0].Measures {
+		if measure.Divisions > 0 {
+			divisions = measure.Divisions
+		}
+
+		sounds := measure.Sound
+		for _, d := range measure.Directions {
+			sounds = append(sounds, d.Sound...)
+		}
+		for _, s := range sounds {
+			if s.Tempo > 0 {
+				tempo = s.Tempo
+			}
+		}
+
+		for _, note := range measure.Notes {
+			if note.Chord != nil {
+				continue
+			}
+
+			beats := float64(note.Duration) / float64(divisions)
+			duration := time.Duration(math.Round(beats * float64(time.Minute) / tempo))
+
+			key := 0
+			if note.Rest == nil {
+				semitone, ok := synth.Semitone(firstByte(note.Pitch.Step))
+				if !ok {
+					return nil, fmt.Errorf("measure %d: invalid step %q", m+1, note.Pitch.Step)
+				}
+
+				// notes out of the piano range are kept for -transpose-to-fit,
+				// except G#0 that would end up as key 0, a rest
+				key = synth.KeyFor(semitone+int(math.Round(note.Pitch.Alter)), note.Pitch.Octave)
+				if key == 0 {
+					return nil, fmt.Errorf("measure %d: %s%d is out of the piano range", m+1, note.Pitch.Step, note.Pitch.Octave)
+				}
+			}
+
+			track.Notes = append(track.Notes, Note{Key: key, Duration: duration})
+		}
+	}
+
+	return &Song{Tracks: []Track{track}}, nil
+}
+
+func firstByte(s string) byte {
+	if s == "" {
+		return 0
+	}
+	return s[0]
+}
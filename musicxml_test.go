@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// minimalScore is a two measure MusicXML score: a C major arpeggio at 90
+// BPM with a rest, a chord and accidentals
+const minimalScore = `<?xml version="1.0" encoding="UTF-8"?>
+<score-partwise version="3.1">
+  <part-list><score-part id="P1"><part-name>Piano</part-name></score-part></part-list>
+  <part id="P1">
+    <measure number="1">
+      <attributes><divisions>2</divisions></attributes>
+      <direction><sound tempo="90"/></direction>
+      <note><pitch><step>C</step><octave>4</octave></pitch><duration>2</duration></note>
+      <note><pitch><step>E</step><octave>4</octave></pitch><duration>1</duration></note>
+      <note><pitch><step>G</step><octave>4</octave></pitch><duration>1</duration></note>
+      <note><rest/><duration>4</duration></note>
+    </measure>
+    <measure number="2">
+      <sound tempo="120"/>
+      <note><pitch><step>F</step><alter>1</alter><octave>3</octave></pitch><duration>3</duration></note>
+      <note><chord/><pitch><step>A</step><octave>3</octave></pitch><duration>3</duration></note>
+      <note><pitch><step>B</step><alter>-1</alter><octave>5</octave></pitch><duration>8</duration></note>
+    </measure>
+  </part>
+</score-partwise>`
+
+func TestParseMusicXML(t *testing.T) {
+	song, err := parseMusicXML(strings.NewReader(minimalScore))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(song.Tracks) != 1 {
+		t.Fatalf("%d tracks, want 1", len(song.Tracks))
+	}
+
+	// two divisions per beat, a beat lasting 666.67ms at 90 BPM and 500ms
+	// at 120 BPM; the chord note is dropped
+	want := []Note{
+		{Key: 40, Duration: 666666667},
+		{Key: 44, Duration: 333333333},
+		{Key: 47, Duration: 333333333},
+		{Key: 0, Duration: 1333333333},
+		{Key: 34, Duration: 750 * time.Millisecond},
+		{Key: 62, Duration: 2 * time.Second},
+	}
+
+	notes := song.Tracks[0].Notes
+	if len(notes) != len(want) {
+		t.Fatalf("%d notes, want %d: %+v", len(notes), len(want), notes)
+	}
+	for i := range want {
+		if notes[i].Key != want[i].Key || notes[i].Duration != want[i].Duration {
+			t.Errorf("note %d = %+v, want %+v", i, notes[i], want[i])
+		}
+	}
+}
+
+func TestParseMusicXMLErrors(t *testing.T) {
+	tests := []struct {
+		name, xml string
+	}{
+		{"not XML", "C4:q"},
+		{"no parts", `<score-partwise></score-partwise>`},
+		{"bad step", `<score-partwise><part><measure><note><pitch><step>H</step><octave>4</octave></pitch><duration>1</duration></note></measure></part></score-partwise>`},
+		{"G#0", `<score-partwise><part><measure><note><pitch><step>G</step><alter>1</alter><octave>0</octave></pitch><duration>1</duration></note></measure></part></score-partwise>`},
+	}
+
+	for _, tt := range tests {
+		if _, err := parseMusicXML(strings.NewReader(tt.xml)); err == nil {
+			t.Errorf("%s: should fail", tt.name)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tecnologer/SoundOfCode/synth"
+)
+
+// Note is a piano key held for Duration. Key 0 is a rest
+type Note struct {
+	Key      int
+	Duration time.Duration
+	// Velocity is how hard the note is played, from 0 to 1. The zero
+	// value means full strength
+	Velocity float64
+	// Bend glides the pitch of the note while it is held, nil keeps it
+	// steady
+	Bend *PitchBend
+}
+
+// PitchBend moves the pitch of a note from Start to End cents off its
+// key over the time it is held, following Curve
+type PitchBend struct {
+	Start, End float64
+	Curve      string
+}
+
+// bendCurves shape the glide, x going from 0 to 1 over the note: lin
+// moves evenly in cents, fast does most of the move early and slow late
+var bendCurves = map[string]func(x float64) float64{
+	"lin":  func(x float64) float64 { return x },
+	"fast": func(x float64) float64 { return 1 - (1-x)*(1-x) },
+	"slow": func(x float64) float64 { return x * x },
+}
+
+// Cents is the pitch offset at fraction x of the held note
+func (b *PitchBend) Cents(x float64) float64 {
+	x = math.Max(0, math.Min(1, x))
+	return b.Start + (b.End-b.Start)*bendCurves[b.Curve](x)
+}
+
+// parseBend parses "start,end" or "start,end,curve" in cents, e.g.
+// "200,0" or "0,-1200,fast"
+func parseBend(spec string) (*PitchBend, error) {
+	parts := strings.Split(spec, ",")
+	if len(parts) < 2 || len(parts) > 3 {
+		return nil, fmt.Errorf("invalid pitch bend %q", spec)
+	}
+
+	start, err1 := strconv.ParseFloat(parts[0], 64)
+	end, err2 := strconv.ParseFloat(parts[1], 64)
+	if err1 != nil || err2 != nil {
+		return nil, fmt.Errorf("invalid pitch bend %q", spec)
+	}
+
+	bend := &PitchBend{Start: start, End: end, Curve: "lin"}
+	if len(parts) == 3 {
+		bend.Curve = parts[2]
+	}
+	if _, ok := bendCurves[bend.Curve]; !ok {
+		return nil, fmt.Errorf("unknown pitch bend curve %q, use lin, fast or slow", bend.Curve)
+	}
+	return bend, nil
+}
+
+// strength is the velocity of the note, 1 when unset
+func (n Note) strength() float64 {
+	if n.Velocity <= 0 {
+		return 1
+	}
+	return n.Velocity
+}
+
+// noteBeats is the length in beats of each duration code
+var noteBeats = map[byte]float64{'w': 4, 'h': 2, 'q': 1, 'e': 0.5, 's': 0.25}
+
+// noteDuration converts a duration code to time at the given bpm.
+// Codes are w, h, q, e or s, optionally followed by "." (dotted, 1.5x)
+// or "t" (triplet, 2/3x). A number of beats like "1.5b" also follows the
+// tempo while an absolute time like "500ms" doesn't
+func noteDuration(code string, bpm int) (time.Duration, error) {
+	if code == "" || bpm <= 0 {
+		return 0, fmt.Errorf("invalid duration %q at %d bpm", code, bpm)
+	}
+
+	beat := float64(time.Minute) / float64(bpm)
+	if c := code[0]; c >= '0' && c <= '9' || c == '.' {
+		if strings.HasSuffix(code, "b") {
+			beats, err := strconv.ParseFloat(strings.TrimSuffix(code, "b"), 64)
+			if err != nil || beats <= 0 {
+				return 0, fmt.Errorf("invalid beat count %q", code)
+			}
+			return time.Duration(math.Round(beats * beat)), nil
+		}
+
+		d, err := time.ParseDuration(code)
+		if err != nil || d <= 0 {
+			return 0, fmt.Errorf("invalid duration %q", code)
+		}
+		return d, nil
+	}
+
+	beats, ok := noteBeats[code[0]]
+	if !ok {
+		return 0, fmt.Errorf("unknown duration code %q", code)
+	}
+
+	switch code[1:] {
+	case "":
+	case ".":
+		beats *= 1.5
+	case "t":
+		beats *= 2.0 / 3.0
+	default:
+		return 0, fmt.Errorf("unknown duration modifier in %q", code)
+	}
+
+	return time.Duration(math.Round(beats * beat)), nil
+}
+
+// ParseScore parses a space separated list of notes like "C4:q. D4:e R:q",
+// where R is a rest. Durations can also be beats or times, "C4:1.5b" or
+// "C4:500ms". A note can end with @velocity, e.g. "C4:q@0.5", and then
+// with a pitch bend in cents, e.g. "C4:q~200,0" or "C4:q@0.5~0,-100,fast"
+func ParseScore(score string, bpm int) ([]Note, error) {
+	var notes []Note
+	for _, token := range strings.Fields(score) {
+		var bend *PitchBend
+		if tilde := strings.IndexByte(token, '~'); tilde >= 0 {
+			var err error
+			bend, err = parseBend(token[tilde+1:])
+			if err != nil {
+				return nil, err
+			}
+			token = token[:tilde]
+		}
+
+		parts := strings.SplitN(token, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("missing duration in %q", token)
+		}
+
+		key := 0
+		if parts[0] != "R" {
+			var err error
+			key, err = synth.ParseNote(parts[0])
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		code, velocity := parts[1], 0.0
+		if at := strings.IndexByte(code, '@'); at >= 0 {
+			var err error
+			velocity, err = strconv.ParseFloat(code[at+1:], 64)
+			if err != nil || velocity <= 0 || velocity > 1 {
+				return nil, fmt.Errorf("invalid velocity in %q", token)
+			}
+			code = code[:at]
+		}
+
+		duration, err := noteDuration(code, bpm)
+		if err != nil {
+			return nil, err
+		}
+
+		notes = append(notes, Note{Key: key, Duration: duration, Velocity: velocity, Bend: bend})
+	}
+
+	return notes, nil
+}
+
+// OctaveUp returns the key one octave higher, clamped to the last key
+func OctaveUp(key int) int {
+	return synth.ClampKey(key + 12)
+}
+
+// OctaveDown returns the key one octave lower, clamped to the first key
+func OctaveDown(key int) int {
+	return synth.ClampKey(key - 12)
+}
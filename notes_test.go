@@ -0,0 +1,229 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/tecnologer/SoundOfCode/synth"
+)
+
+func TestNoteDuration(t *testing.T) {
+	tests := []struct {
+		code string
+		bpm  int
+		want time.Duration
+	}{
+		{"q", 120, 500 * time.Millisecond},
+		{"q.", 120, 750 * time.Millisecond},
+		{"et", 120, 166666667},
+		{"w", 120, 2 * time.Second},
+		{"h.", 60, 3 * time.Second},
+		{"qt", 90, 444444444},
+		{"s", 120, 125 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		got, err := noteDuration(tt.code, tt.bpm)
+		if err != nil {
+			t.Errorf("noteDuration(%q, %d): %v", tt.code, tt.bpm, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("noteDuration(%q, %d) = %v, want %v", tt.code, tt.bpm, got, tt.want)
+		}
+	}
+}
+
+func TestNoteDurationTripletIsAThirdOfABeat(t *testing.T) {
+	beat, _ := noteDuration("q", 120)
+	triplet, _ := noteDuration("et", 120)
+	if diff := 3*triplet - beat; diff < -time.Nanosecond || diff > time.Nanosecond {
+		t.Errorf("three eighth triplets last %v, a beat %v", 3*triplet, beat)
+	}
+}
+
+func TestNoteDurationInvalid(t *testing.T) {
+	for _, code := range []string{"", "x", "q..", "qx", "et."} {
+		if _, err := noteDuration(code, 120); err == nil {
+			t.Errorf("noteDuration(%q) should fail", code)
+		}
+	}
+}
+
+func TestParseScore(t *testing.T) {
+	notes, err := ParseScore("C4:q. D4:et R:q A4:w", 120)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Note{
+		{Key: 40, Duration: 750 * time.Millisecond},
+		{Key: 42, Duration: 166666667},
+		{Key: 0, Duration: 500 * time.Millisecond},
+		{Key: 49, Duration: 2 * time.Second},
+	}
+	if len(notes) != len(want) {
+		t.Fatalf("got %d notes, want %d", len(notes), len(want))
+	}
+	for i := range want {
+		if notes[i].Key != want[i].Key || notes[i].Duration != want[i].Duration {
+			t.Errorf("note %d = %+v, want %+v", i, notes[i], want[i])
+		}
+	}
+}
+
+func TestOctaves(t *testing.T) {
+	tests := []struct {
+		key      int
+		up, down int
+	}{
+		{40, 52, 28},
+		{49, 61, 37},
+		{1, 13, 1},
+		{12, 24, 1},
+		{80, 88, 68},
+		{88, 88, 76},
+	}
+
+	for _, tt := range tests {
+		if got := OctaveUp(tt.key); got != tt.up {
+			t.Errorf("OctaveUp(%d) = %d, want %d", tt.key, got, tt.up)
+		}
+		if got := OctaveDown(tt.key); got != tt.down {
+			t.Errorf("OctaveDown(%d) = %d, want %d", tt.key, got, tt.down)
+		}
+	}
+}
+
+func TestOctaveUpDoublesTheFrequency(t *testing.T) {
+	for key := 1; key+12 <= synth.TotalKeys; key++ {
+		up, down := synth.KeyFrequency(OctaveUp(key)), synth.KeyFrequency(key)
+		if math.Abs(up/down-2) > 1e-12 {
+			t.Errorf("key %d: %gHz an octave up from %gHz", key, up, down)
+		}
+	}
+}
+
+func TestSongMapKeysSkipsRests(t *testing.T) {
+	song, err := ParseSong("C4:q R:q | A4:h", 120)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	song.MapKeys(OctaveUp)
+	for i, want := range [][]int{{52, 0}, {61}} {
+		for j, key := range want {
+			if got := song.Tracks[i].Notes[j].Key; got != key {
+				t.Errorf("track %d note %d: key %d, want %d", i+1, j, got, key)
+			}
+		}
+	}
+}
+
+func TestNoteDurationInBeats(t *testing.T) {
+	tests := []struct {
+		code string
+		bpm  int
+		want time.Duration
+	}{
+		{"1b", 120, 500 * time.Millisecond},
+		{"1.5b", 120, 750 * time.Millisecond},
+		{"1.5b", 60, 1500 * time.Millisecond},
+		{"0.25b", 90, 166666667},
+		{".5b", 120, 250 * time.Millisecond},
+		// absolute times ignore the tempo
+		{"500ms", 60, 500 * time.Millisecond},
+		{"500ms", 240, 500 * time.Millisecond},
+		{"1.2s", 120, 1200 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		got, err := noteDuration(tt.code, tt.bpm)
+		if err != nil {
+			t.Errorf("noteDuration(%q, %d): %v", tt.code, tt.bpm, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("noteDuration(%q, %d) = %v, want %v", tt.code, tt.bpm, got, tt.want)
+		}
+	}
+
+	for _, code := range []string{"0b", "-1b", "1.5.b", "1bb", "0ms", "5", "2x"} {
+		if d, err := noteDuration(code, 120); err == nil {
+			t.Errorf("noteDuration(%q) = %v, should fail", code, d)
+		}
+	}
+}
+
+func TestBeatDurationsScaleWithTheTempo(t *testing.T) {
+	// beats scale inversely with the tempo, absolute times stay put
+	const score = "C4:1.5b D4:250ms E4:q R:2b"
+	slow, err := ParseScore(score, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fast, err := ParseScore(score, 150)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, ratio := range []float64{2.5, 1, 2.5, 2.5} {
+		if got := float64(slow[i].Duration) / float64(fast[i].Duration); math.Abs(got-ratio) > 1e-6 {
+			t.Errorf("note %d: %v at 60 BPM and %v at 150, want a ratio of %g", i, slow[i].Duration, fast[i].Duration, ratio)
+		}
+	}
+}
+
+func TestParseScoreBends(t *testing.T) {
+	tests := []struct {
+		token string
+		bend  *PitchBend
+	}{
+		{"C4:q", nil},
+		{"C4:q~200,0", &PitchBend{Start: 200, End: 0, Curve: "lin"}},
+		{"C4:q@0.5~0,-100,fast", &PitchBend{Start: 0, End: -100, Curve: "fast"}},
+		{"C4:1.5b~-50,50,slow", &PitchBend{Start: -50, End: 50, Curve: "slow"}},
+	}
+
+	for _, tt := range tests {
+		notes, err := ParseScore(tt.token, 120)
+		if err != nil {
+			t.Errorf("ParseScore(%q): %v", tt.token, err)
+			continue
+		}
+		if bend := notes[0].Bend; (bend == nil) != (tt.bend == nil) || bend != nil && *bend != *tt.bend {
+			t.Errorf("ParseScore(%q) bends %+v, want %+v", tt.token, bend, tt.bend)
+		}
+	}
+
+	for _, token := range []string{"C4:q~", "C4:q~200", "C4:q~200,x", "C4:q~0,100,exp", "C4:q~1,2,lin,4"} {
+		if _, err := ParseScore(token, 120); err == nil {
+			t.Errorf("ParseScore(%q) should fail", token)
+		}
+	}
+}
+
+func TestPitchBendCents(t *testing.T) {
+	tests := []struct {
+		curve string
+		x     float64
+		want  float64
+	}{
+		{"lin", 0, 200},
+		{"lin", 0.5, 100},
+		{"lin", 1, 0},
+		{"fast", 0.5, 50},
+		{"slow", 0.5, 150},
+		// outside the note it holds its ends
+		{"lin", -1, 200},
+		{"lin", 2, 0},
+	}
+
+	for _, tt := range tests {
+		b := &PitchBend{Start: 200, End: 0, Curve: tt.curve}
+		if got := b.Cents(tt.x); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s at %g: %g cents, want %g", tt.curve, tt.x, got, tt.want)
+		}
+	}
+}
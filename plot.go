@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/tecnologer/SoundOfCode/synth"
+)
+
+const (
+	plotWidth  = 72
+	plotHeight = 16
+)
+
+// plotASCII draws values between min and max as columns of '*' characters,
+// one column per value
+func plotASCII(w io.Writer, values []float64, min, max float64, height int) {
+	rows := make([][]byte, height)
+	for r := range rows {
+		rows[r] = []byte(strings.Repeat(" ", len(values)))
+	}
+
+	for c, v := range values {
+		r := int(math.Round((v - min) / (max - min) * float64(height-1)))
+		if r < 0 {
+			r = 0
+		}
+		if r > height-1 {
+			r = height - 1
+		}
+		rows[height-1-r][c] = '*'
+	}
+
+	for r, row := range rows {
+		label := max - (max-min)*float64(r)/float64(height-1)
+		fmt.Fprintf(w, "%5.2f |%s\n", label, row)
+	}
+	fmt.Fprintf(w, "      +%s\n", strings.Repeat("-", len(values)))
+}
+
+// plotEnvelope plots the envelope of a note held for held seconds,
+// including its release
+func plotEnvelope(w io.Writer, env synth.Envelope, held float64) {
+	length := held + env.Release.Seconds()
+	values := make([]float64, plotWidth)
+	for i := range values {
+		t := length * float64(i) / float64(plotWidth-1)
+		values[i] = env.Amplitude(t, held)
+	}
+
+	plotASCII(w, values, 0, 1, plotHeight)
+	fmt.Fprintf(w, "       0s%*s\n", plotWidth-2, fmt.Sprintf("%.3fs", length))
+}
+
+// writeSamplesCSV writes the channels one frame per row, the time in
+// seconds followed by a column per channel, at full float precision
+func writeSamplesCSV(w io.Writer, channels [][]float64) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("time")
+	for ch := range channels {
+		fmt.Fprintf(bw, ",ch%d", ch+1)
+	}
+	bw.WriteString("\n")
+
+	for i := range channels[0] {
+		bw.WriteString(strconv.FormatFloat(float64(i)/SampleRate, 'f', 6, 64))
+		for _, samples := range channels {
+			bw.WriteByte(',')
+			bw.WriteString(strconv.FormatFloat(samples[i], 'g', -1, 64))
+		}
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
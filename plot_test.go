@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tecnologer/SoundOfCode/synth"
+)
+
+// plotted reads the plot back: the row of the '*' of every column, 0
+// being the bottom one
+func plotted(tb testing.TB, plot string) []int {
+	tb.Helper()
+	lines := strings.Split(plot, "\n")[:plotHeight]
+
+	heights := make([]int, plotWidth)
+	for c := range heights {
+		heights[c] = -1
+	}
+	for r, line := range lines {
+		bar := strings.IndexByte(line, '|')
+		for c, b := range line[bar+1:] {
+			if b == '*' {
+				heights[c] = plotHeight - 1 - r
+			}
+		}
+	}
+
+	for c, h := range heights {
+		if h < 0 {
+			tb.Fatalf("column %d has no point", c)
+		}
+	}
+	return heights
+}
+
+func TestPlotEnvelope(t *testing.T) {
+	tests := []struct {
+		env  synth.Envelope
+		held float64
+	}{
+		{synth.Envelope{Attack: 100 * time.Millisecond, Decay: 100 * time.Millisecond, Sustain: 0.5, Release: 200 * time.Millisecond}, 0.5},
+		{synth.Envelope{Attack: 300 * time.Millisecond, Sustain: 1, Release: 100 * time.Millisecond}, 0.6},
+		{synth.Envelope{Attack: 50 * time.Millisecond, Decay: 50 * time.Millisecond, Sustain: 0.2, Release: time.Second}, 0.2},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		plotEnvelope(&buf, tt.env, tt.held)
+		heights := plotted(t, buf.String())
+
+		// the first column at the top is the end of the attack
+		length := tt.held + tt.env.Release.Seconds()
+		peak := 0
+		for c, h := range heights {
+			if h > heights[peak] {
+				peak = c
+			}
+		}
+		if heights[peak] != plotHeight-1 {
+			t.Errorf("%+v: peaks at row %d, want the top one", tt.env, heights[peak])
+		}
+		at := float64(peak) / float64(plotWidth-1) * length
+		if step := length / float64(plotWidth-1); math.Abs(at-tt.env.Attack.Seconds()) > step {
+			t.Errorf("%+v: peaks at %.3fs, the attack is %v", tt.env, at, tt.env.Attack)
+		}
+
+		// it starts and ends at zero, and never rises after the peak
+		// once the release starts
+		if heights[0] != 0 || heights[plotWidth-1] != 0 {
+			t.Errorf("%+v: starts at row %d and ends at row %d, want 0", tt.env, heights[0], heights[plotWidth-1])
+		}
+		for c := peak + 1; c < plotWidth; c++ {
+			if heights[c] > heights[c-1] {
+				t.Errorf("%+v: rises again at column %d", tt.env, c)
+			}
+		}
+	}
+}
+
+func TestWriteSamplesCSV(t *testing.T) {
+	tests := []struct {
+		config   string
+		score    string
+		channels int
+	}{
+		{"channel-count=1,attack=10ms,release=20ms", "C4:e", 1},
+		{"channel-count=2,attack=5ms,release=50ms", "A4:s E5:s", 2},
+	}
+
+	for _, tt := range tests {
+		channels := renderWith(t, tt.config, tt.score)
+		var b bytes.Buffer
+		if err := writeSamplesCSV(&b, channels); err != nil {
+			t.Fatal(err)
+		}
+
+		rows := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+		header := "time,ch1"
+		if tt.channels == 2 {
+			header = "time,ch1,ch2"
+		}
+		if rows[0] != header {
+			t.Errorf("%s: header %q, want %q", tt.score, rows[0], header)
+		}
+
+		// a row per frame, the note fading in from and out to silence
+		rows = rows[1:]
+		if len(rows) != len(channels[0]) {
+			t.Fatalf("%s: %d rows, want %d", tt.score, len(rows), len(channels[0]))
+		}
+		for _, i := range []int{0, len(rows) - 1} {
+			fields := strings.Split(rows[i], ",")
+			if len(fields) != tt.channels+1 {
+				t.Fatalf("%s: row %d is %q, want %d columns", tt.score, i, rows[i], tt.channels+1)
+			}
+			if want := strconv.FormatFloat(float64(i)/SampleRate, 'f', 6, 64); fields[0] != want {
+				t.Errorf("%s: row %d at %s, want %s", tt.score, i, fields[0], want)
+			}
+			for ch, field := range fields[1:] {
+				v, err := strconv.ParseFloat(field, 64)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if math.Abs(v) > 0.01 {
+					t.Errorf("%s: row %d channel %d is %v, want it near zero", tt.score, i, ch+1, v)
+				}
+			}
+		}
+
+		// at full precision, every value reads back as it was
+		for i := 0; i < len(rows); i += 97 {
+			v, _ := strconv.ParseFloat(strings.Split(rows[i], ",")[1], 64)
+			if v != channels[0][i] {
+				t.Errorf("%s: row %d reads back %v, was %v", tt.score, i, v, channels[0][i])
+				break
+			}
+		}
+	}
+}
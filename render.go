@@ -0,0 +1,364 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/tecnologer/SoundOfCode/synth"
+)
+
+// pitchDriftStep is how often driftCents picks a new tuning offset
+const pitchDriftStep = 100 * time.Millisecond
+
+// span is a note placed on the timeline. It is held from start up to
+// release and keeps sounding until end while its release fades out, on
+// top of the notes that follow. phase is where the oscillator is at
+// start, so the wave continues smoothly from the previous note
+type span struct {
+	start, release, end int
+	// note is the index of the note played in the list given to schedule
+	note      int
+	frequency float64
+	phase     float64
+	velocity  float64
+	// bend is how far the oscillator has gone since start at every sample
+	// of a pitch bent note, nil when the pitch is steady
+	bend []float64
+	// strings are the samples from start to end of the plucked string
+	// layers of the instrument, by layer, nil for the other layers
+	strings [][]float64
+}
+
+// timing is how schedule lays the notes out and tunes them
+type timing struct {
+	// Gap is the silence between consecutive notes
+	Gap time.Duration
+	// Articulation is the fraction of every note that is held, the rest
+	// of its slot is left to the release and silence
+	Articulation float64
+	// ZeroCross starts every note at phase zero and rounds its length to
+	// the nearest whole number of periods, so it also ends on a zero
+	// crossing
+	ZeroCross bool
+	// Clock is the oscillator time at every sample, see driftClock, nil
+	// being steady time
+	Clock []float64
+	// NoteJitter gives every note a pitch drift of its own of up to that
+	// many cents, seeded with Seed plus the note index
+	NoteJitter float64
+	Seed       int64
+}
+
+// schedule places the notes one after the other and returns the spans and
+// the total length in samples, including the last release tail. Notes
+// held for no sample at all, like MusicXML grace notes, are not played,
+// not even their release
+func schedule(notes []Note, in Instrument, t timing) (spans []span, total int) {
+	tail := int(in.Release().Seconds() * SampleRate)
+	silence := int(t.Gap.Seconds() * SampleRate)
+	phase := 0.0
+	position := 0
+	for i, note := range notes {
+		frequency := 0.0
+		if note.Key > 0 {
+			frequency = tuning.Frequency(note.Key)
+		}
+
+		length := int(note.Duration.Seconds() * SampleRate)
+		if t.ZeroCross && frequency > 0 {
+			periods := math.Max(1, math.Round(note.Duration.Seconds()*frequency))
+			length = int(math.Round(periods * SampleRate / frequency))
+			phase = 0
+		}
+
+		held := int(float64(length) * t.Articulation)
+		bent := frequency > 0 && held > 0 && (note.Bend != nil || t.NoteJitter > 0)
+		if frequency == 0 {
+			// rests are silent, the next note starts from zero
+			phase = 0
+		} else if held > 0 {
+			s := span{
+				start:     position,
+				release:   position + held,
+				end:       position + held + tail,
+				note:      i,
+				frequency: frequency,
+				phase:     phase,
+				velocity:  note.strength(),
+			}
+			if bent {
+				s.bend = notePhases(s, length, t.Clock, noteCents(note, s, length, t, int64(i)))
+			}
+			s.strings = pluckStrings(in, frequency, s.end-s.start, uint64(t.Seed)+uint64(i))
+			spans = append(spans, s)
+		}
+
+		if bent {
+			phase += spans[len(spans)-1].bend[length]
+		} else {
+			phase += τ * frequency * (elapsed(t.Clock, position+length) - elapsed(t.Clock, position))
+		}
+		position += length
+
+		if silence > 0 && i < len(notes)-1 {
+			// the next note starts from zero after the silence
+			position += silence
+			phase = 0
+		}
+	}
+
+	total = position
+	if len(spans) > 0 && spans[len(spans)-1].end > total {
+		total = spans[len(spans)-1].end
+	}
+
+	return
+}
+
+// noteCents returns the pitch offset in cents of the note at every sample
+// of its span: its bend plus its own drift
+func noteCents(note Note, s span, length int, t timing, index int64) func(i int) float64 {
+	held := float64(s.release - s.start)
+
+	var drift []float64
+	if t.NoteJitter > 0 {
+		drift = driftCents(phaseLength(s, length)+1, t.NoteJitter, rand.New(rand.NewSource(t.Seed+index)))
+	}
+
+	return func(i int) float64 {
+		cents := 0.0
+		if note.Bend != nil {
+			cents = note.Bend.Cents(float64(i) / held)
+		}
+		if drift != nil {
+			cents += drift[i]
+		}
+		return cents
+	}
+}
+
+// phaseLength is how many samples notePhases covers: the note slot or the
+// span, the longest
+func phaseLength(s span, length int) int {
+	if s.end-s.start > length {
+		return s.end - s.start
+	}
+	return length
+}
+
+// notePhases returns how far the oscillator of the span has gone at every
+// sample from start up to length samples or to end if later, with its
+// pitch off by cents(i) at sample i
+func notePhases(s span, length int, clock []float64, cents func(i int) float64) []float64 {
+	phases := make([]float64, phaseLength(s, length)+1)
+	for i := 1; i < len(phases); i++ {
+		p := s.start + i - 1
+		step := elapsed(clock, p+1) - elapsed(clock, p)
+		phases[i] = phases[i-1] + τ*s.frequency*math.Pow(2, cents(i-1)/1200)*step
+	}
+	return phases
+}
+
+// pluckStrings renders the Karplus-Strong layers of the instrument for a
+// note at frequency, length samples long. Strings can't be computed one
+// sample at a time like the other layers, so they are rendered ahead
+func pluckStrings(in Instrument, frequency float64, length int, seed uint64) [][]float64 {
+	var strings [][]float64
+	for i, l := range in {
+		if l.String <= 0 {
+			continue
+		}
+		if strings == nil {
+			strings = make([][]float64, len(in))
+		}
+
+		pluck := synth.NewPluck(frequency*l.Ratio, l.String, seed)
+		strings[i] = make([]float64, length)
+		for n := range strings[i] {
+			strings[i][n] = pluck.Next()
+		}
+	}
+	return strings
+}
+
+// stringAt reads the rendered string at fractional position x, 0 past
+// its end
+func stringAt(samples []float64, x float64) float64 {
+	i := int(x)
+	if i+1 >= len(samples) {
+		return 0
+	}
+	frac := x - float64(i)
+	return samples[i]*(1-frac) + samples[i+1]*frac
+}
+
+// skipTo drops the spans over before sample from, so a render cut there
+// starts with the notes sounding at that point and nothing earlier
+func skipTo(spans []span, from int) []span {
+	var kept []span
+	for _, s := range spans {
+		if s.release > from {
+			kept = append(kept, s)
+		}
+	}
+	return kept
+}
+
+// renderRange fills out with the samples starting at offset, with every
+// frequency scaled by ratio and every note low passed according to its
+// velocity and sensitivity. The oscillators run on clock. Every sample
+// only depends on its position, so any range can be rendered on its own
+func renderRange(spans []span, in Instrument, ratio, sensitivity float64, clock []float64, offset int, out []float64) {
+	// spans sounding somewhere in the range
+	var active []span
+	for _, s := range spans {
+		if s.start < offset+len(out) && s.end > offset {
+			active = append(active, s)
+		}
+	}
+
+	// the filters of the notes already playing are run over the samples
+	// before the range, so they are where a render from the start would
+	// have left them
+	filters := make([]*biquad, len(active))
+	for i, s := range active {
+		cutoff := veloCutoff(s.velocity, sensitivity)
+		if cutoff <= 0 || cutoff >= SampleRate/2 {
+			continue
+		}
+
+		filters[i] = newLowPass(cutoff, 1/math.Sqrt2, SampleRate)
+		for p := s.start; p < offset; p++ {
+			filters[i].Process(noteAt(s, in, ratio, clock, p))
+		}
+	}
+
+	for n := range out {
+		p := offset + n
+		out[n] = 0
+		for i, s := range active {
+			if p < s.start || p >= s.end {
+				continue
+			}
+
+			sample := noteAt(s, in, ratio, clock, p)
+			if filters[i] != nil {
+				sample = filters[i].Process(sample)
+			}
+			out[n] += sample
+		}
+	}
+}
+
+// noteAt returns the sample of the span at p, the layers of the
+// instrument summed at their level and envelope
+func noteAt(s span, in Instrument, ratio float64, clock []float64, p int) float64 {
+	t := float64(p-s.start) / SampleRate
+	held := float64(s.release-s.start) / SampleRate
+
+	sum := 0.0
+	for li, l := range in {
+		amplitude := s.velocity * l.Level * l.Envelope.Amplitude(t, held)
+		if s.strings != nil && s.strings[li] != nil {
+			// ratio detunes the string by reading it faster
+			sum += amplitude * stringAt(s.strings[li], float64(p-s.start)*ratio)
+			continue
+		}
+
+		travel := τ * s.frequency * (elapsed(clock, p) - elapsed(clock, s.start))
+		if s.bend != nil {
+			travel = s.bend[p-s.start]
+		}
+		phase := l.Ratio * ratio * (s.phase + travel)
+		if l.FM != nil {
+			phase += l.FM.Modulation(phase, t, held)
+		}
+		sum += amplitude * l.wave(phase, l.Ratio*ratio*s.frequency, t)
+	}
+	return sum
+}
+
+// renderChannel renders the samples of the timeline from offset on into
+// out, split in chunks across threads goroutines
+func renderChannel(spans []span, in Instrument, offset int, out []float64, threads int, ratio, sensitivity float64, clock []float64) {
+	if threads < 1 {
+		threads = 1
+	}
+
+	chunk := (len(out) + threads - 1) / threads
+	if chunk == 0 {
+		return
+	}
+
+	var wg sync.WaitGroup
+	for from := 0; from < len(out); from += chunk {
+		to := from + chunk
+		if to > len(out) {
+			to = len(out)
+		}
+
+		wg.Add(1)
+		go func(from, to int) {
+			defer wg.Done()
+			renderRange(spans, in, ratio, sensitivity, clock, offset+from, out[from:to])
+		}(from, to)
+	}
+	wg.Wait()
+}
+
+// veloCutoff maps a velocity to a low pass cutoff: full velocity leaves
+// the filter open at Nyquist and every step down closes it by up to sensitivity*8
+// octaves. A zero sensitivity disables the filter
+func veloCutoff(velocity, sensitivity float64) float64 {
+	if sensitivity <= 0 {
+		return 0
+	}
+	return SampleRate / 2 * math.Pow(2, -sensitivity*(1-velocity)*8)
+}
+
+// elapsed is the oscillator time in seconds at sample p, read from clock
+// or p/SampleRate when there is no clock
+func elapsed(clock []float64, p int) float64 {
+	if clock == nil {
+		return float64(p) / SampleRate
+	}
+	if p >= len(clock) {
+		p = len(clock) - 1
+	}
+	return clock[p]
+}
+
+// driftCents returns n samples of a tuning that wanders up to cents away
+// from pitch in a slow random walk, one step every pitchDriftStep with
+// straight lines in between
+func driftCents(n int, cents float64, rng *rand.Rand) []float64 {
+	step := int(pitchDriftStep.Seconds() * SampleRate)
+	points := make([]float64, n/step+2)
+	for i := 1; i < len(points); i++ {
+		// a random walk pulled back to the center so it stays in range
+		next := points[i-1]*0.9 + rng.NormFloat64()*cents/4
+		points[i] = math.Max(-cents, math.Min(cents, next))
+	}
+
+	offsets := make([]float64, n)
+	for p := range offsets {
+		i, f := p/step, float64(p%step)/float64(step)
+		offsets[p] = points[i] + (points[i+1]-points[i])*f
+	}
+	return offsets
+}
+
+// driftClock returns the oscillator time at every sample from 0 to total
+// when the tuning follows driftCents. Running oscillators on it bends
+// every note the same way at the same moment, like an analog synth
+// drifting
+func driftClock(total int, cents float64, rng *rand.Rand) []float64 {
+	offsets := driftCents(total, cents, rng)
+	clock := make([]float64, total+1)
+	for p := 1; p <= total; p++ {
+		clock[p] = clock[p-1] + math.Pow(2, offsets[p-1]/1200)/SampleRate
+	}
+	return clock
+}
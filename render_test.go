@@ -0,0 +1,825 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tecnologer/SoundOfCode/synth"
+)
+
+// scheduleScore schedules a score for an instrument, with every note held
+// for its whole length
+func scheduleScore(tb testing.TB, score string, in Instrument) ([]span, int) {
+	tb.Helper()
+	notes, err := ParseScore(score, 120)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	spans, total := schedule(notes, in, timing{Articulation: 1})
+	return spans, total
+}
+
+// sine is the plain single layer instrument played without -instrument
+func sine(env synth.Envelope) Instrument {
+	in, _ := instrument("", env)
+	return in
+}
+
+func TestRenderChannelThreadsAreBitIdentical(t *testing.T) {
+	env := synth.Envelope{Attack: 10 * time.Millisecond, Decay: 50 * time.Millisecond, Sustain: 0.7, Release: 100 * time.Millisecond}
+	spans, total := scheduleScore(t, "C4:e@0.5 E4:s G4:et@0.8 R:s C5:q.@0.2 A3:h", sine(env))
+
+	// the velocity filters too, whatever chunk they start in
+	for _, sensitivity := range []float64{0, 1} {
+		serial := make([]float64, total)
+		renderChannel(spans, sine(env), 0, serial, 1, 1, sensitivity, nil)
+
+		for _, threads := range []int{2, 3, 4, 7, 16} {
+			parallel := make([]float64, total)
+			renderChannel(spans, sine(env), 0, parallel, threads, 1, sensitivity, nil)
+			for i := range serial {
+				if parallel[i] != serial[i] {
+					t.Fatalf("sensitivity %g, %d threads: sample %d is %v, serial is %v", sensitivity, threads, i, parallel[i], serial[i])
+				}
+			}
+		}
+	}
+}
+
+func TestRenderChannelOffset(t *testing.T) {
+	in := sine(synth.Envelope{Release: 50 * time.Millisecond})
+	spans, total := scheduleScore(t, "C4:q E4:q", in)
+
+	whole := make([]float64, total)
+	renderChannel(spans, in, 0, whole, 1, 1, 0, nil)
+
+	// rendering block by block gives the same samples
+	for from := 0; from < total; from += 1000 {
+		to := from + 1000
+		if to > total {
+			to = total
+		}
+		block := make([]float64, to-from)
+		renderChannel(spans, in, from, block, 3, 1, 0, nil)
+		for i, s := range block {
+			if s != whole[from+i] {
+				t.Fatalf("sample %d is %v in a block, %v whole", from+i, s, whole[from+i])
+			}
+		}
+	}
+}
+
+func BenchmarkRender(b *testing.B) {
+	in := sine(synth.Envelope{Attack: 10 * time.Millisecond, Release: 200 * time.Millisecond})
+	spans, total := scheduleScore(b, "C4:q E4:q G4:q C5:q A3:h F3:h", in)
+	out := make([]float64, total)
+
+	for _, threads := range []int{1, 4} {
+		b.Run(fmt.Sprintf("threads=%d", threads), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				renderChannel(spans, in, 0, out, threads, 1, 0, nil)
+			}
+		})
+	}
+}
+
+// renderWith renders the song score with the flags overridden by config,
+// see withFlags
+func renderWith(tb testing.TB, config, score string) [][]float64 {
+	tb.Helper()
+	song, err := ParseSong(score, 120)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	var channels [][]float64
+	if err := withFlags(config, func() { channels = renderSong(song) }); err != nil {
+		tb.Fatal(err)
+	}
+	return channels
+}
+
+func TestPhaseContinuesAcrossNotes(t *testing.T) {
+	in := sine(synth.Envelope{Sustain: 1})
+	for _, score := range []string{"C4:q E4:q", "A4:e. C#5:s A3:et", "C2:s C7:s C2:s"} {
+		spans, total := scheduleScore(t, score, in)
+		out := make([]float64, total)
+		renderChannel(spans, in, 0, out, 1, 1, 0, nil)
+
+		for _, s := range spans[1:] {
+			// a sine moves at most τf/SampleRate from one sample to the next
+			// at the faster of the two notes
+			slope := 0.0
+			for _, o := range spans {
+				slope = math.Max(slope, τ*o.frequency/SampleRate)
+			}
+
+			if s.phase == 0 {
+				t.Errorf("%q: the note at %d starts from phase 0", score, s.start)
+			}
+			for n := s.start - 2; n <= s.start+2; n++ {
+				if jump := math.Abs(out[n] - out[n-1]); jump > slope {
+					t.Errorf("%q: the wave jumps %.4f at sample %d, at most %.4f expected", score, jump, n, slope)
+				}
+			}
+		}
+	}
+}
+
+func TestReleaseTailRings(t *testing.T) {
+	tests := []struct {
+		score   string
+		release time.Duration
+	}{
+		{"C4:q R:q", 100 * time.Millisecond},
+		{"C4:q E4:q", 200 * time.Millisecond},
+		{"A4:e", 300 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		in := sine(synth.Envelope{Sustain: 1, Release: tt.release})
+		spans, total := scheduleScore(t, tt.score, in)
+		out := make([]float64, total)
+		renderChannel(spans, in, 0, out, 1, 1, 0, nil)
+
+		first := spans[0]
+		tail := int(tt.release.Seconds() * SampleRate)
+		if first.end-first.release != tail {
+			t.Errorf("%q: the first note rings %d samples after its slot, want %d", tt.score, first.end-first.release, tail)
+		}
+		if last := spans[len(spans)-1]; total < last.end {
+			t.Errorf("%q: %d samples, the last tail ends at %d", tt.score, total, last.end)
+		}
+
+		// alone, the first note is heard all through its tail, fading out
+		alone := make([]float64, total)
+		renderChannel(spans[:1], in, 0, alone, 1, 1, 0, nil)
+		for _, quarter := range []int{0, 1, 2, 3} {
+			from := first.release + quarter*tail/4
+			peak := 0.0
+			for _, s := range alone[from : from+tail/4] {
+				peak = math.Max(peak, math.Abs(s))
+			}
+			if want := 1 - float64(quarter)/4; peak < want-0.26 || peak > want {
+				t.Errorf("%q: the tail peaks at %.3f in its quarter %d, want about %.2f", tt.score, peak, quarter+1, want)
+			}
+		}
+
+		// and mixed on top of what follows
+		rest := make([]float64, total)
+		renderChannel(spans[1:], in, 0, rest, 1, 1, 0, nil)
+		for n := first.release; n < first.end; n++ {
+			if math.Abs(out[n]-alone[n]-rest[n]) > 1e-12 {
+				t.Fatalf("%q: sample %d is %v, the tail and the next notes add up to %v", tt.score, n, out[n], alone[n]+rest[n])
+			}
+		}
+	}
+}
+
+func TestNoteGap(t *testing.T) {
+	in := sine(synth.Envelope{Sustain: 1})
+	tests := []struct {
+		score string
+		gap   time.Duration
+	}{
+		{"C4:q E4:q G4:h", 0},
+		{"C4:q E4:q G4:h", 100 * time.Millisecond},
+		{"C4:e R:e A4:s", 250 * time.Millisecond},
+		{"C4:w", time.Second},
+	}
+
+	for _, tt := range tests {
+		notes, err := ParseScore(tt.score, 120)
+		if err != nil {
+			t.Fatal(err)
+		}
+		spans, total := schedule(notes, in, timing{Gap: tt.gap, Articulation: 1})
+
+		// every note starts after the ones before it and their gaps, the
+		// last one isn't followed by a gap
+		gap := int(tt.gap.Seconds() * SampleRate)
+		position, played := 0, 0
+		for i, note := range notes {
+			if note.Key > 0 {
+				if s := spans[played]; s.start != position {
+					t.Errorf("%q gap %v: note %d starts at %d, want %d", tt.score, tt.gap, i, s.start, position)
+				}
+				played++
+			}
+			position += int(note.Duration.Seconds() * SampleRate)
+			if i < len(notes)-1 {
+				position += gap
+			}
+		}
+
+		if total != position {
+			t.Errorf("%q gap %v: %d samples, want %d", tt.score, tt.gap, total, position)
+		}
+	}
+}
+
+func TestMeasureSpeed(t *testing.T) {
+	for _, config := range []string{"", "channel-count=2,instrument=pluck,chorus=2", "drive=3,delay=100ms"} {
+		song, err := ParseSong("C4:q E4:q | G3:h", 120)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		var rtf float64
+		if err := withFlags(config, func() { rtf, err = measureSpeed(&buf, song) }); err != nil {
+			t.Fatal(err)
+		}
+		if err != nil {
+			t.Fatalf("%q: %v", config, err)
+		}
+
+		if rtf <= 0 || math.IsInf(rtf, 0) || math.IsNaN(rtf) {
+			t.Errorf("%q: real-time factor %v", config, rtf)
+		}
+		if !strings.HasPrefix(buf.String(), "rendered ") || !strings.Contains(buf.String(), "real-time factor") {
+			t.Errorf("%q: reported %q", config, buf.String())
+		}
+	}
+}
+
+// brightness is the share of the energy of the samples above 1kHz
+func brightness(samples []float64) float64 {
+	magnitudes := spectrum(samples)
+	high, total := 0.0, 0.0
+	for bin, m := range magnitudes {
+		total += m * m
+		if binFrequency(float64(bin), len(magnitudes), SampleRate) > 1000 {
+			high += m * m
+		}
+	}
+	return high / total
+}
+
+func TestVelocityFilter(t *testing.T) {
+	in := Instrument{{Envelope: synth.Envelope{Sustain: 1}, Level: 1, Ratio: 1, Harmonics: []float64{1, 1, 1, 1, 1, 1, 1, 1, 1, 1}}}
+
+	render := func(score string, sensitivity float64) []float64 {
+		spans, total := scheduleScore(t, score, in)
+		out := make([]float64, total)
+		renderChannel(spans, in, 0, out, 1, 1, sensitivity, nil)
+		return out
+	}
+
+	for _, sensitivity := range []float64{0.3, 0.6, 1} {
+		loud := brightness(render("C4:h@1", sensitivity))
+		soft := brightness(render("C4:h@0.3", sensitivity))
+		if soft >= loud {
+			t.Errorf("sensitivity %g: a soft note is as bright as a loud one, %.3f and %.3f above 1kHz", sensitivity, soft, loud)
+		}
+	}
+
+	// without the filter only the level changes
+	loud, soft := brightness(render("C4:h@1", 0)), brightness(render("C4:h@0.3", 0))
+	if math.Abs(loud-soft) > 1e-9 {
+		t.Errorf("without -velo-filter: %.3f and %.3f above 1kHz", loud, soft)
+	}
+}
+
+func TestVelocityFilterLayers(t *testing.T) {
+	env := synth.Envelope{Sustain: 1}
+	tests := []struct {
+		name  string
+		layer Layer
+		// score is a note loud enough above 1kHz to tell
+		score string
+	}{
+		{"sine", Layer{}, "C7:h"},
+		{"square", Layer{Shape: synth.Shapes["square"]}, "C4:h"},
+		{"saw", Layer{Shape: synth.Shapes["saw"]}, "C4:h"},
+		{"harmonics", Layer{Harmonics: []float64{1, 0.5, 0.5, 0.5, 0.5, 0.5, 0.5, 0.5}}, "C4:h"},
+		{"partials", Layer{Partials: []synth.Partial{{Ratio: 1, Amplitude: 1}, {Ratio: 5.4, Amplitude: 1}}}, "C4:h"},
+		{"fm", Layer{FM: synth.FM{{Ratio: 3.5, Index: 3, Envelope: env}}}, "C4:h"},
+		{"string", Layer{String: 0.999}, "C4:h"},
+	}
+
+	// high is the energy above 1kHz of the note played at velocity with
+	// the filter at sensitivity
+	high := func(in Instrument, score string, velocity, sensitivity float64) float64 {
+		spans, total := scheduleScore(t, fmt.Sprintf("%s@%g", score, velocity), in)
+		out := make([]float64, total)
+		renderChannel(spans, in, 0, out, 1, 1, sensitivity, nil)
+
+		magnitudes := spectrum(out)
+		sum := 0.0
+		for bin, m := range magnitudes {
+			if binFrequency(float64(bin), len(magnitudes), SampleRate) > 1000 {
+				sum += m * m
+			}
+		}
+		return sum
+	}
+
+	for _, tt := range tests {
+		tt.layer.Envelope, tt.layer.Level, tt.layer.Ratio = env, 1, 1
+		in := Instrument{tt.layer}
+
+		// a soft note loses its highs on top of its level, a full velocity
+		// one plays as without the filter
+		if kept := high(in, tt.score, 0.3, 1) / high(in, tt.score, 0.3, 0); kept > 0.1 {
+			t.Errorf("%s: a soft note keeps %.3f of its energy above 1kHz through the filter", tt.name, kept)
+		}
+		if kept := high(in, tt.score, 1, 1) / high(in, tt.score, 1, 0); kept != 1 {
+			t.Errorf("%s: a full velocity note keeps %.3f of its energy above 1kHz through the filter", tt.name, kept)
+		}
+	}
+}
+
+func TestVeloCutoff(t *testing.T) {
+	tests := []struct {
+		velocity, sensitivity, want float64
+	}{
+		{1, 1, SampleRate / 2},
+		{0.5, 0.25, SampleRate / 4},
+		{0, 1, SampleRate / 512.0},
+		{0.3, 0, 0},
+	}
+
+	for _, tt := range tests {
+		if got := veloCutoff(tt.velocity, tt.sensitivity); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("veloCutoff(%g, %g) = %g, want %g", tt.velocity, tt.sensitivity, got, tt.want)
+		}
+	}
+}
+
+func TestRenderTail(t *testing.T) {
+	const score = "C4:q E4:q"
+	peak := func(samples []float64) float64 {
+		p := 0.0
+		for _, s := range samples {
+			p = math.Max(p, math.Abs(s))
+		}
+		return p
+	}
+
+	dry := renderWith(t, "delay=100ms,delay-feedback=0.4", score)[0]
+	for _, tail := range []time.Duration{500 * time.Millisecond, 2 * time.Second} {
+		wet := renderWith(t, "delay=100ms,delay-feedback=0.4,render-tail="+tail.String(), score)[0]
+
+		extra := int(tail.Seconds() * SampleRate)
+		if len(wet) != len(dry)+extra {
+			t.Fatalf("tail %v: %d samples, want %d", tail, len(wet), len(dry)+extra)
+		}
+
+		// the song is unchanged, the echoes ring on into the tail and fade
+		for i := range dry {
+			if wet[i] != dry[i] {
+				t.Fatalf("tail %v: sample %d changed from %v to %v", tail, i, dry[i], wet[i])
+			}
+		}
+		window := SampleRate / 10
+		start, end := peak(wet[len(dry):len(dry)+window]), peak(wet[len(wet)-window:])
+		if start < 0.01 {
+			t.Errorf("tail %v: no echo at the start of the tail, it peaks at %v", tail, start)
+		}
+		if end > start/10 {
+			t.Errorf("tail %v: the echoes don't fade, %v at the start of the tail and %v at its end", tail, start, end)
+		}
+	}
+}
+
+func TestReleaseFollowsTheEnvelope(t *testing.T) {
+	// a flat waveform leaves the envelope alone in the output
+	flat := func(phase, dt float64) float64 { return 1 }
+
+	tests := []synth.Envelope{
+		{Sustain: 1, Release: 100 * time.Millisecond},
+		{Attack: 20 * time.Millisecond, Decay: 50 * time.Millisecond, Sustain: 0.4, Release: 300 * time.Millisecond},
+		// released before reaching the sustain level
+		{Attack: 400 * time.Millisecond, Hold: 100 * time.Millisecond, Sustain: 0.5, Release: 200 * time.Millisecond},
+	}
+
+	for _, env := range tests {
+		in := Instrument{{Envelope: env, Level: 1, Ratio: 1, Shape: flat}}
+		spans, total := scheduleScore(t, "A4:q@0.8", in)
+		out := make([]float64, total)
+		renderChannel(spans, in, 0, out, 1, 1, 0, nil)
+
+		s := spans[0]
+		held := float64(s.release-s.start) / SampleRate
+		for p := s.release; p < s.end; p++ {
+			want := 0.8 * env.Amplitude(float64(p-s.start)/SampleRate, held)
+			if math.Abs(out[p]-want) > 1e-12 {
+				t.Fatalf("%+v: %v at sample %d of the release, the envelope says %v", env, out[p], p-s.release, want)
+			}
+		}
+		if out[s.end-1] > 0.8/float64(s.end-s.release)*1.01 {
+			t.Errorf("%+v: the release ends at %v, not near 0", env, out[s.end-1])
+		}
+	}
+}
+
+func TestArticulation(t *testing.T) {
+	in := sine(synth.Envelope{Sustain: 1})
+	notes, err := ParseScore("C4:q E4:e G4:h", 120)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, legato := schedule(notes, in, timing{Articulation: 1})
+
+	for _, articulation := range []float64{1, 0.75, 0.5, 0.3} {
+		spans, total := schedule(notes, in, timing{Articulation: articulation})
+		if total != legato {
+			t.Errorf("articulation %g: %d samples, want %d as legato", articulation, total, legato)
+		}
+
+		out := make([]float64, total)
+		renderChannel(spans, in, 0, out, 1, 1, 0, nil)
+
+		// the note sounds for its share of the slot and then it's silent,
+		// the slots start where they did
+		position := 0
+		for i, note := range notes {
+			slot := int(note.Duration.Seconds() * SampleRate)
+			s := spans[i]
+			if s.start != position {
+				t.Errorf("articulation %g: note %d starts at %d, want %d", articulation, i, s.start, position)
+			}
+			if want := int(float64(slot) * articulation); s.release-s.start != want {
+				t.Errorf("articulation %g: note %d sounds %d samples, want %d", articulation, i, s.release-s.start, want)
+			}
+			for p := s.release; p < position+slot; p++ {
+				if out[p] != 0 {
+					t.Fatalf("articulation %g: sample %d after note %d is %v, want silence", articulation, p, i, out[p])
+				}
+			}
+			position += slot
+		}
+	}
+}
+
+func TestZeroCrossings(t *testing.T) {
+	in := sine(synth.Envelope{Sustain: 1})
+	for _, score := range []string{"A4:q", "C4:e E4:s G4:et", "A0:s C8:q", "C4:q R:e D4:q"} {
+		notes, err := ParseScore(score, 120)
+		if err != nil {
+			t.Fatal(err)
+		}
+		spans, total := schedule(notes, in, timing{Articulation: 1, ZeroCross: true})
+		out := make([]float64, total)
+		renderChannel(spans, in, 0, out, 1, 1, 0, nil)
+
+		for _, s := range spans {
+			if s.phase != 0 {
+				t.Errorf("%q: the note at %d starts at phase %v", score, s.start, s.phase)
+			}
+
+			// a whole number of periods, rounded to the sample
+			periods := float64(s.release-s.start) * s.frequency / SampleRate
+			if math.Abs(periods-math.Round(periods)) > s.frequency/SampleRate {
+				t.Errorf("%q: the note at %d lasts %.3f periods", score, s.start, periods)
+			}
+
+			// so it ends on a zero crossing: the last sample is a step of
+			// the sine from zero, give or take the half sample rounding
+			if last := out[s.release-1]; math.Abs(last) > 1.5*τ*s.frequency/SampleRate {
+				t.Errorf("%q: the note at %d ends at %v", score, s.start, last)
+			}
+		}
+	}
+}
+
+func TestDriftClock(t *testing.T) {
+	tests := []struct {
+		cents float64
+		seed  int64
+	}{
+		{5, 1},
+		{20, 42},
+		{1, 7},
+	}
+
+	step := pitchDriftStep.Seconds() * SampleRate
+	for _, tt := range tests {
+		clock := driftClock(10*SampleRate, tt.cents, rand.New(rand.NewSource(tt.seed)))
+
+		widest, previous, fastest := 0.0, 0.0, 0.0
+		for p := 1; p < len(clock); p++ {
+			cents := 1200 * math.Log2((clock[p]-clock[p-1])*SampleRate)
+			if math.Abs(cents) > tt.cents+1e-6 {
+				t.Errorf("%g cents: sample %d is %.3f cents off", tt.cents, p, cents)
+				break
+			}
+			widest = math.Max(widest, math.Abs(cents))
+			if p > 1 {
+				fastest = math.Max(fastest, math.Abs(cents-previous))
+			}
+			previous = cents
+		}
+
+		if widest < tt.cents/4 {
+			t.Errorf("%g cents: drifts at most %.3f cents, should wander", tt.cents, widest)
+		}
+		// between two steps the tuning moves in a straight line, at most
+		// across the whole range
+		if limit := 2*tt.cents/step + 1e-6; fastest > limit {
+			t.Errorf("%g cents: moves %.5f cents in a sample, want at most %.5f", tt.cents, fastest, limit)
+		}
+
+		again := driftClock(10*SampleRate, tt.cents, rand.New(rand.NewSource(tt.seed)))
+		for p := range clock {
+			if clock[p] != again[p] {
+				t.Errorf("%g cents: seed %d gives a different clock at sample %d", tt.cents, tt.seed, p)
+				break
+			}
+		}
+	}
+}
+
+func TestSkipTo(t *testing.T) {
+	spans := []span{
+		{start: 0, release: 100, end: 150},
+		{start: 100, release: 200, end: 250},
+		{start: 200, release: 300, end: 350},
+	}
+
+	tests := []struct {
+		from   int
+		starts []int
+	}{
+		{0, []int{0, 100, 200}},
+		{50, []int{0, 100, 200}},
+		// a note released by then is dropped, its tail with it
+		{100, []int{100, 200}},
+		{250, []int{200}},
+		{300, nil},
+	}
+
+	for _, tt := range tests {
+		var starts []int
+		for _, s := range skipTo(spans, tt.from) {
+			starts = append(starts, s.start)
+		}
+		if fmt.Sprint(starts) != fmt.Sprint(tt.starts) {
+			t.Errorf("skipTo(%d) keeps the spans at %v, want %v", tt.from, starts, tt.starts)
+		}
+	}
+}
+
+func TestStartAt(t *testing.T) {
+	const score = "C4:q E4:q G4:h A3:q F4:q D4:h"
+	full := renderWith(t, "release=5ms", score)
+
+	tests := []struct {
+		startAt string
+		offset  int
+		// from is the first sample matching the whole render
+		from int
+	}{
+		// halfway through the 2nd and 4th notes
+		{"750ms", 33075, 0},
+		{"2.25s", 99225, 0},
+		// on a note start, the release tail of the note before is dropped
+		// with it
+		{"1s", SampleRate, 221},
+	}
+
+	for _, tt := range tests {
+		part := renderWith(t, "release=5ms,start-at="+tt.startAt, score)
+		if got, want := len(part[0]), len(full[0])-tt.offset; got != want {
+			t.Errorf("start at %s: %d samples, want %d", tt.startAt, got, want)
+			continue
+		}
+
+		// the notes before are gone, the one playing there joins with the
+		// phase and envelope it has at that point
+		for i := tt.from; i < len(part[0]); i++ {
+			if s := part[0][i]; math.Abs(s-full[0][tt.offset+i]) > 1e-9 {
+				t.Errorf("start at %s: sample %d is %v, want %v", tt.startAt, i, s, full[0][tt.offset+i])
+				break
+			}
+		}
+	}
+}
+
+func TestPitchBend(t *testing.T) {
+	tests := []struct {
+		score                  string
+		start, middle, release float64
+	}{
+		// a whole tone sharp down to A4, through 100 cents at the midpoint
+		{"A4:h~200,0", 493.88, 466.16, 440},
+		{"A4:h~200,0,fast", 493.88, 452.89, 440},
+		{"A4:h~200,0,slow", 493.88, 479.82, 440},
+		{"A4:h~0,-1200", 440, 311.13, 220},
+	}
+
+	for _, tt := range tests {
+		spans, _ := scheduleScore(t, tt.score+" A4:q", sine(synth.Envelope{Sustain: 1, Release: 100 * time.Millisecond}))
+		s := spans[0]
+		if s.bend == nil {
+			t.Fatalf("%s: no bend", tt.score)
+		}
+
+		// the instantaneous frequency out of the phase the oscillator goes
+		// through
+		frequency := func(i int) float64 { return (s.bend[i+1] - s.bend[i]) * SampleRate / τ }
+		held := s.release - s.start
+		for _, check := range []struct {
+			at   int
+			want float64
+		}{{0, tt.start}, {held / 2, tt.middle}, {held + 100, tt.release}} {
+			if got := frequency(check.at); math.Abs(got-check.want) > 0.01 {
+				t.Errorf("%s: %.2fHz at sample %d, want %.2fHz", tt.score, got, check.at, check.want)
+			}
+		}
+
+		// the next note picks up where the bend left the oscillator
+		if want := math.Mod(s.bend[held], τ); math.Abs(math.Mod(spans[1].phase, τ)-want) > 1e-9 {
+			t.Errorf("%s: the next note starts at phase %.4f, want %.4f", tt.score, math.Mod(spans[1].phase, τ), want)
+		}
+	}
+}
+
+func TestZeroLengthNotes(t *testing.T) {
+	sample := time.Second/SampleRate + 1
+	tests := []struct {
+		name  string
+		notes []Note
+		// spans are the start and held length of every note played
+		spans [][2]int
+		total int
+	}{
+		{"zero duration", []Note{{Key: 40}}, nil, 0},
+		{"one sample", []Note{{Key: 40, Duration: sample}}, [][2]int{{0, 1}}, 1},
+		{"grace note", []Note{{Key: 40, Duration: time.Millisecond / 100}, {Key: 44, Duration: 10 * time.Millisecond}}, [][2]int{{0, 441}}, 441},
+		// the notes after a skipped one keep their timing
+		{"between", []Note{{Key: 40, Duration: 10 * time.Millisecond}, {Key: 42}, {Key: 44, Duration: 10 * time.Millisecond}}, [][2]int{{0, 441}, {441, 441}}, 882},
+		{"bent", []Note{{Key: 40, Bend: &PitchBend{Start: 100, Curve: "lin"}}, {Key: 44, Duration: sample, Bend: &PitchBend{Start: 100, Curve: "lin"}}}, [][2]int{{0, 1}}, 1},
+	}
+
+	in := sine(synth.Envelope{Attack: 10 * time.Millisecond, Sustain: 1})
+	for _, tt := range tests {
+		spans, total := schedule(tt.notes, in, timing{Articulation: 1})
+		var got [][2]int
+		for _, s := range spans {
+			got = append(got, [2]int{s.start, s.release - s.start})
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.spans) || total != tt.total {
+			t.Errorf("%s: spans %v over %d samples, want %v over %d", tt.name, got, total, tt.spans, tt.total)
+		}
+
+		out := make([]float64, total)
+		renderChannel(spans, in, 0, out, 1, 1, 0, nil)
+		for i, s := range out {
+			if math.IsNaN(s) || math.Abs(s) > 1 {
+				t.Errorf("%s: sample %d is %v", tt.name, i, s)
+				break
+			}
+		}
+	}
+}
+
+func TestZeroLengthNoteWAV(t *testing.T) {
+	tests := []struct {
+		duration time.Duration
+		bytes    int
+	}{
+		// nothing but the header, then a single 16-bit frame
+		{0, 44},
+		{time.Second/SampleRate + 1, 46},
+	}
+
+	for _, tt := range tests {
+		song := &Song{Tracks: []Track{{Notes: []Note{{Key: 49, Duration: tt.duration}}}}}
+		var buf bytes.Buffer
+		err := withFlags("channel-count=1", func() {
+			channels := renderSong(song)
+			if err := writeWAV(&buf, interleave(channels), 1, SampleRate, 16, "clamp"); err != nil {
+				t.Fatal(err)
+			}
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if buf.Len() != tt.bytes {
+			t.Errorf("%v note: %d bytes, want %d", tt.duration, buf.Len(), tt.bytes)
+		}
+	}
+}
+
+func TestSeedModePerNote(t *testing.T) {
+	notes, err := ParseScore("A4:q A4:q A4:q", 120)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := sine(synth.Envelope{Sustain: 1})
+
+	// cents is the drift of the span at every sample of it, read back from
+	// its phase table
+	cents := func(s span) []float64 {
+		out := make([]float64, s.release-s.start)
+		for i := range out {
+			out[i] = 1200 * math.Log2((s.bend[i+1]-s.bend[i])*SampleRate/(τ*s.frequency))
+		}
+		return out
+	}
+
+	tests := []struct {
+		jitter float64
+		seed   int64
+	}{
+		{10, 1},
+		{30, 42},
+	}
+
+	for _, tt := range tests {
+		spans, _ := schedule(notes, in, timing{Articulation: 1, NoteJitter: tt.jitter, Seed: tt.seed})
+		first, second := cents(spans[0]), cents(spans[1])
+
+		// the same pitch twice in a row drifts two different ways
+		differ := false
+		for i := range first {
+			if math.Abs(first[i]) > tt.jitter+1e-6 || math.Abs(second[i]) > tt.jitter+1e-6 {
+				t.Fatalf("%g cents: sample %d drifts %.3f and %.3f cents", tt.jitter, i, first[i], second[i])
+			}
+			differ = differ || math.Abs(first[i]-second[i]) > 1e-6
+		}
+		if !differ {
+			t.Errorf("%g cents, seed %d: two consecutive A4 drift the same way", tt.jitter, tt.seed)
+		}
+
+		// and the whole song is the same on every run with the seed
+		again, _ := schedule(notes, in, timing{Articulation: 1, NoteJitter: tt.jitter, Seed: tt.seed})
+		for i := range spans {
+			if fmt.Sprint(spans[i].bend) != fmt.Sprint(again[i].bend) {
+				t.Errorf("%g cents, seed %d: note %d drifts differently on a second run", tt.jitter, tt.seed, i)
+			}
+		}
+	}
+}
+
+func TestSeedModeRender(t *testing.T) {
+	const score = "A4:q A4:q C5:h"
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{"pitch-jitter=20,seed-mode=per-note,seed=3", "pitch-jitter=20,seed-mode=per-note,seed=3", true},
+		{"pitch-jitter=20,seed-mode=per-note,seed=3", "pitch-jitter=20,seed-mode=per-note,seed=4", false},
+		{"pitch-jitter=20,seed-mode=global,seed=3", "pitch-jitter=20,seed-mode=global,seed=3", true},
+		{"pitch-jitter=20,seed-mode=global,seed=3", "pitch-jitter=20,seed-mode=per-note,seed=3", false},
+	}
+
+	for _, tt := range tests {
+		a, b := renderWith(t, tt.a, score), renderWith(t, tt.b, score)
+		same := len(a[0]) == len(b[0])
+		for i := 0; same && i < len(a[0]); i++ {
+			same = a[0][i] == b[0][i]
+		}
+		if same != tt.same {
+			t.Errorf("%s and %s: same render %v, want %v", tt.a, tt.b, same, tt.same)
+		}
+	}
+
+	song, _ := ParseSong(score, 120)
+	if err := withFlags("seed-mode=random", func() { renderSong(song) }); err == nil {
+		t.Error("-seed-mode random should be rejected")
+	}
+}
+
+// crest is the peak over the RMS of the samples
+func crest(samples []float64) float64 {
+	peak, sum := 0.0, 0.0
+	for _, s := range samples {
+		peak = math.Max(peak, math.Abs(s))
+		sum += s * s
+	}
+	return peak / math.Sqrt(sum/float64(len(samples)))
+}
+
+func TestWave(t *testing.T) {
+	tests := []struct {
+		wave  string
+		crest float64
+	}{
+		{"sine", math.Sqrt2},
+		{"square", 1},
+		{"triangle", math.Sqrt(3)},
+		{"saw", math.Sqrt(3)},
+	}
+
+	for _, tt := range tests {
+		// the middle of a held note, past the attack
+		out := renderWith(t, "wave="+tt.wave, "A4:w")[0]
+		if got := crest(out[22050:66150]); math.Abs(got-tt.crest) > 0.05 {
+			t.Errorf("-wave %s: crest factor %.3f, want %.3f", tt.wave, got, tt.crest)
+		}
+	}
+
+	song, _ := ParseSong("A4:q", 120)
+	if err := withFlags("wave=sawtooth", func() { renderSong(song) }); err == nil {
+		t.Error("-wave sawtooth should be rejected")
+	}
+}
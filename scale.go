@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tecnologer/SoundOfCode/synth"
+)
+
+// scalePatterns are the semitone steps between consecutive degrees of
+// each scale, adding up to one octave
+var scalePatterns = map[string][]int{
+	"major":      {2, 2, 1, 2, 2, 2, 1},
+	"minor":      {2, 1, 2, 2, 1, 2, 2},
+	"pentatonic": {2, 2, 3, 2, 3},
+	"blues":      {3, 2, 1, 1, 3, 2},
+	"chromatic":  {1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1},
+}
+
+// Scale is a scale pattern starting on the Root key
+type Scale struct {
+	Root    int
+	Pattern []int
+}
+
+// ParseScale builds a scale from a root note name like C4 and a pattern
+// name like major
+func ParseScale(root, pattern string) (*Scale, error) {
+	key, err := synth.ParseNote(root)
+	if err != nil {
+		return nil, err
+	}
+
+	steps, ok := scalePatterns[strings.ToLower(pattern)]
+	if !ok {
+		return nil, fmt.Errorf("unknown scale %q", pattern)
+	}
+
+	return &Scale{Root: key, Pattern: steps}, nil
+}
+
+// Keys returns every piano key in the scale, in ascending order
+func (s *Scale) Keys() []int {
+	inScale := make(map[int]bool)
+	offset := 0
+	for _, step := range s.Pattern {
+		inScale[offset] = true
+		offset += step
+	}
+
+	var keys []int
+	for key := 1; key <= synth.TotalKeys; key++ {
+		if inScale[((key-s.Root)%12+12)%12] {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// snapToScale returns the key of scaleKeys closest to key. When two keys
+// are equally close the lower one wins
+func snapToScale(key int, scaleKeys []int) int {
+	best := key
+	for i, k := range scaleKeys {
+		if i == 0 || abs(k-key) < abs(best-key) {
+			best = k
+		}
+	}
+
+	return best
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
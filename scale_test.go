@@ -0,0 +1,55 @@
+package main
+
+import "testing"
+
+func TestSnapToScale(t *testing.T) {
+	major, err := ParseScale("C4", "major")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pentatonic, err := ParseScale("C4", "pentatonic")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		scale *Scale
+		key   int
+		want  int
+	}{
+		{"in scale stays", major, 44, 44},
+		{"C#4 ties to the lower C4", major, 41, 40},
+		{"D#4 ties to the lower D4", major, 43, 42},
+		{"F#4 ties to the lower F4", major, 46, 45},
+		{"A#4 ties to the lower A4", major, 50, 49},
+		{"F4 is closer to E4 in pentatonic", pentatonic, 45, 44},
+		{"F#4 is closer to G4 in pentatonic", pentatonic, 46, 47},
+		{"B4 is closer to C5 in pentatonic", pentatonic, 51, 52},
+	}
+
+	for _, tt := range tests {
+		if got := snapToScale(tt.key, tt.scale.Keys()); got != tt.want {
+			t.Errorf("%s: snapToScale(%d) = %d, want %d", tt.name, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestScaleKeys(t *testing.T) {
+	scale, err := ParseScale("A4", "minor")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inScale := map[int]bool{}
+	for _, key := range scale.Keys() {
+		inScale[key] = true
+	}
+
+	// A minor has the white keys only, A4 is key 49
+	for key, want := range map[int]bool{49: true, 51: true, 52: true, 50: false, 53: false, 54: true} {
+		if inScale[key] != want {
+			t.Errorf("key %d in A minor: %v, want %v", key, inScale[key], want)
+		}
+	}
+}
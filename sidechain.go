@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	sidechainAttack  = 5 * time.Millisecond
+	sidechainRelease = 150 * time.Millisecond
+)
+
+// Sidechain ducks the To track while the From track plays, tracks being
+// numbered from 1 in score order. At the loudest point of From the level
+// of To drops by Amount, from 0 (no ducking) to 1 (silence)
+type Sidechain struct {
+	From, To int
+	Amount   float64
+}
+
+// ParseSidechain parses a config like "from=1,to=2,amount=0.6"
+func ParseSidechain(config string) (*Sidechain, error) {
+	s := &Sidechain{Amount: 0.5}
+	for _, pair := range strings.Split(config, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid sidechain setting %q", pair)
+		}
+
+		var err error
+		switch parts[0] {
+		case "from":
+			s.From, err = strconv.Atoi(parts[1])
+		case "to":
+			s.To, err = strconv.Atoi(parts[1])
+		case "amount":
+			s.Amount, err = strconv.ParseFloat(parts[1], 64)
+		default:
+			return nil, fmt.Errorf("unknown sidechain setting %q", parts[0])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid sidechain setting %q", pair)
+		}
+	}
+
+	if s.From < 1 || s.To < 1 || s.From == s.To {
+		return nil, fmt.Errorf("sidechain needs two different tracks, got from=%d to=%d", s.From, s.To)
+	}
+	if s.Amount < 0 || s.Amount > 1 {
+		return nil, fmt.Errorf("sidechain amount %g is not between 0 and 1", s.Amount)
+	}
+	return s, nil
+}
+
+// Apply ducks the target track, tracks holding one buffer per channel for
+// every track of the song
+func (s *Sidechain) Apply(tracks [][][]float64) error {
+	if s.From > len(tracks) || s.To > len(tracks) {
+		return fmt.Errorf("sidechain tracks %d and %d, the song has %d", s.From, s.To, len(tracks))
+	}
+
+	env := follow(tracks[s.From-1])
+	peak := 0.0
+	for _, e := range env {
+		peak = math.Max(peak, e)
+	}
+	if peak == 0 {
+		return nil
+	}
+
+	for _, samples := range tracks[s.To-1] {
+		for i := range samples {
+			if i < len(env) {
+				samples[i] *= 1 - s.Amount*env[i]/peak
+			}
+		}
+	}
+	return nil
+}
+
+// follow is the envelope of the loudest channel, rising in
+// sidechainAttack and falling in sidechainRelease
+func follow(channels [][]float64) []float64 {
+	up := math.Exp(-1 / (sidechainAttack.Seconds() * SampleRate))
+	down := math.Exp(-1 / (sidechainRelease.Seconds() * SampleRate))
+
+	env := make([]float64, len(channels[0]))
+	level := 0.0
+	for i := range env {
+		x := 0.0
+		for _, samples := range channels {
+			x = math.Max(x, math.Abs(samples[i]))
+		}
+
+		coefficient := down
+		if x > level {
+			coefficient = up
+		}
+		level = x + coefficient*(level-x)
+		env[i] = level
+	}
+	return env
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseSidechain(t *testing.T) {
+	tests := []struct {
+		config string
+		want   Sidechain
+	}{
+		{"from=1,to=2,amount=0.6", Sidechain{From: 1, To: 2, Amount: 0.6}},
+		{"to=1, from=3", Sidechain{From: 3, To: 1, Amount: 0.5}},
+		{"from=2,to=1,amount=1", Sidechain{From: 2, To: 1, Amount: 1}},
+	}
+
+	for _, tt := range tests {
+		s, err := ParseSidechain(tt.config)
+		if err != nil {
+			t.Errorf("ParseSidechain(%q): %v", tt.config, err)
+			continue
+		}
+		if *s != tt.want {
+			t.Errorf("ParseSidechain(%q) = %+v, want %+v", tt.config, *s, tt.want)
+		}
+	}
+}
+
+func TestParseSidechainInvalid(t *testing.T) {
+	for _, config := range []string{
+		"", "from=1", "from=1,to=1", "from=0,to=2", "from=1,to=2,amount=1.5",
+		"from=1,to=2,amount=-0.1", "from=1,to=2,ratio=4", "from=x,to=2", "from=1,to",
+	} {
+		if s, err := ParseSidechain(config); err == nil {
+			t.Errorf("ParseSidechain(%q) = %+v, should fail", config, *s)
+		}
+	}
+}
+
+// hits is a mono track of four 50ms bursts, one every 500ms
+func hits() [][]float64 {
+	samples := make([]float64, 2*SampleRate)
+	for hit := 0; hit < 4; hit++ {
+		start := hit * SampleRate / 2
+		for i := start; i < start+SampleRate/20; i++ {
+			samples[i] = math.Sin(2 * math.Pi * 100 * float64(i) / SampleRate)
+		}
+	}
+	return [][]float64{samples}
+}
+
+// pad is a constant stereo track at full scale
+func pad() [][]float64 {
+	channels := make([][]float64, 2)
+	for ch := range channels {
+		channels[ch] = make([]float64, 2*SampleRate)
+		for i := range channels[ch] {
+			channels[ch][i] = 1
+		}
+	}
+	return channels
+}
+
+func TestSidechainDucksDuringHits(t *testing.T) {
+	tests := []struct {
+		amount float64
+	}{
+		{0.6},
+		{1},
+		{0.25},
+	}
+
+	for _, tt := range tests {
+		tracks := [][][]float64{hits(), pad()}
+		s := &Sidechain{From: 1, To: 2, Amount: tt.amount}
+		if err := s.Apply(tracks); err != nil {
+			t.Fatal(err)
+		}
+
+		for ch, samples := range tracks[1] {
+			for hit := 0; hit < 4; hit++ {
+				start := hit * SampleRate / 2
+				during := samples[start+SampleRate/20]
+				if want := 1 - tt.amount; math.Abs(during-want) > 0.1 {
+					t.Errorf("amount %g: channel %d at the end of hit %d is %.3f, want about %.3f", tt.amount, ch, hit, during, want)
+				}
+
+				// 400ms after the hit is over the release has almost recovered
+				between := samples[start+SampleRate/2-SampleRate/20]
+				if between < 1-0.1*tt.amount {
+					t.Errorf("amount %g: channel %d between hits %d and %d is %.3f, should recover", tt.amount, ch, hit, hit+1, between)
+				}
+			}
+
+			if samples[0] != 1 {
+				t.Errorf("amount %g: channel %d starts at %.3f before the first hit rises", tt.amount, ch, samples[0])
+			}
+		}
+	}
+}
+
+func TestSidechainLeavesTheTrigger(t *testing.T) {
+	tracks := [][][]float64{hits(), pad()}
+	trigger := append([]float64(nil), tracks[0][0]...)
+
+	if err := (&Sidechain{From: 1, To: 2, Amount: 1}).Apply(tracks); err != nil {
+		t.Fatal(err)
+	}
+	for i := range trigger {
+		if tracks[0][0][i] != trigger[i] {
+			t.Fatalf("sample %d of the trigger track changed from %f to %f", i, trigger[i], tracks[0][0][i])
+		}
+	}
+}
+
+func TestSidechainSilentTrigger(t *testing.T) {
+	tracks := [][][]float64{{make([]float64, SampleRate)}, pad()}
+	if err := (&Sidechain{From: 1, To: 2, Amount: 1}).Apply(tracks); err != nil {
+		t.Fatal(err)
+	}
+	for ch, samples := range tracks[1] {
+		for i, x := range samples {
+			if x != 1 {
+				t.Fatalf("channel %d sample %d is %f, a silent trigger shouldn't duck", ch, i, x)
+			}
+		}
+	}
+}
+
+func TestSidechainMissingTrack(t *testing.T) {
+	tracks := [][][]float64{hits(), pad()}
+	if err := (&Sidechain{From: 1, To: 3, Amount: 0.5}).Apply(tracks); err == nil {
+		t.Error("Apply with track 3 of 2 should fail")
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tecnologer/SoundOfCode/synth"
+)
+
+// Track is one voice of a song, its notes play one after the other
+type Track struct {
+	Notes []Note
+}
+
+// Song is a set of tracks playing at the same time
+type Song struct {
+	Tracks []Track
+}
+
+// ParseSong parses a score with its tracks separated by "|", e.g.
+// "C4:q E4:q | C3:h"
+func ParseSong(score string, bpm int) (*Song, error) {
+	song := &Song{}
+	for i, part := range strings.Split(score, "|") {
+		notes, err := ParseScore(part, bpm)
+		if err != nil {
+			return nil, fmt.Errorf("track %d: %v", i+1, err)
+		}
+
+		if len(notes) > 0 {
+			song.Tracks = append(song.Tracks, Track{Notes: notes})
+		}
+	}
+
+	return song, nil
+}
+
+// mixChannels mixes the tracks channel by channel
+func mixChannels(tracks [][][]float64, channels int) [][]float64 {
+	out := make([][]float64, channels)
+	for ch := range out {
+		buffers := make([][]float64, len(tracks))
+		for i, t := range tracks {
+			buffers[i] = t[ch]
+		}
+		out[ch] = mix(buffers)
+	}
+	return out
+}
+
+// mix averages the tracks into one buffer as long as the longest one
+func mix(tracks [][]float64) []float64 {
+	length := 0
+	for _, t := range tracks {
+		if len(t) > length {
+			length = len(t)
+		}
+	}
+
+	out := make([]float64, length)
+	for _, t := range tracks {
+		for i, s := range t {
+			out[i] += s / float64(len(tracks))
+		}
+	}
+
+	return out
+}
+
+// padTo returns the samples extended with silence up to length
+func padTo(samples []float64, length int) []float64 {
+	if len(samples) >= length {
+		return samples
+	}
+
+	return append(samples, make([]float64, length-len(samples))...)
+}
+
+// writeStems renders every track to its own dir/track-N.wav, all padded
+// to the length of the longest track so they line up
+func writeStems(song *Song, dir string, channels int) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	stems := make([][][]float64, len(song.Tracks))
+	length := 0
+	for i, track := range song.Tracks {
+		stems[i] = renderTrack(track, channels)
+		if len(stems[i][0]) > length {
+			length = len(stems[i][0])
+		}
+	}
+
+	for i, stem := range stems {
+		name := filepath.Join(dir, fmt.Sprintf("track-%d.wav", i+1))
+		f, err := os.Create(name)
+		if err != nil {
+			return err
+		}
+
+		for ch := range stem {
+			stem[ch] = padTo(stem[ch], length)
+		}
+
+		err = writeWAV(f, interleave(stem), channels, SampleRate, 32, "clamp")
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+
+	return nil
+}
+
+// MapKeys replaces the key of every note (rests excluded) with f(key)
+func (s *Song) MapKeys(f func(key int) int) {
+	for _, track := range s.Tracks {
+		for i := range track.Notes {
+			if track.Notes[i].Key > 0 {
+				track.Notes[i].Key = f(track.Notes[i].Key)
+			}
+		}
+	}
+}
+
+// Limit cuts every track right after its first n notes, rests not
+// counting as notes
+func (s *Song) Limit(n int) {
+	for t, track := range s.Tracks {
+		played := 0
+		for i, note := range track.Notes {
+			if note.Key > 0 {
+				played++
+			}
+			if played == n {
+				s.Tracks[t].Notes = track.Notes[:i+1]
+				break
+			}
+		}
+	}
+}
+
+// ShortNotes counts the notes too short to reach the sustain stage of env
+func (s *Song) ShortNotes(env synth.Envelope) int {
+	n := 0
+	for _, track := range s.Tracks {
+		for _, note := range track.Notes {
+			if note.Key > 0 && note.Duration < env.Stages() {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// printFrequencies writes the frequency of every note of the song, one
+// per line, track after track. Rests are skipped
+func printFrequencies(w io.Writer, song *Song) {
+	for _, track := range song.Tracks {
+		for _, note := range track.Notes {
+			if note.Key > 0 {
+				fmt.Fprintf(w, "%.2f\n", tuning.Frequency(note.Key))
+			}
+		}
+	}
+}
+
+// TotalDuration is how long the song plays: its longest track, adding gap
+// between consecutive notes. Release tails are not included
+func TotalDuration(song *Song, gap time.Duration) time.Duration {
+	var longest time.Duration
+	for _, track := range song.Tracks {
+		var length time.Duration
+		for i, note := range track.Notes {
+			length += note.Duration
+			if i < len(track.Notes)-1 {
+				length += gap
+			}
+		}
+
+		if length > longest {
+			longest = length
+		}
+	}
+	return longest
+}
+
+// quantizeTiming returns a copy of the song with every note start snapped
+// to the nearest grid slot, division being the grid step as a fraction of
+// a whole note (0.0625 for sixteenths) at bpm. Each note lasts until the
+// next one starts so they never overlap, the last note keeps its length
+// and notes squeezed to nothing are dropped
+func quantizeTiming(song *Song, division float64, bpm int) *Song {
+	grid := division * 4 * float64(time.Minute) / float64(bpm)
+	snap := func(t time.Duration) time.Duration {
+		return time.Duration(math.Round(float64(t)/grid) * grid)
+	}
+
+	out := &Song{}
+	for _, track := range song.Tracks {
+		starts := make([]time.Duration, len(track.Notes))
+		var position time.Duration
+		for i, note := range track.Notes {
+			starts[i] = snap(position)
+			position += note.Duration
+		}
+
+		var notes []Note
+		for i, note := range track.Notes {
+			if i < len(track.Notes)-1 {
+				note.Duration = starts[i+1] - starts[i]
+			}
+			if note.Duration > 0 {
+				notes = append(notes, note)
+			}
+		}
+		out.Tracks = append(out.Tracks, Track{Notes: notes})
+	}
+
+	return out
+}
+
+// parseDivision parses a grid division like "1/16" or "0.0625"
+func parseDivision(s string) (float64, error) {
+	num, den := s, "1"
+	if i := strings.IndexByte(s, '/'); i >= 0 {
+		num, den = s[:i], s[i+1:]
+	}
+
+	n, err1 := strconv.ParseFloat(num, 64)
+	d, err2 := strconv.ParseFloat(den, 64)
+	if err1 != nil || err2 != nil || n <= 0 || d <= 0 {
+		return 0, fmt.Errorf("invalid grid division %q", s)
+	}
+	return n / d, nil
+}
+
+// outOfRange counts the notes of the song that would fall outside the
+// piano keys when shifted by semitones
+func outOfRange(song *Song, semitones int) int {
+	n := 0
+	for _, track := range song.Tracks {
+		for _, note := range track.Notes {
+			if note.Key == 0 {
+				continue
+			}
+			if key := note.Key + semitones; key < 1 || key > synth.TotalKeys {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// transposeToFit returns a copy of the song moved by the whole number of
+// octaves that leaves the fewest notes outside the piano, the smallest
+// such shift on a tie, and that shift. Notes still outside are clamped
+func transposeToFit(song *Song) (*Song, int) {
+	best := 0
+	for octaves := 1; octaves <= synth.TotalKeys/12+1; octaves++ {
+		for _, shift := range []int{-octaves, octaves} {
+			if outOfRange(song, shift*12) < outOfRange(song, best*12) {
+				best = shift
+			}
+		}
+	}
+
+	out := &Song{}
+	for _, track := range song.Tracks {
+		notes := append([]Note(nil), track.Notes...)
+		for i := range notes {
+			if notes[i].Key != 0 {
+				notes[i].Key = synth.ClampKey(notes[i].Key + best*12)
+			}
+		}
+		out.Tracks = append(out.Tracks, Track{Notes: notes})
+	}
+
+	return out, best
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tecnologer/SoundOfCode/synth"
+)
+
+func TestWriteStemsHaveEqualLength(t *testing.T) {
+	tests := []struct {
+		score    string
+		channels int
+	}{
+		// the second track is the longest one
+		{"C4:q E4:q G4:h | C3:w", 1},
+		{"C4:s | C3:w | G3:h", 2},
+	}
+
+	for _, tt := range tests {
+		song, err := ParseSong(tt.score, 120)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		dir := t.TempDir()
+		if err := writeStems(song, dir, tt.channels); err != nil {
+			t.Fatal(err)
+		}
+
+		want := -1
+		for i := range song.Tracks {
+			name := filepath.Join(dir, fmt.Sprintf("track-%d.wav", i+1))
+			f, err := os.Open(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, header, err := readWAV(f)
+			f.Close()
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+
+			if header.Channels != tt.channels {
+				t.Errorf("%q: stem %d has %d channels, want %d", tt.score, i+1, header.Channels, tt.channels)
+			}
+			if want < 0 {
+				want = header.Frames()
+			}
+			if header.Frames() != want {
+				t.Errorf("%q: stem %d has %d frames, the first one %d", tt.score, i+1, header.Frames(), want)
+			}
+		}
+
+		// the stems are as long as the longest track
+		if longest := len(renderTrack(song.Tracks[1], tt.channels)[0]); want != longest {
+			t.Errorf("%q: the stems have %d frames, the longest track %d", tt.score, want, longest)
+		}
+	}
+}
+
+func TestShortNotes(t *testing.T) {
+	// attack and decay take 60ms
+	env := synth.Envelope{Attack: 20 * time.Millisecond, Decay: 40 * time.Millisecond, Sustain: 0.5}
+	tests := []struct {
+		score string
+		bpm   int
+		want  int
+	}{
+		{"C4:q E4:s", 120, 0},
+		// a sixteenth lasts 25ms at 600 BPM
+		{"C4:q E4:s", 600, 1},
+		{"C4:s R:s E4:s | G4:w A4:s", 600, 3},
+		{"C4:et E4:q", 1000, 1},
+	}
+
+	for _, tt := range tests {
+		song, err := ParseSong(tt.score, tt.bpm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := song.ShortNotes(env); got != tt.want {
+			t.Errorf("%q at %d BPM: %d short notes, want %d", tt.score, tt.bpm, got, tt.want)
+		}
+	}
+}
+
+func TestPrintFrequencies(t *testing.T) {
+	tests := []struct {
+		score string
+		want  string
+	}{
+		{"A4:q", "440.00\n"},
+		{"C4:q R:q E4:q G4:h", "261.63\n329.63\n392.00\n"},
+		{"A4:q | A3:q A5:q", "440.00\n220.00\n880.00\n"},
+		{"R:w", ""},
+	}
+
+	for _, tt := range tests {
+		song, err := ParseSong(tt.score, 120)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		printFrequencies(&buf, song)
+		if buf.String() != tt.want {
+			t.Errorf("%q: printed %q, want %q", tt.score, buf.String(), tt.want)
+		}
+	}
+}
+
+func TestQuantizeTiming(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name      string
+		durations []time.Duration
+		want      []time.Duration
+	}{
+		// sixteenths last 125ms at 120 BPM
+		{"off grid starts snap", []time.Duration{130 * ms, 120 * ms, 260 * ms, 490 * ms}, []time.Duration{125 * ms, 125 * ms, 250 * ms, 490 * ms}},
+		{"on grid stays", []time.Duration{125 * ms, 375 * ms, 500 * ms, 100 * ms}, []time.Duration{125 * ms, 375 * ms, 500 * ms, 100 * ms}},
+		{"late start rounds up", []time.Duration{190 * ms, 310 * ms}, []time.Duration{250 * ms, 310 * ms}},
+		{"squeezed notes are dropped", []time.Duration{10 * ms, 40 * ms, 250 * ms}, []time.Duration{250 * ms}},
+	}
+
+	for _, tt := range tests {
+		var notes []Note
+		for i, d := range tt.durations {
+			notes = append(notes, Note{Key: 40 + i, Duration: d})
+		}
+		song := &Song{Tracks: []Track{{Notes: notes}}}
+
+		got := quantizeTiming(song, 1.0/16, 120).Tracks[0].Notes
+		if len(got) != len(tt.want) {
+			t.Fatalf("%s: %d notes, want %d: %+v", tt.name, len(got), len(tt.want), got)
+		}
+		for i := range got {
+			if got[i].Duration != tt.want[i] {
+				t.Errorf("%s: note %d lasts %v, want %v", tt.name, i, got[i].Duration, tt.want[i])
+			}
+		}
+
+		// the song itself isn't changed
+		if song.Tracks[0].Notes[0].Duration != tt.durations[0] {
+			t.Errorf("%s: quantizeTiming changed the song", tt.name)
+		}
+	}
+}
+
+func TestParseDivision(t *testing.T) {
+	tests := []struct {
+		s    string
+		want float64
+	}{
+		{"1/16", 0.0625},
+		{"1/8", 0.125},
+		{"3/8", 0.375},
+		{"0.25", 0.25},
+	}
+
+	for _, tt := range tests {
+		if got, err := parseDivision(tt.s); err != nil || got != tt.want {
+			t.Errorf("parseDivision(%q) = %v, %v, want %v", tt.s, got, err, tt.want)
+		}
+	}
+
+	for _, s := range []string{"", "1/0", "x/4", "-1/4", "1/"} {
+		if _, err := parseDivision(s); err == nil {
+			t.Errorf("parseDivision(%q) should fail", s)
+		}
+	}
+}
+
+func TestTotalDuration(t *testing.T) {
+	tests := []struct {
+		score string
+		gap   time.Duration
+		want  time.Duration
+	}{
+		{"C4:q E4:q G4:h", 0, 2 * time.Second},
+		// three notes, two gaps
+		{"C4:q E4:q G4:h", 100 * time.Millisecond, 2200 * time.Millisecond},
+		{"C4:q R:q E4:e", 50 * time.Millisecond, 1350 * time.Millisecond},
+		// the longest track
+		{"C4:w | C3:q C3:q C3:q", 500 * time.Millisecond, 2500 * time.Millisecond},
+		// triplets are rounded to the nanosecond
+		{"C4:et C4:et C4:et", 0, 500*time.Millisecond + 1},
+	}
+
+	for _, tt := range tests {
+		song, err := ParseSong(tt.score, 120)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := TotalDuration(song, tt.gap); got != tt.want {
+			t.Errorf("%q with %v gaps: %v, want %v", tt.score, tt.gap, got, tt.want)
+		}
+	}
+}
+
+func TestTransposeToFit(t *testing.T) {
+	tests := []struct {
+		name  string
+		keys  [][]int
+		shift int
+		want  [][]int
+	}{
+		{"in range", [][]int{{40, 44, 0, 47}}, 0, [][]int{{40, 44, 0, 47}}},
+		{"an octave up", [][]int{{90, 95, 100}}, -1, [][]int{{78, 83, 88}}},
+		{"very high", [][]int{{100, 105, 0, 99}, {110}}, -2, [][]int{{76, 81, 0, 75}, {86}}},
+		{"very low", [][]int{{-20, -15, 0}, {-5}}, 2, [][]int{{4, 9, 0}, {19}}},
+		// wider than the piano: no shift does better, the top is clamped
+		{"too wide", [][]int{{1, 100}}, 0, [][]int{{1, 88}}},
+		// the shift that leaves fewest notes out wins over the smaller one
+		{"mostly high", [][]int{{2, 95, 96, 97}}, -1, [][]int{{1, 83, 84, 85}}},
+	}
+
+	for _, tt := range tests {
+		song := &Song{}
+		for _, keys := range tt.keys {
+			var track Track
+			for _, key := range keys {
+				track.Notes = append(track.Notes, Note{Key: key, Duration: 250 * time.Millisecond})
+			}
+			song.Tracks = append(song.Tracks, track)
+		}
+
+		fitted, shift := transposeToFit(song)
+		if shift != tt.shift {
+			t.Errorf("%s: shifted %d octaves, want %d", tt.name, shift, tt.shift)
+		}
+
+		var keys [][]int
+		for i, track := range fitted.Tracks {
+			keys = append(keys, nil)
+			for _, note := range track.Notes {
+				if note.Duration != 250*time.Millisecond {
+					t.Errorf("%s: note duration changed to %v", tt.name, note.Duration)
+				}
+				keys[i] = append(keys[i], note.Key)
+			}
+		}
+		if !reflect.DeepEqual(keys, tt.want) {
+			t.Errorf("%s: keys %v, want %v", tt.name, keys, tt.want)
+		}
+
+		// the song given is left as it was
+		if song.Tracks[0].Notes[0].Key != tt.keys[0][0] {
+			t.Errorf("%s: the original song was changed", tt.name)
+		}
+	}
+}
+
+func TestSongLimit(t *testing.T) {
+	tests := []struct {
+		score string
+		n     int
+		want  []string
+	}{
+		{"C4:q D4:q E4:q F4:q G4:q", 3, []string{"C4 D4 E4"}},
+		// rests aren't notes, the ones before the last kept note stay
+		{"C4:q R:q D4:q E4:q F4:q R:q", 3, []string{"C4 R D4 E4"}},
+		{"C4:q D4:q | C3:h R:q E3:q G3:q", 2, []string{"C4 D4", "C3 R E3"}},
+		// a shorter track is left whole
+		{"C4:q D4:q", 5, []string{"C4 D4"}},
+	}
+
+	for _, tt := range tests {
+		song, err := ParseSong(tt.score, 120)
+		if err != nil {
+			t.Fatal(err)
+		}
+		song.Limit(tt.n)
+
+		for i, track := range song.Tracks {
+			var names []string
+			for _, note := range track.Notes {
+				name := "R"
+				if note.Key > 0 {
+					name = synth.KeyName(note.Key)
+				}
+				names = append(names, name)
+			}
+			if got := fmt.Sprint(names); got != fmt.Sprint(strings.Fields(tt.want[i])) {
+				t.Errorf("%q limited to %d: track %d plays %s, want [%s]", tt.score, tt.n, i+1, got, tt.want[i])
+			}
+
+			// only those notes are scheduled, the song ends with the last
+			spans, total := schedule(track.Notes, sine(synth.Envelope{Sustain: 1}), timing{Articulation: 1})
+			played := len(strings.Fields(strings.ReplaceAll(tt.want[i], "R", "")))
+			if len(spans) != played || total != spans[len(spans)-1].end {
+				t.Errorf("%q limited to %d: track %d schedules %d notes over %d samples", tt.score, tt.n, i+1, len(spans), total)
+			}
+		}
+	}
+}
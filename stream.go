@@ -0,0 +1,382 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"strings"
+)
+
+// streamBlock is how many frames the streaming render produces at a time
+const streamBlock = 1 << 14
+
+// trackStream renders a track a block at a time, one buffer per channel,
+// through its own chain of track effects per channel
+type trackStream struct {
+	spans       []span
+	in          Instrument
+	clock       []float64
+	layout      channelLayout
+	ratios      []float64
+	threads     int
+	sensitivity float64
+	effects     [][]Effect
+
+	// position is where the next block starts on the timeline, from
+	// -start-at on, and end is where the track ends, its tail included
+	position, end int
+}
+
+// newTrackStream schedules the notes of the track with the instrument and
+// the timing set by the flags
+func newTrackStream(track Track, channelCount int) *trackStream {
+	in, err := instrument(*instrumentName, envelope())
+	check(err)
+
+	if *harmonicProfile != "" {
+		harmonics, err := parseHarmonics(*harmonicProfile)
+		check(err)
+
+		in = in.WithHarmonics(harmonics)
+	}
+
+	shape, err := waveShape()
+	check(err)
+
+	if shape != nil {
+		in = in.WithShape(shape)
+	}
+
+	if *harmonicDecay > 0 {
+		in = in.WithHarmonicDecay(*harmonicDecay)
+	}
+
+	if *harmonicDetune > 0 {
+		in = in.WithInharmonicity(*harmonicDetune)
+	}
+
+	if *overtoneCeiling > 0 {
+		in = in.WithOvertoneCeiling(*overtoneCeiling)
+	}
+
+	t := timing{Gap: *noteGap, Articulation: *articulation, ZeroCross: *zeroCrossings, Seed: *seed}
+	if *pitchJitter > 0 && *seedMode == "per-note" {
+		t.NoteJitter = *pitchJitter
+	}
+
+	spans, total := schedule(track.Notes, in, t)
+
+	if *pitchJitter > 0 && *seedMode == "global" {
+		// the note positions don't depend on the clock, only their phases
+		t.Clock = driftClock(total, *pitchJitter, rand.New(rand.NewSource(*seed)))
+		spans, _ = schedule(track.Notes, in, t)
+	}
+
+	// the notes under way at -start-at keep the phase and envelope they
+	// have there
+	start := int(startAt.Seconds() * SampleRate)
+	if start > total {
+		start = total
+	}
+	if start > 0 {
+		spans = skipTo(spans, start)
+	}
+
+	s := &trackStream{
+		spans:       spans,
+		in:          in,
+		clock:       t.Clock,
+		layout:      channelLayouts[channelCount],
+		threads:     *renderThreads,
+		sensitivity: *veloFilter,
+		effects:     make([][]Effect, channelCount),
+		position:    start,
+		// silence for the effects to ring out into
+		end: total + int(renderTail.Seconds()*SampleRate),
+	}
+
+	// with detune the source channels are spread from -detune/2 to
+	// +detune/2 cents, otherwise they all get the same samples
+	sources := len(s.layout.Sources)
+	if *stereoDetune != 0 && sources > 1 {
+		for ch := 0; ch < sources; ch++ {
+			cents := *stereoDetune * (float64(ch)/float64(sources-1) - 0.5)
+			s.ratios = append(s.ratios, math.Pow(2, cents/1200))
+		}
+	}
+
+	for ch := range s.effects {
+		s.effects[ch], err = effectChain()
+		check(err)
+	}
+
+	return s
+}
+
+// Read renders the next frames of the track into the start of out, one
+// buffer per channel, and returns how many, 0 once the track is over
+func (s *trackStream) Read(out [][]float64) int {
+	n := len(out[0])
+	if left := s.end - s.position; n > left {
+		n = left
+	}
+	if n <= 0 {
+		return 0
+	}
+
+	frames := make([][]float64, len(out))
+	for ch := range out {
+		frames[ch] = out[ch][:n]
+		for i := range frames[ch] {
+			frames[ch][i] = 0
+		}
+	}
+
+	if s.ratios == nil {
+		first := frames[s.layout.Sources[0]]
+		renderChannel(s.spans, s.in, s.position, first, s.threads, 1, s.sensitivity, s.clock)
+		for _, ch := range s.layout.Sources[1:] {
+			copy(frames[ch], first)
+		}
+	} else {
+		for i, ch := range s.layout.Sources {
+			renderChannel(s.spans, s.in, s.position, frames[ch], s.threads, s.ratios[i], s.sensitivity, s.clock)
+		}
+	}
+
+	for ch, chain := range s.effects {
+		for _, effect := range chain {
+			samples := frames[ch]
+			for i := range samples {
+				samples[i] = effect.Process(samples[i])
+			}
+		}
+	}
+
+	s.position += n
+	return n
+}
+
+// effectChain returns the track effects in -effects order, leaving out the
+// ones their flags turn off. An effect listed twice runs twice, each time
+// with its own state
+func effectChain() ([]Effect, error) {
+	effects, err := trackEffects()
+	if err != nil {
+		return nil, err
+	}
+
+	var chain []Effect
+	used := map[string]bool{}
+	for _, name := range strings.Split(*effectOrder, ",") {
+		name = strings.TrimSpace(name)
+		if used[name] {
+			if effects, err = trackEffects(); err != nil {
+				return nil, err
+			}
+		}
+		used[name] = true
+
+		effect, ok := effects[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown effect %q in -effects", name)
+		}
+
+		if effect != nil {
+			chain = append(chain, effect)
+		}
+	}
+	return chain, nil
+}
+
+// masterStage is the part of the master processing that goes through the
+// mix in order: fade-in, auto-pan and decorrelation. It keeps its place,
+// so the mix can go through it whole or a block at a time
+type masterStage struct {
+	fade          int
+	pan           *AutoPan
+	decorrelators []Decorrelator
+	position      int
+}
+
+// newMasterStage sets the stage up from the flags for channels channels
+func newMasterStage(channels int) *masterStage {
+	m := &masterStage{fade: int(fadeInFor.Seconds() * SampleRate)}
+
+	if channels == 2 && *autopanDepth > 0 {
+		m.pan = &AutoPan{Rate: *autopanRate, Depth: *autopanDepth}
+	}
+
+	if *decorrelate > 0 && channels > 1 {
+		for ch := 0; ch < channels; ch++ {
+			m.decorrelators = append(m.decorrelators, NewDecorrelator(ch, *decorrelate))
+		}
+	}
+	return m
+}
+
+// Process runs the next frames of the mix, one buffer per channel,
+// through the stage
+func (m *masterStage) Process(channels [][]float64) {
+	n := len(channels[0])
+
+	// a linear ramp from 0 at the first sample up to 1 at fade
+	for i := 0; i < n && m.position+i < m.fade; i++ {
+		for _, samples := range channels {
+			samples[i] *= float64(m.position+i) / float64(m.fade)
+		}
+	}
+
+	if m.pan != nil {
+		left, right := channels[0], channels[1]
+		for i := range left {
+			left[i], right[i] = m.pan.Process(left[i], right[i])
+		}
+	}
+
+	for ch, d := range m.decorrelators {
+		samples := channels[ch]
+		for i := range samples {
+			samples[i] = d.Process(samples[i])
+		}
+	}
+
+	m.position += n
+}
+
+// songStream renders the song a block at a time: the count-in, then the
+// tracks mixed together and run through the master stage
+type songStream struct {
+	tracks []*trackStream
+	// pans are the -chord-spread positions of the tracks, nil for none
+	pans   []float64
+	clicks []float64
+	master *masterStage
+	buf    [][]float64
+}
+
+// newSongStream sets up the streams of every track of the song
+func newSongStream(song *Song) *songStream {
+	s := &songStream{master: newMasterStage(*channelCount), buf: make([][]float64, *channelCount)}
+	for _, track := range song.Tracks {
+		s.tracks = append(s.tracks, newTrackStream(track, *channelCount))
+	}
+
+	if *chordSpread > 0 && *channelCount == 2 && (*chordList != "" || *progression != "") {
+		s.pans = voicePans(song, *chordSpread)
+	}
+
+	if *countInBars > 0 {
+		s.clicks = countIn(*countInBars, *bpm)
+	}
+	return s
+}
+
+// Read renders the next frames of the song into the start of out, one
+// buffer per channel, and returns how many, 0 once the song is over
+func (s *songStream) Read(out [][]float64) int {
+	size := len(out[0])
+	frames := make([][]float64, len(out))
+
+	n := 0
+	if len(s.clicks) > 0 {
+		n = copy(out[0], s.clicks)
+		for _, samples := range out[1:] {
+			copy(samples, s.clicks[:n])
+		}
+		s.clicks = s.clicks[n:]
+	} else {
+		for ch := range out {
+			for i := range out[ch] {
+				out[ch][i] = 0
+			}
+			if cap(s.buf[ch]) < size {
+				s.buf[ch] = make([]float64, size)
+			}
+			s.buf[ch] = s.buf[ch][:size]
+		}
+
+		for i, track := range s.tracks {
+			m := track.Read(s.buf)
+			for ch := range frames {
+				frames[ch] = s.buf[ch][:m]
+			}
+			if s.pans != nil {
+				panStereo(frames, s.pans[i])
+			}
+
+			// the tracks are averaged, the ones already over adding silence
+			for ch, samples := range frames {
+				for j, v := range samples {
+					out[ch][j] += v / float64(len(s.tracks))
+				}
+			}
+			if m > n {
+				n = m
+			}
+		}
+	}
+
+	for ch := range out {
+		frames[ch] = out[ch][:n]
+	}
+	s.master.Process(frames)
+	return n
+}
+
+// streamable reports whether the song can be written out while it
+// renders, with memory bounded whatever its length: the output is WAV or
+// raw PCM and no pass needs the whole render first
+func streamable(encoder AudioEncoder) bool {
+	switch encoder.(type) {
+	case wavEncoder, pcmEncoder:
+	default:
+		return false
+	}
+
+	// global -pitch-jitter keeps a clock for every sample of the song
+	jitter := *pitchJitter > 0 && *seedMode == "global"
+	return !*abMode && !jitter && *sidechain == "" && *lufs == 0 && !*monoCompatible && !*trim && !*replayGain
+}
+
+// streamSong renders the song a block at a time into w with the WAV or raw
+// PCM encoder. The WAV header is written with its sizes left for Close to
+// patch, so w has to be seekable
+func streamSong(w io.Writer, encoder AudioEncoder, song *Song) error {
+	var out interface{ Write([]float64) error }
+	done := func() error { return nil }
+	switch e := encoder.(type) {
+	case wavEncoder:
+		ww, err := newWAVWriter(w, *channelCount, SampleRate, e.BitsPerSample, e.Clip, -1)
+		if err != nil {
+			return err
+		}
+		out, done = ww, ww.Close
+	case pcmEncoder:
+		out = newPCMWriter(w, e.BitsPerSample, e.Clip)
+	default:
+		return fmt.Errorf("can't stream to %T", encoder)
+	}
+
+	s := newSongStream(song)
+	block := make([][]float64, *channelCount)
+	for ch := range block {
+		block[ch] = make([]float64, streamBlock)
+	}
+
+	frames := make([][]float64, len(block))
+	for {
+		n := s.Read(block)
+		if n == 0 {
+			return done()
+		}
+
+		for ch := range block {
+			frames[ch] = block[ch][:n]
+		}
+		if err := out.Write(interleave(frames)); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestFadeIn(t *testing.T) {
+	tests := []struct {
+		config string
+		fade   int
+	}{
+		{"fade-in=100ms", 4410},
+		{"fade-in=1s,channel-count=2", SampleRate},
+		{"fade-in=0s", 0},
+	}
+
+	for _, tt := range tests {
+		config := tt.config
+		var stage *masterStage
+		var channels [][]float64
+		err := withFlags(config, func() {
+			stage = newMasterStage(*channelCount)
+			channels = make([][]float64, *channelCount)
+			for ch := range channels {
+				channels[ch] = make([]float64, 2*SampleRate)
+				for i := range channels[ch] {
+					channels[ch][i] = 1
+				}
+			}
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if stage.fade != tt.fade {
+			t.Errorf("%s: fades over %d samples, want %d", config, stage.fade, tt.fade)
+		}
+
+		// a block at a time, as the song is streamed
+		for from := 0; from < len(channels[0]); from += 1000 {
+			to := from + 1000
+			if to > len(channels[0]) {
+				to = len(channels[0])
+			}
+			block := make([][]float64, len(channels))
+			for ch := range channels {
+				block[ch] = channels[ch][from:to]
+			}
+			stage.Process(block)
+		}
+
+		// a straight ramp from silence up to the full level at the fade
+		// length, untouched from there on
+		for ch, samples := range channels {
+			for i, s := range samples {
+				want := 1.0
+				if i < stage.fade {
+					want = float64(i) / float64(stage.fade)
+				}
+				if s != want {
+					t.Fatalf("%s: channel %d sample %d is %v, want %v", config, ch, i, s, want)
+				}
+			}
+		}
+	}
+}
+
+// seekBuffer is an in memory io.WriteSeeker that remembers its largest
+// single write
+type seekBuffer struct {
+	data    []byte
+	pos     int
+	largest int
+}
+
+func (b *seekBuffer) Write(p []byte) (int, error) {
+	if end := b.pos + len(p); end > len(b.data) {
+		b.data = append(b.data, make([]byte, end-len(b.data))...)
+	}
+	copy(b.data[b.pos:], p)
+	b.pos += len(p)
+	if len(p) > b.largest {
+		b.largest = len(p)
+	}
+	return len(p), nil
+}
+
+func (b *seekBuffer) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		b.pos = int(offset)
+	case io.SeekCurrent:
+		b.pos += int(offset)
+	case io.SeekEnd:
+		b.pos = len(b.data) + int(offset)
+	}
+	if b.pos < 0 {
+		return 0, errors.New("seek before the start")
+	}
+	return int64(b.pos), nil
+}
+
+func TestWAVWriterPatchesTheSizes(t *testing.T) {
+	tests := []struct {
+		channels, bits int
+		seconds        float64
+	}{
+		{1, 16, 3},
+		{2, 24, 5},
+		{2, 32, 2.5},
+		{1, 8, 0},
+	}
+
+	for _, tt := range tests {
+		var out seekBuffer
+		ww, err := newWAVWriter(&out, tt.channels, SampleRate, tt.bits, "clamp", -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// a block at a time, as the scheduler produces them
+		samples := noise(int(tt.seconds*SampleRate)*tt.channels, 3)
+		for from := 0; from < len(samples); from += 1000 * tt.channels {
+			to := from + 1000*tt.channels
+			if to > len(samples) {
+				to = len(samples)
+			}
+			if err := ww.Write(samples[from:to]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := ww.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		dataSize := len(samples) * tt.bits / 8
+		if len(out.data) != 44+dataSize {
+			t.Errorf("%d channels %d-bit %gs: %d bytes, want %d", tt.channels, tt.bits, tt.seconds, len(out.data), 44+dataSize)
+			continue
+		}
+		if riff := binary.LittleEndian.Uint32(out.data[4:8]); riff != uint32(36+dataSize) {
+			t.Errorf("%d channels %d-bit %gs: RIFF size %d, want %d", tt.channels, tt.bits, tt.seconds, riff, 36+dataSize)
+		}
+		if data := binary.LittleEndian.Uint32(out.data[40:44]); data != uint32(dataSize) {
+			t.Errorf("%d channels %d-bit %gs: data size %d, want %d", tt.channels, tt.bits, tt.seconds, data, dataSize)
+		}
+		if out.pos != len(out.data) {
+			t.Errorf("%d channels %d-bit %gs: left at byte %d of %d", tt.channels, tt.bits, tt.seconds, out.pos, len(out.data))
+		}
+
+		// the file reads back like any other
+		if _, err := readWAVHeader(bytes.NewReader(out.data)); err != nil {
+			t.Errorf("%d channels %d-bit %gs: %v", tt.channels, tt.bits, tt.seconds, err)
+		}
+	}
+}
+
+func TestWAVWriterNeedsASeeker(t *testing.T) {
+	var b bytes.Buffer
+	if _, err := newWAVWriter(&b, 1, SampleRate, 16, "clamp", -1); err == nil {
+		t.Error("a WAV of unknown length written to a bytes.Buffer should fail")
+	}
+
+	// a known length written short can't be patched either
+	ww, err := newWAVWriter(&b, 1, SampleRate, 16, "clamp", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ww.Write(make([]float64, 50)); err != nil {
+		t.Fatal(err)
+	}
+	if err := ww.Close(); err == nil {
+		t.Error("closing a WAV 50 frames short on a bytes.Buffer should fail")
+	}
+}
+
+func TestStreamSongMatchesTheBufferedRender(t *testing.T) {
+	// long enough for several blocks, the streamed song is written a block
+	// at a time
+	const score = "C4:q E4:q G4:q C5:q | E3:h G3:h | C3:w"
+	tests := []struct {
+		config  string
+		encoder AudioEncoder
+	}{
+		{"channel-count=1", wavEncoder{BitsPerSample: 16, Clip: "clamp"}},
+		{"channel-count=2,instrument=pluck", wavEncoder{BitsPerSample: 24, Clip: "clamp"}},
+		{"channel-count=2,fade-in=200ms,autopan-rate=2,autopan-depth=0.8", wavEncoder{BitsPerSample: 32, Clip: "clamp"}},
+		{"channel-count=1,count-in=1", pcmEncoder{BitsPerSample: 16, Clip: "clamp"}},
+	}
+
+	song, err := ParseSong(score, 120)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		var buffered bytes.Buffer
+		var streamed seekBuffer
+		var streamErr error
+		err := withFlags(tt.config, func() {
+			channels := renderSong(song)
+			if err := tt.encoder.Encode(&buffered, interleave(channels), len(channels), SampleRate); err != nil {
+				t.Fatal(err)
+			}
+			streamErr = streamSong(&streamed, tt.encoder, song)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if streamErr != nil {
+			t.Errorf("%s: %v", tt.config, streamErr)
+			continue
+		}
+
+		if !bytes.Equal(streamed.data, buffered.Bytes()) {
+			t.Errorf("%s: streamed %d bytes differ from the %d buffered ones", tt.config, len(streamed.data), buffered.Len())
+		}
+		if limit := streamBlock * 2 * 4; streamed.largest > limit {
+			t.Errorf("%s: wrote %d bytes at once, want at most a block of %d", tt.config, streamed.largest, limit)
+		}
+	}
+}
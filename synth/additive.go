@@ -0,0 +1,64 @@
+package synth
+
+import "math"
+
+// Partial is a sine at Ratio times the note frequency, fading at Decay
+type Partial struct {
+	Ratio     float64 `json:"ratio"`
+	Amplitude float64 `json:"amplitude"`
+	// Decay is how fast the partial dies out, scaling it by exp(-Decay*t)
+	// t seconds into the note. 0 keeps it steady
+	Decay float64 `json:"decay"`
+}
+
+// Gain is the amplitude of the partial t seconds into the note
+func (p Partial) Gain(t float64) float64 {
+	if p.Decay <= 0 {
+		return p.Amplitude
+	}
+	return p.Amplitude * math.Exp(-p.Decay*t)
+}
+
+// Harmonics returns the partials of a harmonic spectrum, amplitudes[i]
+// being the amplitude of harmonic i+1
+func Harmonics(amplitudes ...float64) []Partial {
+	partials := make([]Partial, len(amplitudes))
+	for i, a := range amplitudes {
+		partials[i] = Partial{Ratio: float64(i + 1), Amplitude: a}
+	}
+	return partials
+}
+
+// Additive is a sum of sine partials, scaled so it never exceeds full
+// scale. Partials above Nyquist are left out
+type Additive struct {
+	Frequency float64
+
+	partials []Partial
+	n        int
+}
+
+// NewAdditive returns an oscillator playing the partials over frequency Hz
+func NewAdditive(partials []Partial, frequency float64) *Additive {
+	return &Additive{Frequency: frequency, partials: partials}
+}
+
+// Next returns the next sample of the sum
+func (a *Additive) Next() float64 {
+	t := float64(a.n) / SampleRate
+	a.n++
+
+	sum, total := 0.0, 0.0
+	for _, p := range a.partials {
+		total += math.Abs(p.Amplitude)
+		if p.Ratio*a.Frequency >= SampleRate/2 {
+			continue
+		}
+		sum += p.Gain(t) * math.Sin(τ*p.Ratio*a.Frequency*t)
+	}
+
+	if total == 0 {
+		return 0
+	}
+	return sum / total
+}
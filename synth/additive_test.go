@@ -0,0 +1,113 @@
+package synth
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPartialGain(t *testing.T) {
+	tests := []struct {
+		partial Partial
+		t       float64
+		want    float64
+	}{
+		{Partial{Ratio: 1, Amplitude: 0.5}, 3, 0.5},
+		{Partial{Ratio: 2, Amplitude: 1, Decay: 2}, 0, 1},
+		{Partial{Ratio: 2, Amplitude: 1, Decay: 2}, 0.5, math.Exp(-1)},
+		{Partial{Ratio: 3, Amplitude: 0.4, Decay: 1}, 2, 0.4 * math.Exp(-2)},
+		// a negative decay doesn't make it grow
+		{Partial{Ratio: 1, Amplitude: 0.5, Decay: -1}, 1, 0.5},
+	}
+
+	for _, tt := range tests {
+		if got := tt.partial.Gain(tt.t); math.Abs(got-tt.want) > 1e-12 {
+			t.Errorf("%+v at %gs: gain %v, want %v", tt.partial, tt.t, got, tt.want)
+		}
+	}
+}
+
+func TestHarmonics(t *testing.T) {
+	got := Harmonics(1, 0.5, 0, 0.25)
+	want := []Partial{{Ratio: 1, Amplitude: 1}, {Ratio: 2, Amplitude: 0.5}, {Ratio: 3}, {Ratio: 4, Amplitude: 0.25}}
+	if len(got) != len(want) {
+		t.Fatalf("Harmonics = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("harmonic %d is %+v, want %+v", i+1, got[i], want[i])
+		}
+	}
+}
+
+func TestAdditive(t *testing.T) {
+	// 441 Hz fits a whole number of periods of every partial in a second
+	const frequency = 441.0
+	tests := []struct {
+		name     string
+		partials []Partial
+		// levels are the amplitudes expected at each partial over the
+		// first second
+		levels []float64
+	}{
+		{
+			"harmonics",
+			Harmonics(1, 0.5, 0.25),
+			[]float64{1 / 1.75, 0.5 / 1.75, 0.25 / 1.75},
+		},
+		{
+			"inharmonic",
+			[]Partial{{Ratio: 1, Amplitude: 1}, {Ratio: 2.5, Amplitude: 1}},
+			[]float64{0.5, 0.5},
+		},
+		{
+			// the partial above Nyquist isn't played but still counts
+			// toward the scaling
+			"above Nyquist",
+			[]Partial{{Ratio: 1, Amplitude: 1}, {Ratio: 60, Amplitude: 1}},
+			[]float64{0.5, 0},
+		},
+	}
+
+	for _, tt := range tests {
+		s := samples(NewAdditive(tt.partials, frequency), SampleRate)
+		peak := 0.0
+		for _, v := range s {
+			peak = math.Max(peak, math.Abs(v))
+		}
+		if peak > 1 {
+			t.Errorf("%s: peaks at %v, over full scale", tt.name, peak)
+		}
+
+		for i, p := range tt.partials {
+			if p.Ratio*frequency >= SampleRate/2 {
+				// it would alias onto this frequency
+				continue
+			}
+			if got := math.Sqrt(2 * harmonicPower(s, p.Ratio*frequency)); math.Abs(got-tt.levels[i]) > 1e-3 {
+				t.Errorf("%s: partial at %gx is at %.4f, want %.4f", tt.name, p.Ratio, got, tt.levels[i])
+			}
+		}
+	}
+
+	if s := samples(NewAdditive(nil, frequency), 10); s[5] != 0 {
+		t.Errorf("no partials play %v, want silence", s[5])
+	}
+}
+
+func TestAdditiveDecay(t *testing.T) {
+	// each partial fades at its own rate, the steady one stays
+	partials := []Partial{{Ratio: 1, Amplitude: 1}, {Ratio: 2, Amplitude: 1, Decay: 4}}
+	s := samples(NewAdditive(partials, 441), 2*SampleRate)
+	level := func(ratio float64, from int) float64 {
+		return math.Sqrt(2 * harmonicPower(s[from:from+4400], ratio*441))
+	}
+
+	if kept := level(1, SampleRate) / level(1, 0); math.Abs(kept-1) > 1e-3 {
+		t.Errorf("the steady partial keeps %.4f of its level after 1s, want 1", kept)
+	}
+	// 4400 samples are 44 whole periods, and both windows average the fade
+	// over them the same way
+	if kept, want := level(2, SampleRate)/level(2, 0), math.Exp(-4); math.Abs(kept-want) > want*0.01 {
+		t.Errorf("the decaying partial keeps %.4f of its level after 1s, want %.4f", kept, want)
+	}
+}
@@ -0,0 +1,70 @@
+package synth
+
+import "time"
+
+// Envelope shapes the amplitude of a note: it rises to 1 during Attack,
+// stays there during Hold, falls to the Sustain level during Decay, holds
+// it while the note is held and fades to silence during Release once the
+// note ends
+type Envelope struct {
+	Attack time.Duration
+	Hold   time.Duration
+	Decay  time.Duration
+	// Sustain is a level from 0 to 1, not a time: it lasts for whatever
+	// is left of the note after attack, hold and decay
+	Sustain float64
+	Release time.Duration
+}
+
+// Amplitude returns the gain at t seconds into a note held for held
+// seconds. After held the release takes over
+func (e Envelope) Amplitude(t, held float64) float64 {
+	e = e.fit(held)
+	if t < held {
+		return e.level(t)
+	}
+
+	release := e.Release.Seconds()
+	r := t - held
+	if r >= release {
+		return 0
+	}
+
+	return e.level(held) * (1 - r/release)
+}
+
+// level is the attack/hold/decay/sustain value at t seconds
+func (e Envelope) level(t float64) float64 {
+	attack, hold, decay := e.Attack.Seconds(), e.Hold.Seconds(), e.Decay.Seconds()
+	switch {
+	case t < attack:
+		return t / attack
+	case t < attack+hold:
+		return 1
+	case t < attack+hold+decay:
+		return 1 - (1-e.Sustain)*(t-attack-hold)/decay
+	default:
+		return e.Sustain
+	}
+}
+
+// Stages is the time it takes to reach the sustain level
+func (e Envelope) Stages() time.Duration {
+	return e.Attack + e.Hold + e.Decay
+}
+
+// fit shrinks attack, hold and decay proportionally when the note is too
+// short to reach the sustain stage, so the note still peaks and settles
+// before its release
+func (e Envelope) fit(held float64) Envelope {
+	stages := e.Stages().Seconds()
+	if held <= 0 || stages <= held {
+		return e
+	}
+
+	scale := held / stages
+	e.Attack = time.Duration(float64(e.Attack) * scale)
+	e.Hold = time.Duration(float64(e.Hold) * scale)
+	e.Decay = time.Duration(float64(e.Decay) * scale)
+	return e
+}
@@ -0,0 +1,131 @@
+package synth
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestEnvelopeFitsShortNotes(t *testing.T) {
+	env := Envelope{Attack: 30 * time.Millisecond, Hold: 10 * time.Millisecond, Decay: 40 * time.Millisecond, Sustain: 0.6, Release: 5 * time.Millisecond}
+
+	for _, held := range []time.Duration{18 * time.Millisecond, 22 * time.Millisecond, time.Millisecond, 79 * time.Millisecond} {
+		h := held.Seconds()
+		end := h + env.Release.Seconds()
+
+		peak := 0.0
+		for i := 0; i <= 1000; i++ {
+			tm := end * float64(i) / 1000
+			a := env.Amplitude(tm, h)
+			if a < 0 || a > 1 {
+				t.Fatalf("held %v: amplitude %v at %.4fs", held, a, tm)
+			}
+			if a > peak {
+				peak = a
+			}
+		}
+
+		// the stages shrink so the note still peaks and settles on the
+		// sustain level before its release, then it fades to 0
+		if peak < 0.99 {
+			t.Errorf("held %v: peaks at %.3f, want 1", held, peak)
+		}
+		if a := env.Amplitude(h-1e-9, h); a < env.Sustain-1e-3 || a > env.Sustain+1e-3 {
+			t.Errorf("held %v: %.3f when released, want the sustain %g", held, a, env.Sustain)
+		}
+		if a := env.Amplitude(end-1e-6, h); a > 1e-3 {
+			t.Errorf("held %v: %.4f at the end of the release, want about 0", held, a)
+		}
+	}
+}
+
+func TestEnvelopeHold(t *testing.T) {
+	tests := []Envelope{
+		{Attack: 10 * time.Millisecond, Hold: 50 * time.Millisecond, Decay: 100 * time.Millisecond, Sustain: 0.4},
+		{Hold: 200 * time.Millisecond, Decay: 10 * time.Millisecond},
+		{Attack: 100 * time.Millisecond, Hold: time.Millisecond, Decay: time.Second, Sustain: 0.9},
+	}
+
+	const held = 2.0
+	for _, env := range tests {
+		attack, hold := env.Attack.Seconds(), env.Hold.Seconds()
+
+		// at the peak through the hold window
+		for i := 0; i < 100; i++ {
+			tm := attack + hold*float64(i)/100
+			if a := env.Amplitude(tm, held); a != 1 {
+				t.Errorf("%+v: %v at %.4fs, in the hold", env, a, tm)
+			}
+		}
+
+		// decaying right after
+		after := attack + hold + env.Decay.Seconds()/100
+		if a := env.Amplitude(after, held); a >= 1 || a <= env.Sustain {
+			t.Errorf("%+v: %v right after the hold, want between the sustain and 1", env, a)
+		}
+	}
+}
+
+func TestEnvelopeWithoutHold(t *testing.T) {
+	// without a hold the decay starts right at the peak
+	env := Envelope{Attack: 10 * time.Millisecond, Decay: 100 * time.Millisecond, Sustain: 0.5}
+	tests := []struct {
+		t, want float64
+	}{
+		{0.005, 0.5},
+		{0.010, 1},
+		{0.060, 0.75},
+		{0.110, 0.5},
+		{0.500, 0.5},
+	}
+
+	for _, tt := range tests {
+		if a := env.Amplitude(tt.t, 1); math.Abs(a-tt.want) > 1e-9 {
+			t.Errorf("%v at %gs, want %v", a, tt.t, tt.want)
+		}
+	}
+}
+
+func TestSustainIsALevel(t *testing.T) {
+	tests := []struct {
+		env  Envelope
+		held time.Duration
+	}{
+		{Envelope{Attack: 10 * time.Millisecond, Decay: 50 * time.Millisecond, Sustain: 0.6, Release: 100 * time.Millisecond}, time.Second},
+		{Envelope{Attack: 10 * time.Millisecond, Hold: 20 * time.Millisecond, Decay: 70 * time.Millisecond, Sustain: 0.25, Release: 10 * time.Millisecond}, 400 * time.Millisecond},
+		// a sustain of 1 or 0 is a level too, the note is held as long
+		{Envelope{Attack: 5 * time.Millisecond, Decay: 5 * time.Millisecond, Sustain: 1, Release: 50 * time.Millisecond}, 200 * time.Millisecond},
+		{Envelope{Attack: 5 * time.Millisecond, Decay: 95 * time.Millisecond, Sustain: 0, Release: 50 * time.Millisecond}, 200 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		held := tt.held.Seconds()
+		stages := tt.env.Stages().Seconds()
+
+		// the sustain holds at its level for what is left of the note after
+		// the other stages, however high the level
+		sustained := 0.0
+		const step = 1e-4
+		for tm := 0.0; tm < held+tt.env.Release.Seconds(); tm += step {
+			if math.Abs(tt.env.Amplitude(tm, held)-tt.env.Sustain) < 1e-9 && tm >= stages && tm < held {
+				sustained += step
+			}
+		}
+		if want := held - stages; math.Abs(sustained-want) > 2*step {
+			t.Errorf("%+v held %v: sustains for %.4fs, want %.4fs", tt.env, tt.held, sustained, want)
+		}
+
+		// it starts once the decay is over and the release follows from it
+		// when the note ends
+		if a := tt.env.Amplitude(stages-0.002, held); tt.env.Sustain < 1 && a <= tt.env.Sustain {
+			t.Errorf("%+v: %.4f before the decay is over, want it above the sustain", tt.env, a)
+		}
+		release := tt.env.Release.Seconds()
+		if a, want := tt.env.Amplitude(held+release/2, held), tt.env.Sustain/2; math.Abs(a-want) > 1e-9 {
+			t.Errorf("%+v: %.4f halfway through the release, want %.4f", tt.env, a, want)
+		}
+		if a := tt.env.Amplitude(held+release, held); a > 1e-9 {
+			t.Errorf("%+v: %.4f after the release, want 0", tt.env, a)
+		}
+	}
+}
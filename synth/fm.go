@@ -0,0 +1,79 @@
+package synth
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// maxOperators is how many operators an FM setup can have
+const maxOperators = 8
+
+// Operator is a sine modulating the phase of the carrier or of another
+// operator
+type Operator struct {
+	// Ratio is the operator frequency over the carrier frequency
+	Ratio float64
+	// Index is the peak phase deviation it causes, in radians, scaled by
+	// Envelope over the note
+	Index    float64
+	Envelope Envelope
+	// Into is the operator it modulates, counting from 1, 0 being the
+	// carrier. An operator can only modulate one listed before it
+	Into int
+}
+
+// FM is a set of operators phase modulating a carrier, from a simple
+// pair up to stacks and several modulators on one operator
+type FM []Operator
+
+// NewFM checks the routing of the operators
+func NewFM(operators ...Operator) (FM, error) {
+	if len(operators) > maxOperators {
+		return nil, fmt.Errorf("%d operators, at most %d are supported", len(operators), maxOperators)
+	}
+
+	for i, op := range operators {
+		if op.Into < 0 || op.Into > i {
+			return nil, fmt.Errorf("operator %d can't modulate operator %d, only the carrier or one listed before it", i+1, op.Into)
+		}
+	}
+	return FM(operators), nil
+}
+
+// Modulation is the phase offset of the carrier at phase, t seconds into
+// a note held for held seconds
+func (fm FM) Modulation(phase, t, held float64) float64 {
+	// every operator is done before the ones it modulates
+	var into [maxOperators + 1]float64
+	for i := len(fm) - 1; i >= 0; i-- {
+		op := fm[i]
+		into[op.Into] += op.Index * op.Envelope.Amplitude(t, held) * math.Sin(op.Ratio*phase+into[i+1])
+	}
+	return into[0]
+}
+
+// FMTone is a sine carrier playing one note through FM operators
+type FMTone struct {
+	Frequency float64
+
+	fm   FM
+	held float64
+	n    int
+}
+
+// NewFMTone returns an oscillator for a note at frequency Hz held for held,
+// which the operator envelopes need. Shape the carrier itself with the
+// envelope given to NewReader or Render
+func NewFMTone(fm FM, frequency float64, held time.Duration) *FMTone {
+	return &FMTone{Frequency: frequency, fm: fm, held: held.Seconds()}
+}
+
+// Next returns the next sample of the tone
+func (f *FMTone) Next() float64 {
+	t := float64(f.n) / SampleRate
+	f.n++
+	// the phase isn't wrapped, operators at fractional ratios would jump
+	phase := τ * f.Frequency * t
+	return math.Sin(phase + f.fm.Modulation(phase, t, f.held))
+}
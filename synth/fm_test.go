@@ -0,0 +1,94 @@
+package synth
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestNewFM(t *testing.T) {
+	tests := []struct {
+		operators []Operator
+		ok        bool
+	}{
+		{nil, true},
+		{[]Operator{{Ratio: 1, Index: 1}}, true},
+		// a stack and two modulators on one operator
+		{[]Operator{{Ratio: 1}, {Ratio: 2, Into: 1}, {Ratio: 3, Into: 1}}, true},
+		{[]Operator{{Ratio: 1}, {Ratio: 2, Into: 1}, {Ratio: 3, Into: 2}}, true},
+		// modulating itself, one listed later or one that doesn't exist
+		{[]Operator{{Ratio: 1, Into: 1}}, false},
+		{[]Operator{{Ratio: 1, Into: 2}, {Ratio: 2}}, false},
+		{[]Operator{{Ratio: 1, Into: -1}}, false},
+		{make([]Operator, maxOperators+1), false},
+	}
+
+	for _, tt := range tests {
+		if _, err := NewFM(tt.operators...); (err == nil) != tt.ok {
+			t.Errorf("NewFM(%+v): error %v, want ok %v", tt.operators, err, tt.ok)
+		}
+	}
+}
+
+func TestFMSidebands(t *testing.T) {
+	// a carrier at 1000 Hz and a modulator at 100 Hz put sidebands every
+	// 100 Hz, at the levels of the Bessel functions of the index
+	const carrier, ratio = 1000.0, 0.1
+	for _, index := range []float64{0, 0.5, 1, 2.4} {
+		fm, err := NewFM(Operator{Ratio: ratio, Index: index, Envelope: Envelope{Sustain: 1}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		s := samples(NewFMTone(fm, carrier, time.Second), SampleRate)
+		for k := -4; k <= 4; k++ {
+			got := math.Sqrt(2 * harmonicPower(s, carrier+float64(k)*carrier*ratio))
+			if want := math.Abs(math.Jn(k, index)); math.Abs(got-want) > 0.005 {
+				t.Errorf("index %g: sideband %+d at %.3f, want %.3f", index, k, got, want)
+			}
+		}
+	}
+}
+
+func TestFMModulation(t *testing.T) {
+	held := Envelope{Sustain: 1}
+	tests := []struct {
+		name      string
+		operators []Operator
+		want      func(phase float64) float64
+	}{
+		{
+			"pair",
+			[]Operator{{Ratio: 2, Index: 1.5, Envelope: held}},
+			func(phase float64) float64 { return 1.5 * math.Sin(2*phase) },
+		},
+		{
+			"stack",
+			[]Operator{{Ratio: 1, Index: 2, Envelope: held}, {Ratio: 3, Index: 0.5, Envelope: held, Into: 1}},
+			func(phase float64) float64 { return 2 * math.Sin(phase+0.5*math.Sin(3*phase)) },
+		},
+		{
+			"parallel",
+			[]Operator{{Ratio: 1, Index: 2, Envelope: held}, {Ratio: 3, Index: 0.5, Envelope: held}},
+			func(phase float64) float64 { return 2*math.Sin(phase) + 0.5*math.Sin(3*phase) },
+		},
+		{
+			// the index follows the operator envelope, gone once it decays
+			"decayed",
+			[]Operator{{Ratio: 1, Index: 2, Envelope: Envelope{Decay: 100 * time.Millisecond}}},
+			func(phase float64) float64 { return 0 },
+		},
+	}
+
+	for _, tt := range tests {
+		fm, err := NewFM(tt.operators...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, phase := range []float64{0, 0.3, 1, 2.5, 4, 6} {
+			if got, want := fm.Modulation(phase, 0.5, 1), tt.want(phase); math.Abs(got-want) > 1e-12 {
+				t.Errorf("%s: modulation at phase %g is %v, want %v", tt.name, phase, got, want)
+			}
+		}
+	}
+}
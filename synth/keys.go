@@ -0,0 +1,101 @@
+package synth
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+const (
+	// TotalKeys is the number of keys on a piano
+	TotalKeys = 88
+	// RefKey is the piano key number of A4
+	RefKey = 49
+	// RefFrequency is the pitch of RefKey
+	RefFrequency = 440.0
+)
+
+// semitones is the offset of each natural note from C
+var semitones = map[byte]int{'C': 0, 'D': 2, 'E': 4, 'F': 5, 'G': 7, 'A': 9, 'B': 11}
+
+// Semitone returns the offset from C of a natural note letter, A to G
+func Semitone(letter byte) (int, bool) {
+	semitone, ok := semitones[letter]
+	return semitone, ok
+}
+
+// KeyFrequency returns the frequency in Hz of the piano key (A4 = 49) in
+// equal temperament
+func KeyFrequency(key int) float64 {
+	return RefFrequency * math.Pow(2, float64(key-RefKey)/12)
+}
+
+// NearestKey returns the nearest piano key to the frequency. The result
+// is not clamped to the piano range
+func NearestKey(frequency float64) int {
+	return int(math.Round(FrequencyToKey(frequency)))
+}
+
+// FrequencyToKey is the exact, fractional key of the frequency, the
+// fraction times 100 being the cents above the key
+func FrequencyToKey(frequency float64) float64 {
+	return 12*math.Log2(frequency/RefFrequency) + RefKey
+}
+
+// ParseNote converts a note name like C4, F#3 or Bb5 to its piano key number
+func ParseNote(name string) (int, error) {
+	if len(name) < 2 {
+		return 0, fmt.Errorf("invalid note %q", name)
+	}
+
+	semitone, ok := semitones[name[0]]
+	if !ok {
+		return 0, fmt.Errorf("invalid note %q", name)
+	}
+
+	rest := name[1:]
+	switch rest[0] {
+	case '#':
+		semitone++
+		rest = rest[1:]
+	case 'b':
+		semitone--
+		rest = rest[1:]
+	}
+
+	octave, err := strconv.Atoi(rest)
+	if err != nil {
+		return 0, fmt.Errorf("invalid octave in note %q", name)
+	}
+
+	key := KeyFor(semitone, octave)
+	if key < 1 || key > TotalKeys {
+		return 0, fmt.Errorf("note %q is out of the piano range", name)
+	}
+
+	return key, nil
+}
+
+// KeyName returns the name of the piano key, e.g. C4 or F#3
+func KeyName(key int) string {
+	names := [12]string{"C", "C#", "D", "D#", "E", "F", "F#", "G", "G#", "A", "A#", "B"}
+	n := key + 8
+	return fmt.Sprintf("%s%d", names[n%12], n/12)
+}
+
+// KeyFor returns the piano key of the semitone (0 is C) in the octave.
+// C4 is key 40, A0 is key 1
+func KeyFor(semitone, octave int) int {
+	return octave*12 + semitone - 8
+}
+
+// ClampKey keeps key inside the piano range
+func ClampKey(key int) int {
+	if key < 1 {
+		return 1
+	}
+	if key > TotalKeys {
+		return TotalKeys
+	}
+	return key
+}
@@ -0,0 +1,44 @@
+package synth
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNearestKeyRoundTrip(t *testing.T) {
+	for key := 1; key <= TotalKeys; key++ {
+		if got := NearestKey(KeyFrequency(key)); got != key {
+			t.Errorf("NearestKey(KeyFrequency(%d)) = %d", key, got)
+		}
+	}
+}
+
+func TestNearestKey(t *testing.T) {
+	tests := []struct {
+		frequency float64
+		want      int
+	}{
+		{440, 49},
+		{27.5, 1},
+		{4186.01, 88},
+		{261.63, 40},
+		// a quarter tone up from A4 still rounds to it, a bit more goes to A#4
+		{440 * math.Pow(2, 0.49/12), 49},
+		{440 * math.Pow(2, 0.51/12), 50},
+		// the result isn't clamped to the piano
+		{8372, 100},
+	}
+
+	for _, tt := range tests {
+		if got := NearestKey(tt.frequency); got != tt.want {
+			t.Errorf("NearestKey(%g) = %d, want %d", tt.frequency, got, tt.want)
+		}
+	}
+}
+
+func TestFrequencyToKeyCents(t *testing.T) {
+	// 10 cents sharp of C4
+	if key := FrequencyToKey(KeyFrequency(40) * math.Pow(2, 10.0/1200)); math.Abs(key-40.1) > 1e-9 {
+		t.Errorf("FrequencyToKey = %v, want 40.1", key)
+	}
+}
@@ -0,0 +1,43 @@
+package synth
+
+// Mixer sums any number of sources into one, each at its own gain.
+// Sources are dropped as soon as they are over, and the mixer itself is
+// over once it has none left
+type Mixer struct {
+	voices []mixerVoice
+}
+
+// mixerVoice is a source of the mix and its gain
+type mixerVoice struct {
+	src  Source
+	gain float64
+}
+
+// Add starts playing src in the mix at gain, from the next sample on
+func (m *Mixer) Add(src Source, gain float64) {
+	m.voices = append(m.voices, mixerVoice{src: src, gain: gain})
+}
+
+// Voices is the number of sources still playing
+func (m *Mixer) Voices() int {
+	return len(m.voices)
+}
+
+// Next returns the sum of the next sample of every source. It is not
+// limited, keep the gains low enough for the voices playing together
+func (m *Mixer) Next() (float64, bool) {
+	sum := 0.0
+	playing := m.voices[:0]
+	for _, v := range m.voices {
+		if s, ok := v.src.Next(); ok {
+			sum += v.gain * s
+			playing = append(playing, v)
+		}
+	}
+	// clear the dropped tail so finished sources can be collected
+	for i := len(playing); i < len(m.voices); i++ {
+		m.voices[i] = mixerVoice{}
+	}
+	m.voices = playing
+	return sum, len(playing) > 0
+}
@@ -0,0 +1,103 @@
+package synth
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// constant is a source of n samples at value
+type constant struct {
+	value float64
+	n     int
+}
+
+func (c *constant) Next() (float64, bool) {
+	if c.n <= 0 {
+		return 0, false
+	}
+	c.n--
+	return c.value, true
+}
+
+// drain returns every sample of the source
+func drain(src Source) []float64 {
+	var out []float64
+	for {
+		s, ok := src.Next()
+		if !ok {
+			return out
+		}
+		out = append(out, s)
+	}
+}
+
+func TestMixer(t *testing.T) {
+	var m Mixer
+	m.Add(&constant{value: 1, n: 2}, 0.5)
+	m.Add(&constant{value: 1, n: 4}, 0.25)
+	m.Add(&constant{value: -1, n: 3}, 1)
+
+	// each voice at its gain, dropped once it reports it is over
+	tests := []struct {
+		sample float64
+		voices int
+	}{
+		{-0.25, 3},
+		{-0.25, 3},
+		{-0.75, 2},
+		{0.25, 1},
+	}
+	for i, tt := range tests {
+		sample, ok := m.Next()
+		if !ok || sample != tt.sample {
+			t.Errorf("sample %d is %v, %v, want %v", i, sample, ok, tt.sample)
+		}
+		if got := m.Voices(); got != tt.voices {
+			t.Errorf("after sample %d: %d voices, want %d", i, got, tt.voices)
+		}
+	}
+
+	if _, ok := m.Next(); ok || m.Voices() != 0 {
+		t.Errorf("the mix still plays with %d voices, every source is over", m.Voices())
+	}
+
+	// a voice added later starts on the next sample
+	m.Add(&constant{value: 1, n: 1}, 2)
+	if sample, ok := m.Next(); !ok || sample != 2 {
+		t.Errorf("the new voice plays %v, %v, want 2", sample, ok)
+	}
+}
+
+func TestMixerMatchesTheVoices(t *testing.T) {
+	// notes summed as they are read are the notes rendered and added up,
+	// and the mix ends with the longest one
+	env := Envelope{Attack: 5 * time.Millisecond, Sustain: 0.8, Release: 50 * time.Millisecond}
+	notes := []struct {
+		frequency, gain float64
+		held            time.Duration
+	}{
+		{261.63, 0.3, 100 * time.Millisecond},
+		{329.63, 0.3, 250 * time.Millisecond},
+		{392, 0.2, 50 * time.Millisecond},
+	}
+
+	var m Mixer
+	want := make([]float64, NewVoice(NewSine(0), env, 250*time.Millisecond).Len())
+	for _, n := range notes {
+		m.Add(NewVoice(NewSine(n.frequency), env, n.held), n.gain)
+		for i, s := range Render(NewSine(n.frequency), env, n.held) {
+			want[i] += n.gain * s
+		}
+	}
+
+	got := drain(&m)
+	if len(got) != len(want) {
+		t.Fatalf("the mix lasts %d samples, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if math.Abs(got[i]-want[i]) > 1e-12 {
+			t.Fatalf("sample %d is %v, the notes add up to %v", i, got[i], want[i])
+		}
+	}
+}
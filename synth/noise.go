@@ -0,0 +1,84 @@
+package synth
+
+import "math"
+
+// pinkRows is how many octave bands pink noise sums, covering down to
+// SampleRate/2^pinkRows
+const pinkRows = 12
+
+// noiseAt is white noise in [-1, 1) at sample n, a hash of n so any sample
+// can be computed on its own in any order
+func noiseAt(n uint64) float64 {
+	// splitmix64
+	n += 0x9E3779B97F4A7C15
+	n = (n ^ n>>30) * 0xBF58476D1CE4E5B9
+	n = (n ^ n>>27) * 0x94D049BB133111EB
+	n ^= n >> 31
+	return float64(n>>11)/(1<<52) - 1
+}
+
+// pinkAt is pink noise at sample n, falling 3dB per octave. It sums
+// pinkRows white noises, row k holding each value for 2^k samples
+// (Voss-McCartney)
+func pinkAt(n uint64) float64 {
+	sum := 0.0
+	for k := uint64(0); k < pinkRows; k++ {
+		sum += noiseAt(n>>k ^ k<<56)
+	}
+	return sum / pinkRows
+}
+
+// sampleIndex recovers the sample number from a phase moving dt periods
+// per sample, so noise can be used as a Shape
+func sampleIndex(phase, dt float64) uint64 {
+	if dt <= 0 {
+		return 0
+	}
+	return uint64(math.Round(math.Abs(phase) / (τ * dt)))
+}
+
+// white is white noise as a Shape, ignoring the pitch
+func white(phase, dt float64) float64 {
+	return noiseAt(sampleIndex(phase, dt))
+}
+
+// pink is pink noise as a Shape, ignoring the pitch
+func pink(phase, dt float64) float64 {
+	return pinkAt(sampleIndex(phase, dt))
+}
+
+// White is a white noise source, every frequency at the same level, for
+// hiss and hi-hats
+type White struct {
+	n uint64
+}
+
+// NewWhite returns a white noise source, the same seed always giving the
+// same noise
+func NewWhite(seed uint64) *White {
+	return &White{n: seed << 32}
+}
+
+// Next returns the next sample of noise
+func (w *White) Next() float64 {
+	w.n++
+	return noiseAt(w.n)
+}
+
+// Pink is a pink noise source, equal energy per octave, for rumble, wind
+// and softer drums
+type Pink struct {
+	n uint64
+}
+
+// NewPink returns a pink noise source, the same seed always giving the
+// same noise
+func NewPink(seed uint64) *Pink {
+	return &Pink{n: seed << 32}
+}
+
+// Next returns the next sample of noise
+func (p *Pink) Next() float64 {
+	p.n++
+	return pinkAt(p.n)
+}
@@ -0,0 +1,85 @@
+package synth
+
+import (
+	"math"
+	"testing"
+)
+
+// slope is the spectral slope of the noise in dB per octave per Hz,
+// measured over six octaves from 86 Hz
+func slope(osc Oscillator) float64 {
+	const n = 4096
+	var low, high float64
+	for segment := 0; segment < 8; segment++ {
+		s := samples(osc, n)
+		for k := 8; k < 16; k++ {
+			low += harmonicPower(s, float64(k)*SampleRate/n)
+		}
+		for k := 8 << 6; k < 16<<6; k++ {
+			high += harmonicPower(s, float64(k)*SampleRate/n)
+		}
+	}
+	// the high octave has 64 times the bins of the low one
+	return 10 * math.Log10(high/low/64) / 6
+}
+
+func TestNoise(t *testing.T) {
+	tests := []struct {
+		name     string
+		new      func(seed uint64) Oscillator
+		slope    float64
+		variance float64
+	}{
+		{"white", func(seed uint64) Oscillator { return NewWhite(seed) }, 0, 1.0 / 3},
+		{"pink", func(seed uint64) Oscillator { return NewPink(seed) }, -3, 0.0278},
+	}
+
+	for _, tt := range tests {
+		s := samples(tt.new(1), SampleRate)
+		mean, variance := 0.0, 0.0
+		for i, v := range s {
+			if v < -1 || v >= 1 {
+				t.Fatalf("%s: sample %d is %v, out of [-1, 1)", tt.name, i, v)
+			}
+			mean += v
+			variance += v * v
+		}
+		mean /= SampleRate
+		variance = variance/SampleRate - mean*mean
+		if math.Abs(mean) > 0.02 || math.Abs(variance-tt.variance) > tt.variance/10 {
+			t.Errorf("%s: mean %.4f and variance %.4f, want 0 and %.4f", tt.name, mean, variance, tt.variance)
+		}
+
+		if got := slope(tt.new(1)); math.Abs(got-tt.slope) > 0.75 {
+			t.Errorf("%s: falls %.2f dB per octave, want %g", tt.name, got, tt.slope)
+		}
+
+		// the seed picks the noise
+		same, other := samples(tt.new(1), 100), samples(tt.new(2), 100)
+		differ := false
+		for i := range same {
+			if same[i] != s[i] {
+				t.Fatalf("%s: sample %d differs with the same seed", tt.name, i)
+			}
+			differ = differ || other[i] != same[i]
+		}
+		if !differ {
+			t.Errorf("%s: seeds 1 and 2 give the same noise", tt.name)
+		}
+	}
+}
+
+func TestNoiseShapes(t *testing.T) {
+	// as a Shape the noise follows the sample number, whatever the pitch
+	for name, at := range map[string]func(uint64) float64{"white": noiseAt, "pink": pinkAt} {
+		shape := Shapes[name]
+		for _, frequency := range []float64{27.5, 440, 4186} {
+			dt := frequency / SampleRate
+			for n := uint64(0); n < 1000; n++ {
+				if got, want := shape(float64(n)*τ*dt, dt), at(n); got != want {
+					t.Fatalf("%s at %g Hz: sample %d is %v, want %v", name, frequency, n, got, want)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package synth
+
+import "math"
+
+// Oscillator produces a periodic waveform one sample at a time
+type Oscillator interface {
+	// Next returns the next sample, in [-1, 1]
+	Next() float64
+}
+
+// Shape is one period of a waveform as a function of the phase in
+// radians. dt is how far the phase moves per sample, in periods, which
+// band limited shapes need to smooth their jumps
+type Shape func(phase, dt float64) float64
+
+// Shapes are the waveforms by name
+var Shapes = map[string]Shape{
+	"sine":     sine,
+	"square":   square,
+	"triangle": triangle,
+	"saw":      saw,
+	"white":    white,
+	"pink":     pink,
+}
+
+// sine is the pure tone
+func sine(phase, dt float64) float64 {
+	return math.Sin(phase)
+}
+
+// square is 1 over the first half of the period and -1 over the second
+func square(phase, dt float64) float64 {
+	if p := math.Mod(phase, τ); p >= 0 && p < math.Pi || p < -math.Pi {
+		return 1
+	}
+	return -1
+}
+
+// triangle ramps from 0 up to 1 at a quarter of the period, down to -1 at
+// three quarters and back to 0, in phase with the sine
+func triangle(phase, dt float64) float64 {
+	p := cycle(phase)
+	return 4*math.Abs(math.Mod(p+0.75, 1)-0.5) - 1
+}
+
+// saw rises from 0 to 1 over the first half of the period, drops to -1 and
+// rises back to 0, in phase with the sine. The drop is smoothed with
+// PolyBLEP, a polynomial step spread over the samples next to it, so high
+// notes don't fold their overtones back below Nyquist
+func saw(phase, dt float64) float64 {
+	p := math.Mod(cycle(phase)+0.5, 1)
+	return 2*p - 1 - polyBLEP(p, dt)
+}
+
+// polyBLEP is the correction to subtract from a naive wave stepping down
+// by 2 where its period fraction p wraps, dt being the step per sample
+func polyBLEP(p, dt float64) float64 {
+	switch {
+	case dt <= 0:
+		return 0
+	case p < dt:
+		x := p / dt
+		return x + x - x*x - 1
+	case p > 1-dt:
+		x := (p - 1) / dt
+		return x*x + x + x + 1
+	}
+	return 0
+}
+
+// cycle is the fraction of the period at phase, in [0, 1)
+func cycle(phase float64) float64 {
+	p := math.Mod(phase/τ, 1)
+	if p < 0 {
+		p++
+	}
+	return p
+}
+
+// phasor is the running phase of an oscillator, kept in [0, τ)
+type phasor struct {
+	Frequency float64
+
+	phase float64
+}
+
+// dt is the phase step per sample, in periods
+func (p *phasor) dt() float64 {
+	return p.Frequency / SampleRate
+}
+
+// advance returns the current phase and moves to the next sample
+func (p *phasor) advance() float64 {
+	phase := p.phase
+	p.phase = math.Mod(p.phase+τ*p.Frequency/SampleRate, τ)
+	return phase
+}
+
+// Sine is a pure tone
+type Sine struct {
+	phasor
+}
+
+// NewSine returns a sine oscillator at frequency Hz, starting at phase 0
+func NewSine(frequency float64) *Sine {
+	return &Sine{phasor{Frequency: frequency}}
+}
+
+// Next returns the next sample of the sine
+func (s *Sine) Next() float64 {
+	return sine(s.advance(), 0)
+}
+
+// Square is a square wave, the hollow buzz of old game consoles. It is
+// not band limited, so high notes alias
+type Square struct {
+	phasor
+}
+
+// NewSquare returns a square oscillator at frequency Hz, starting high
+func NewSquare(frequency float64) *Square {
+	return &Square{phasor{Frequency: frequency}}
+}
+
+// Next returns the next sample of the square
+func (s *Square) Next() float64 {
+	return square(s.advance(), 0)
+}
+
+// Triangle is a triangle wave, softer than the square as its overtones
+// fall off twice as fast
+type Triangle struct {
+	phasor
+}
+
+// NewTriangle returns a triangle oscillator at frequency Hz, starting at 0
+// on the way up
+func NewTriangle(frequency float64) *Triangle {
+	return &Triangle{phasor{Frequency: frequency}}
+}
+
+// Next returns the next sample of the triangle
+func (t *Triangle) Next() float64 {
+	return triangle(t.advance(), 0)
+}
+
+// Saw is a band limited sawtooth, the brightest of the basic waves
+type Saw struct {
+	phasor
+}
+
+// NewSaw returns a sawtooth oscillator at frequency Hz, starting at 0 on
+// the way up
+func NewSaw(frequency float64) *Saw {
+	return &Saw{phasor{Frequency: frequency}}
+}
+
+// Next returns the next sample of the sawtooth
+func (s *Saw) Next() float64 {
+	return saw(s.advance(), s.dt())
+}
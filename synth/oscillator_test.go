@@ -0,0 +1,180 @@
+package synth
+
+import (
+	"math"
+	"testing"
+)
+
+// samples returns the first n samples of osc
+func samples(osc Oscillator, n int) []float64 {
+	out := make([]float64, n)
+	for i := range out {
+		out[i] = osc.Next()
+	}
+	return out
+}
+
+// crossings counts the times the samples go from below 0 to 0 or above
+func crossings(s []float64) int {
+	n := 0
+	for i := 1; i < len(s); i++ {
+		if s[i-1] < 0 && s[i] >= 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func TestSquare(t *testing.T) {
+	tests := []struct {
+		frequency float64
+		periods   int
+	}{
+		{100, 100},
+		{441, 441},
+		{1000, 1000},
+	}
+
+	for _, tt := range tests {
+		s := samples(NewSquare(tt.frequency), SampleRate)
+
+		high := 0
+		for i, v := range s {
+			if v != 1 && v != -1 {
+				t.Fatalf("%g Hz: sample %d is %v, want ±1", tt.frequency, i, v)
+			}
+			if v == 1 {
+				high++
+			}
+		}
+		if s[0] != 1 {
+			t.Errorf("%g Hz: starts at %v, want high", tt.frequency, s[0])
+		}
+		// half the time up, half down
+		if duty := float64(high) / SampleRate; math.Abs(duty-0.5) > 0.01 {
+			t.Errorf("%g Hz: high %.3f of the time, want 0.5", tt.frequency, duty)
+		}
+		if n := crossings(s); n < tt.periods-1 || n > tt.periods {
+			t.Errorf("%g Hz: %d periods in a second, want %d", tt.frequency, n, tt.periods)
+		}
+	}
+}
+
+func TestTriangle(t *testing.T) {
+	// in phase with the sine, rising linearly through its quarters
+	tests := []struct {
+		cycle float64
+		want  float64
+	}{
+		{0, 0},
+		{0.125, 0.5},
+		{0.25, 1},
+		{0.375, 0.5},
+		{0.5, 0},
+		{0.75, -1},
+		{0.875, -0.5},
+		{1, 0},
+		{-0.25, -1},
+	}
+
+	for _, tt := range tests {
+		if got := triangle(tt.cycle*τ, 0); math.Abs(got-tt.want) > 1e-12 {
+			t.Errorf("triangle at %g of the period = %v, want %v", tt.cycle, got, tt.want)
+		}
+	}
+
+	// 441 Hz is exactly 100 samples a period
+	s := samples(NewTriangle(441), SampleRate)
+	if n := crossings(s); n < 440 || n > 441 {
+		t.Errorf("%d periods in a second, want 441", n)
+	}
+	for i := 1; i < len(s); i++ {
+		if step := math.Abs(s[i] - s[i-1]); step > 0.04+1e-9 {
+			t.Fatalf("sample %d jumps by %v, want at most 0.04", i, step)
+		}
+	}
+}
+
+// harmonicPower is the power of the samples at frequency, over a whole
+// number of its periods
+func harmonicPower(s []float64, frequency float64) float64 {
+	re, im := 0.0, 0.0
+	for i, v := range s {
+		a := τ * frequency * float64(i) / SampleRate
+		re += v * math.Cos(a)
+		im += v * math.Sin(a)
+	}
+	return 2 * (re*re + im*im) / float64(len(s)*len(s))
+}
+
+// aliased is the fraction of the power of a second of shape at frequency
+// that isn't on its harmonics below Nyquist, with or without the phase
+// step band limited shapes smooth their jumps with
+func aliased(shape Shape, frequency float64, limited bool) float64 {
+	p := phasor{Frequency: frequency}
+	s := make([]float64, SampleRate)
+	total := 0.0
+	for i := range s {
+		dt := 0.0
+		if limited {
+			dt = p.dt()
+		}
+		s[i] = shape(p.advance(), dt)
+		total += s[i] * s[i]
+	}
+	total /= SampleRate
+
+	harmonics := 0.0
+	for k := 1.0; k*frequency < SampleRate/2; k++ {
+		harmonics += harmonicPower(s, k*frequency)
+	}
+	return 1 - harmonics/total
+}
+
+func TestSawAliasing(t *testing.T) {
+	tests := []struct {
+		frequency float64
+		max       float64
+	}{
+		{1000, 0.002},
+		{4000, 0.01},
+		{8000, 0.03},
+	}
+
+	for _, tt := range tests {
+		naive, limited := aliased(saw, tt.frequency, false), aliased(saw, tt.frequency, true)
+		if limited > tt.max || limited > naive/10 {
+			t.Errorf("%g Hz: %.4f of the power aliased, %.4f without PolyBLEP, want at most %g", tt.frequency, limited, naive, tt.max)
+		}
+	}
+}
+
+func TestPolyBLEP(t *testing.T) {
+	tests := []struct {
+		p, dt float64
+		want  float64
+	}{
+		// the step is split between the samples on each side of the wrap
+		{0, 0.01, -1},
+		{0.005, 0.01, -0.25},
+		{0.995, 0.01, 0.25},
+		{0.9999999, 0.01, 1},
+		// away from it the wave is left alone
+		{0.01, 0.01, 0},
+		{0.5, 0.01, 0},
+		{0.99, 0.01, 0},
+		{0, 0, 0},
+	}
+
+	for _, tt := range tests {
+		if got := polyBLEP(tt.p, tt.dt); math.Abs(got-tt.want) > 1e-4 {
+			t.Errorf("polyBLEP(%g, %g) = %v, want %v", tt.p, tt.dt, got, tt.want)
+		}
+	}
+
+	// the saw stays continuous across the wrap, in phase with the sine
+	s := samples(NewSaw(441), 200)
+	if s[0] != 0 || math.Abs(s[25]-0.5) > 1e-9 {
+		t.Errorf("saw starts %v and is %v a quarter period in, want 0 and 0.5", s[0], s[25])
+	}
+}
@@ -0,0 +1,68 @@
+package synth
+
+import "math"
+
+// Pluck is a Karplus-Strong plucked string: a burst of noise circulating
+// in a delay line one period long, low passed on every trip so it rings
+// at the pitch and mellows as it dies out
+type Pluck struct {
+	line []float64
+	pos  int
+	// gain is what is left of the string after each trip
+	gain float64
+	// c, in and out are the allpass tuning the fractional part of the
+	// period
+	c, in, out float64
+}
+
+// NewPluck returns a string plucked at frequency Hz. gain, from 0 to 1, is
+// how much of its level the string keeps every period: 0.996 rings like a
+// guitar, 0.999 like a harp. The same seed always gives the same pluck
+func NewPluck(frequency, gain float64, seed uint64) *Pluck {
+	// averaging with the next sample of the line shortens the loop by half
+	// a sample
+	period := SampleRate/frequency + 0.5
+	length := int(period)
+	if length < 2 {
+		length = 2
+	}
+	frac := period - float64(length)
+	if frac < 0.1 {
+		// keep the allpass away from its unstable end, a sample shorter
+		length--
+		frac++
+	}
+
+	// allpass coefficient delaying frac samples exactly at the pitch
+	w := τ * frequency / SampleRate
+	p := &Pluck{
+		line: make([]float64, length),
+		gain: gain,
+		c:    math.Sin((1-frac)*w/2) / math.Sin((1+frac)*w/2),
+	}
+	mean := 0.0
+	for i := range p.line {
+		p.line[i] = noiseAt(seed<<32 + uint64(i))
+		mean += p.line[i] / float64(length)
+	}
+	// the loop passes DC through, a burst off center would leave the string
+	// off center
+	for i := range p.line {
+		p.line[i] -= mean
+	}
+	return p
+}
+
+// Next returns the next sample of the string
+func (p *Pluck) Next() float64 {
+	out := p.line[p.pos]
+	next := p.line[(p.pos+1)%len(p.line)]
+	avg := p.gain * 0.5 * (out + next)
+
+	y := p.c*avg + p.in - p.c*p.out
+	p.in, p.out = avg, y
+	p.line[p.pos] = y
+	p.pos = (p.pos + 1) % len(p.line)
+
+	return math.Max(-1, math.Min(1, out))
+}
@@ -0,0 +1,77 @@
+package synth
+
+import (
+	"math"
+	"testing"
+)
+
+// peakFrequency is the frequency with the most power in the samples, within
+// 20 cents of guess, to a quarter of a cent
+func peakFrequency(s []float64, guess float64) float64 {
+	best, peak := 0.0, 0.0
+	for cents := -20.0; cents <= 20; cents += 0.25 {
+		frequency := guess * math.Pow(2, cents/1200)
+		if p := harmonicPower(s, frequency); p > best {
+			best, peak = p, frequency
+		}
+	}
+	return peak
+}
+
+func TestPluckTuning(t *testing.T) {
+	// the allpass tunes the fraction of a sample the delay line can't, so
+	// the string plays in tune across the piano
+	for _, key := range []int{1, 16, 28, 40, 49, 64, 76, 82, 88} {
+		frequency := KeyFrequency(key)
+		// 100 periods, after the first 2 where the burst is still noise
+		n := int(100 * SampleRate / frequency)
+		s := samples(NewPluck(frequency, 0.996, 1), n+int(2*SampleRate/frequency))
+
+		got := peakFrequency(s[len(s)-n:], frequency)
+		if cents := 1200 * math.Log2(got/frequency); math.Abs(cents) > 1 {
+			t.Errorf("key %d: plays at %.2f Hz, %+.2f cents off %.2f Hz", key, got, cents, frequency)
+		}
+	}
+}
+
+func TestPluckDecay(t *testing.T) {
+	// every trip the fundamental keeps gain times what the averaging
+	// passes at its frequency. 0.1s is exactly 22 periods at 220 Hz
+	const frequency = 220.0
+	for _, gain := range []float64{0.99, 0.996, 0.999} {
+		s := samples(NewPluck(frequency, gain, 1), 2*SampleRate)
+		level := func(at float64) float64 {
+			from := int(at * SampleRate)
+			return math.Sqrt(2 * harmonicPower(s[from:from+4410], frequency))
+		}
+
+		kept := level(1.2) / level(0.2)
+		if want := math.Pow(gain*math.Cos(math.Pi*frequency/SampleRate), frequency); math.Abs(kept-want) > want*0.02 {
+			t.Errorf("gain %g: the fundamental keeps %.4f of its level over a second, want %.4f", gain, kept, want)
+		}
+
+		// the burst is centered and the loop passes DC, so the string
+		// stays centered
+		mean := 0.0
+		for _, v := range s {
+			mean += v / float64(len(s))
+		}
+		if math.Abs(mean) > 5e-3 {
+			t.Errorf("gain %g: mean %.4f, want 0", gain, mean)
+		}
+	}
+}
+
+func TestPluckSeed(t *testing.T) {
+	a, b, c := samples(NewPluck(440, 0.996, 7), 1000), samples(NewPluck(440, 0.996, 7), 1000), samples(NewPluck(440, 0.996, 8), 1000)
+	differ := false
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("sample %d differs with the same seed", i)
+		}
+		differ = differ || a[i] != c[i]
+	}
+	if !differ {
+		t.Error("seeds 7 and 8 give the same pluck")
+	}
+}
@@ -0,0 +1,106 @@
+package synth
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"time"
+)
+
+// Source is a stream of samples that ends, like a note or a mix of notes
+type Source interface {
+	// Next returns the next sample, ok being false once the source is
+	// over
+	Next() (sample float64, ok bool)
+}
+
+// Voice is a note played by an oscillator for some time and shaped by an
+// envelope, including its release tail
+type Voice struct {
+	osc   Oscillator
+	env   Envelope
+	held  float64
+	n     int
+	total int
+}
+
+// NewVoice returns the note played by osc for held and shaped by env
+func NewVoice(osc Oscillator, env Envelope, held time.Duration) *Voice {
+	return &Voice{
+		osc:   osc,
+		env:   env,
+		held:  held.Seconds(),
+		total: int((held + env.Release).Seconds() * SampleRate),
+	}
+}
+
+// Len is the number of samples of the voice, release included
+func (v *Voice) Len() int {
+	return v.total
+}
+
+// Next returns the next sample of the note
+func (v *Voice) Next() (float64, bool) {
+	if v.n >= v.total {
+		return 0, false
+	}
+
+	t := float64(v.n) / SampleRate
+	v.n++
+	return v.env.Amplitude(t, v.held) * v.osc.Next(), true
+}
+
+// Render returns the mono samples of a note played by osc for held and
+// shaped by env, including the release tail
+func Render(osc Oscillator, env Envelope, held time.Duration) []float64 {
+	v := NewVoice(osc, env, held)
+	out := make([]float64, v.Len())
+	for i := range out {
+		out[i], _ = v.Next()
+	}
+	return out
+}
+
+// Reader streams a source as mono little endian 32-bit float samples at
+// SampleRate, generating them as they are read
+type Reader struct {
+	src Source
+	// pending is what is left of a sample split across two reads
+	pending []byte
+	buf     [4]byte
+}
+
+// NewReader returns a reader of the note played by osc for held and
+// shaped by env, including the release tail
+func NewReader(osc Oscillator, env Envelope, held time.Duration) *Reader {
+	return NewSourceReader(NewVoice(osc, env, held))
+}
+
+// NewSourceReader returns a reader of any source, like a Mixer
+func NewSourceReader(src Source) *Reader {
+	return &Reader{src: src}
+}
+
+// Read fills p with samples, returning io.EOF once the source is over
+func (r *Reader) Read(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		if len(r.pending) == 0 {
+			sample, ok := r.src.Next()
+			if !ok {
+				break
+			}
+			binary.LittleEndian.PutUint32(r.buf[:], math.Float32bits(float32(sample)))
+			r.pending = r.buf[:]
+		}
+
+		n := copy(p[written:], r.pending)
+		r.pending = r.pending[n:]
+		written += n
+	}
+
+	if written == 0 && len(p) > 0 {
+		return 0, io.EOF
+	}
+	return written, nil
+}
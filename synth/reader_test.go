@@ -0,0 +1,71 @@
+package synth
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestRenderLength(t *testing.T) {
+	tests := []struct {
+		held    time.Duration
+		release time.Duration
+		want    int
+	}{
+		{time.Second, 0, 44100},
+		{500 * time.Millisecond, 100 * time.Millisecond, 26460},
+		{0, 50 * time.Millisecond, 2205},
+		{0, 0, 0},
+	}
+
+	for _, tt := range tests {
+		env := Envelope{Sustain: 1, Release: tt.release}
+		if got := len(Render(NewSine(440), env, tt.held)); got != tt.want {
+			t.Errorf("%v held, %v release: %d samples, want %d", tt.held, tt.release, got, tt.want)
+		}
+		if got := NewVoice(NewSine(440), env, tt.held).Len(); got != tt.want {
+			t.Errorf("%v held, %v release: voice of %d samples, want %d", tt.held, tt.release, got, tt.want)
+		}
+	}
+}
+
+func TestReaderMatchesRender(t *testing.T) {
+	env := Envelope{Attack: 10 * time.Millisecond, Decay: 50 * time.Millisecond, Sustain: 0.6, Release: 80 * time.Millisecond}
+	want := Render(NewSine(261.63), env, 200*time.Millisecond)
+
+	// reads of any size, splitting samples across them, give the same
+	// stream of float32 samples
+	for _, size := range []int{1, 3, 4, 7, 4096, 1 << 20} {
+		r := NewReader(NewSine(261.63), env, 200*time.Millisecond)
+		var data []byte
+		buf := make([]byte, size)
+		for {
+			n, err := r.Read(buf)
+			data = append(data, buf[:n]...)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if len(data) != 4*len(want) {
+			t.Errorf("reads of %d: %d bytes, want %d", size, len(data), 4*len(want))
+			continue
+		}
+		for i, w := range want {
+			got := math.Float32frombits(binary.LittleEndian.Uint32(data[4*i:]))
+			if got != float32(w) {
+				t.Errorf("reads of %d: sample %d is %v, want %v", size, i, got, float32(w))
+				break
+			}
+		}
+
+		if n, err := r.Read(buf); n != 0 || err != io.EOF {
+			t.Errorf("reads of %d: reading past the end gave %d, %v", size, n, err)
+		}
+	}
+}
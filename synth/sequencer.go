@@ -0,0 +1,53 @@
+package synth
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Sequencer starts sources at exact sample positions, so timing only
+// depends on the samples played and never on when they are read
+type Sequencer struct {
+	events []event
+	next   int
+	pos    int
+	mix    Mixer
+}
+
+// event is a source waiting for its start sample
+type event struct {
+	at   int
+	src  Source
+	gain float64
+}
+
+// At schedules src to start at gain at time at from the first sample of
+// the sequence. A time already played starts it right away
+func (s *Sequencer) At(at time.Duration, src Source, gain float64) {
+	e := event{at: int(math.Round(at.Seconds() * SampleRate)), src: src, gain: gain}
+
+	// keep the pending events sorted, same time ones in the order added
+	i := s.next + sort.Search(len(s.events)-s.next, func(i int) bool { return s.events[s.next+i].at > e.at })
+	s.events = append(s.events, event{})
+	copy(s.events[i+1:], s.events[i:])
+	s.events[i] = e
+}
+
+// Next returns the next sample of the sequence, silence between sources,
+// and is over once every source has started and ended
+func (s *Sequencer) Next() (float64, bool) {
+	for s.next < len(s.events) && s.events[s.next].at <= s.pos {
+		e := s.events[s.next]
+		s.events[s.next] = event{}
+		s.mix.Add(e.src, e.gain)
+		s.next++
+	}
+	s.pos++
+
+	sample, ok := s.mix.Next()
+	if !ok && s.next < len(s.events) {
+		return 0, true
+	}
+	return sample, ok
+}
@@ -0,0 +1,88 @@
+package synth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSequencer(t *testing.T) {
+	type note struct {
+		at    time.Duration
+		n     int
+		value float64
+	}
+	tests := []struct {
+		name  string
+		notes []note
+		want  []float64
+	}{
+		{
+			"back to back",
+			[]note{{0, 2, 1}, {2 * time.Second / SampleRate, 2, 2}},
+			[]float64{1, 1, 2, 2},
+		},
+		{
+			// silence between the notes, the order added doesn't matter
+			"gap",
+			[]note{{5 * time.Second / SampleRate, 1, 2}, {time.Second / SampleRate, 2, 1}},
+			[]float64{0, 1, 1, 0, 0, 2},
+		},
+		{
+			"overlap",
+			[]note{{0, 3, 1}, {time.Second / SampleRate, 3, 10}},
+			[]float64{1, 11, 11, 10},
+		},
+		{
+			// the time rounds to the nearest sample
+			"rounding",
+			[]note{{1400 * time.Microsecond / 63, 1, 1}, {3600 * time.Microsecond / 63, 1, 2}},
+			[]float64{0, 1, 0, 2},
+		},
+		{
+			"nothing",
+			nil,
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		var s Sequencer
+		for _, n := range tt.notes {
+			s.At(n.at, &constant{value: n.value, n: n.n}, 1)
+		}
+		got := drain(&s)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: played %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for i := range tt.want {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: played %v, want %v", tt.name, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestSequencerLateEvents(t *testing.T) {
+	var s Sequencer
+	s.At(0, &constant{value: 1, n: 3}, 1)
+	s.At(10*time.Second/SampleRate, &constant{value: 3, n: 1}, 1)
+	s.Next()
+	s.Next()
+
+	// scheduled while playing: one already due starts right away, at its
+	// gain, and one later waits for its sample
+	s.At(0, &constant{value: 1, n: 1}, 0.5)
+	s.At(4*time.Second/SampleRate, &constant{value: 2, n: 1}, 1)
+	want := []float64{1.5, 0, 2, 0, 0, 0, 0, 0, 3}
+	got := drain(&s)
+	if len(got) != len(want) {
+		t.Fatalf("played %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("played %v, want %v", got, want)
+		}
+	}
+}
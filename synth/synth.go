@@ -0,0 +1,12 @@
+// Package synth generates tones: oscillators shaped by ADSR envelopes,
+// tuned by piano key, rendered to samples or streamed through an
+// io.Reader. It is the sound engine of the SoundOfCode command
+package synth
+
+import "math"
+
+// SampleRate is the number of samples per second of everything rendered
+const SampleRate = 44100
+
+// τ is a full turn in radians
+const τ = 2 * math.Pi
@@ -0,0 +1,58 @@
+package synth
+
+import (
+	"errors"
+	"math"
+)
+
+// Wavetable is one cycle of a waveform, looped at any pitch
+type Wavetable []float64
+
+// NewWavetable returns the cycle scaled to a peak of 1. It needs at least
+// two samples that aren't all silent
+func NewWavetable(cycle []float64) (Wavetable, error) {
+	if len(cycle) < 2 {
+		return nil, errors.New("a wavetable needs at least 2 samples")
+	}
+
+	peak := 0.0
+	for _, s := range cycle {
+		peak = math.Max(peak, math.Abs(s))
+	}
+	if peak == 0 {
+		return nil, errors.New("the wavetable is silent")
+	}
+
+	table := make(Wavetable, len(cycle))
+	for i, s := range cycle {
+		table[i] = s / peak
+	}
+	return table, nil
+}
+
+// Shape reads the table at phase, interpolating linearly between samples
+// and wrapping from the last sample back to the first
+func (t Wavetable) Shape(phase, dt float64) float64 {
+	x := cycle(phase) * float64(len(t))
+	i := int(x)
+	frac := x - float64(i)
+	return t[i%len(t)]*(1-frac) + t[(i+1)%len(t)]*frac
+}
+
+// Table is an oscillator looping a wavetable. It is not band limited, so
+// tables with sharp edges alias on high notes
+type Table struct {
+	phasor
+	table Wavetable
+}
+
+// NewTable returns an oscillator playing the table at frequency Hz, from
+// its first sample
+func NewTable(table Wavetable, frequency float64) *Table {
+	return &Table{phasor: phasor{Frequency: frequency}, table: table}
+}
+
+// Next returns the next sample of the table
+func (t *Table) Next() float64 {
+	return t.table.Shape(t.advance(), t.dt())
+}
@@ -0,0 +1,81 @@
+package synth
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNewWavetable(t *testing.T) {
+	tests := []struct {
+		cycle []float64
+		want  Wavetable
+	}{
+		{[]float64{0, 0.5, 0, -0.25}, Wavetable{0, 1, 0, -0.5}},
+		{[]float64{-2, 1}, Wavetable{-1, 0.5}},
+		{[]float64{1, -1, 1, -1}, Wavetable{1, -1, 1, -1}},
+	}
+
+	for _, tt := range tests {
+		got, err := NewWavetable(tt.cycle)
+		if err != nil {
+			t.Errorf("NewWavetable(%v): %v", tt.cycle, err)
+			continue
+		}
+		for i := range tt.want {
+			if got[i] != tt.want[i] {
+				t.Errorf("NewWavetable(%v) = %v, want %v", tt.cycle, got, tt.want)
+				break
+			}
+		}
+	}
+
+	for _, cycle := range [][]float64{nil, {1}, {0, 0, 0}} {
+		if _, err := NewWavetable(cycle); err == nil {
+			t.Errorf("NewWavetable(%v) should fail", cycle)
+		}
+	}
+}
+
+func TestWavetableShape(t *testing.T) {
+	table := Wavetable{0, 1, 0, -1}
+	tests := []struct {
+		cycle float64
+		want  float64
+	}{
+		{0, 0},
+		{0.125, 0.5},
+		{0.25, 1},
+		{0.625, -0.5},
+		// wrapping from the last sample back to the first
+		{0.875, -0.5},
+		{1.25, 1},
+		{-0.25, -1},
+	}
+
+	for _, tt := range tests {
+		if got := table.Shape(tt.cycle*τ, 0); math.Abs(got-tt.want) > 1e-12 {
+			t.Errorf("Shape at %g of the cycle = %v, want %v", tt.cycle, got, tt.want)
+		}
+	}
+}
+
+func TestTableMatchesTheWave(t *testing.T) {
+	// a finely sampled sine cycle plays back as the sine at any pitch
+	cycle := make([]float64, 2048)
+	for i := range cycle {
+		cycle[i] = math.Sin(τ * float64(i) / float64(len(cycle)))
+	}
+	table, err := NewWavetable(cycle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, frequency := range []float64{27.5, 261.63, 440, 3520} {
+		got, want := samples(NewTable(table, frequency), 4410), samples(NewSine(frequency), 4410)
+		for i := range want {
+			if math.Abs(got[i]-want[i]) > 1e-5 {
+				t.Fatalf("%g Hz: sample %d is %v, the sine is %v", frequency, i, got[i], want[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/tecnologer/SoundOfCode/synth"
+)
+
+// Temperament maps piano keys to frequencies
+type Temperament interface {
+	Frequency(key int) float64
+}
+
+// tuning is the temperament notes are played in, set by -scala
+var tuning Temperament = equalTemperament{}
+
+// equalTemperament is 12-tone equal temperament with A4 at 440Hz
+type equalTemperament struct{}
+
+func (equalTemperament) Frequency(key int) float64 {
+	return synth.KeyFrequency(key)
+}
+
+// scalaTuning is a scale loaded from a Scala file. Key synth.RefKey plays
+// synth.RefFrequency and every key above it moves one degree up, wrapping into
+// the next period (usually the octave) after the last degree
+type scalaTuning struct {
+	// ratios of every degree to the first one, starting with 1. The
+	// period is not included
+	ratios []float64
+	period float64
+}
+
+func (s *scalaTuning) Frequency(key int) float64 {
+	steps := key - synth.RefKey
+	n := len(s.ratios)
+	periods := int(math.Floor(float64(steps) / float64(n)))
+	degree := steps - periods*n
+	return synth.RefFrequency * math.Pow(s.period, float64(periods)) * s.ratios[degree]
+}
+
+// parseScala reads a Scala .scl file: a description line, the number of
+// pitches and then one pitch per line, either in cents (with a dot) or as
+// a ratio like 3/2. The last pitch is the period. Lines starting with !
+// are comments
+func parseScala(r io.Reader) (*scalaTuning, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "!") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(lines) < 2 {
+		return nil, errors.New("scala file is missing the pitch count")
+	}
+
+	count, err := strconv.Atoi(strings.Fields(lines[1] + " x")[0])
+	if err != nil || count < 1 {
+		return nil, fmt.Errorf("invalid scala pitch count %q", lines[1])
+	}
+
+	pitches := lines[2:]
+	if len(pitches) < count {
+		return nil, fmt.Errorf("scala file has %d pitches, expected %d", len(pitches), count)
+	}
+
+	ratios := []float64{1}
+	for _, line := range pitches[:count] {
+		ratio, err := scalaPitch(line)
+		if err != nil {
+			return nil, err
+		}
+		ratios = append(ratios, ratio)
+	}
+
+	period := ratios[len(ratios)-1]
+	if period <= 1 {
+		return nil, fmt.Errorf("invalid scala period %g", period)
+	}
+
+	return &scalaTuning{ratios: ratios[:len(ratios)-1], period: period}, nil
+}
+
+// scalaPitch parses one pitch line to a frequency ratio, anything after
+// the value is a comment
+func scalaPitch(line string) (float64, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return 0, errors.New("empty scala pitch")
+	}
+	value := fields[0]
+
+	if strings.Contains(value, ".") {
+		cents, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid scala pitch %q", value)
+		}
+		return math.Pow(2, cents/1200), nil
+	}
+
+	num, den := value, "1"
+	if i := strings.IndexByte(value, '/'); i >= 0 {
+		num, den = value[:i], value[i+1:]
+	}
+
+	n, err1 := strconv.ParseFloat(num, 64)
+	d, err2 := strconv.ParseFloat(den, 64)
+	if err1 != nil || err2 != nil || n <= 0 || d <= 0 {
+		return 0, fmt.Errorf("invalid scala pitch %q", value)
+	}
+	return n / d, nil
+}
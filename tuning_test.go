@@ -0,0 +1,66 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+// pentatonicScala is a Scala fixture mixing cents and ratios
+const pentatonicScala = `! slendro.scl
+!
+A five tone scale
+ 5
+!
+ 240.0
+ 4/3 ! a comment after a pitch
+ 720.
+ 960.000
+ 2/1
+`
+
+func TestParseScala(t *testing.T) {
+	scale, err := parseScala(strings.NewReader(pentatonicScala))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A4 is the first degree, every key up is the next degree
+	cents := func(c float64) float64 { return 440 * math.Pow(2, c/1200) }
+	tests := []struct {
+		key  int
+		want float64
+	}{
+		{49, 440},
+		{50, cents(240)},
+		{51, 440.0 * 4 / 3},
+		{52, cents(720)},
+		{53, cents(960)},
+		{54, 880},
+		{55, 2 * cents(240)},
+		{48, cents(960) / 2},
+		{44, 220},
+	}
+
+	for _, tt := range tests {
+		if got := scale.Frequency(tt.key); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("key %d: %.4fHz, want %.4fHz", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestParseScalaErrors(t *testing.T) {
+	tests := map[string]string{
+		"no count":       "description\n",
+		"bad count":      "description\nfive\n100.0\n",
+		"missing pitch":  "description\n3\n100.0\n2/1\n",
+		"bad pitch":      "description\n2\nabc\n2/1\n",
+		"period below 1": "description\n1\n1/2\n",
+	}
+
+	for name, scl := range tests {
+		if _, err := parseScala(strings.NewReader(scl)); err == nil {
+			t.Errorf("%s: should fail", name)
+		}
+	}
+}
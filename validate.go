@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tecnologer/SoundOfCode/synth"
+)
+
+// Validate returns every problem found in the song: keys outside the
+// piano, non-positive durations and notes too short to reach the
+// envelope sustain stage
+func Validate(song *Song, env synth.Envelope) []error {
+	var errs []error
+	for t, track := range song.Tracks {
+		for n, note := range track.Notes {
+			where := fmt.Sprintf("track %d, note %d", t+1, n+1)
+			if note.Key < 0 || note.Key > synth.TotalKeys {
+				errs = append(errs, fmt.Errorf("%s: key %d is out of the piano range", where, note.Key))
+			}
+
+			if note.Duration <= 0 {
+				errs = append(errs, fmt.Errorf("%s: duration %v is not positive", where, note.Duration))
+			} else if note.Key > 0 && note.Duration < env.Stages() {
+				errs = append(errs, fmt.Errorf("%s: duration %v is shorter than the envelope stages %v", where, note.Duration, env.Stages()))
+			}
+		}
+	}
+
+	return errs
+}
+
+// ValidateScore parses the score note by note so every malformed note is
+// reported, then validates the notes that could be parsed
+func ValidateScore(score string, bpm int, env synth.Envelope) []error {
+	var errs []error
+	song := &Song{}
+	for t, part := range strings.Split(score, "|") {
+		var track Track
+		for _, token := range strings.Fields(part) {
+			notes, err := ParseScore(token, bpm)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("track %d: %v", t+1, err))
+				continue
+			}
+			track.Notes = append(track.Notes, notes...)
+		}
+		song.Tracks = append(song.Tracks, track)
+	}
+
+	return append(errs, Validate(song, env)...)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tecnologer/SoundOfCode/synth"
+)
+
+func TestValidate(t *testing.T) {
+	env := synth.Envelope{Attack: 50 * time.Millisecond, Decay: 50 * time.Millisecond, Sustain: 0.5}
+	song := &Song{Tracks: []Track{
+		{Notes: []Note{
+			{Key: 40, Duration: time.Second},
+			{Key: 89, Duration: time.Second},
+			{Key: -1, Duration: time.Second},
+			{Key: 40, Duration: 0},
+		}},
+		{Notes: []Note{
+			{Key: 0, Duration: 10 * time.Millisecond},
+			{Key: 52, Duration: 20 * time.Millisecond},
+			{Key: 0, Duration: -time.Second},
+		}},
+	}}
+
+	// every problem, in order
+	want := []string{
+		"track 1, note 2: key 89 is out of the piano range",
+		"track 1, note 3: key -1 is out of the piano range",
+		"track 1, note 4: duration 0s is not positive",
+		"track 2, note 2: duration 20ms is shorter than the envelope stages 100ms",
+		"track 2, note 3: duration -1s is not positive",
+	}
+
+	errs := Validate(song, env)
+	if len(errs) != len(want) {
+		t.Fatalf("%d problems, want %d: %v", len(errs), len(want), errs)
+	}
+	for i, err := range errs {
+		if err.Error() != want[i] {
+			t.Errorf("problem %d: %q, want %q", i+1, err, want[i])
+		}
+	}
+
+	if errs := Validate(&Song{Tracks: []Track{{Notes: []Note{{Key: 40, Duration: time.Second}}}}}, env); len(errs) != 0 {
+		t.Errorf("a valid song reports %v", errs)
+	}
+}
+
+func TestValidateScore(t *testing.T) {
+	env := synth.Envelope{Attack: 200 * time.Millisecond}
+	errs := ValidateScore("C4:q X4:q E4:z G4:s | C9:q A4:h", 120, env)
+
+	// the notes that can't be parsed first, then the sixteenth too short
+	// for the attack
+	want := []string{"X4", "z", "C9", "shorter than the envelope"}
+	if len(errs) != len(want) {
+		t.Fatalf("%d problems, want %d: %v", len(errs), len(want), errs)
+	}
+	for i, err := range errs {
+		if !strings.Contains(err.Error(), want[i]) {
+			t.Errorf("problem %d: %q, want it to mention %q", i+1, err, want[i])
+		}
+	}
+}
+
+func TestValidateFlags(t *testing.T) {
+	tests := []struct {
+		flags map[string]string
+		// err is part of the error, empty when the flags are valid
+		err string
+	}{
+		{nil, ""},
+		{map[string]string{"instrument": "pluck", "effects": "delay,comb"}, ""},
+		{map[string]string{"instrument": "kazoo"}, `unknown instrument "kazoo"`},
+		{map[string]string{"effects": "delay,reverb"}, `unknown effect "reverb"`},
+		{map[string]string{"harmonic-profile": "1,x"}, `invalid harmonic amplitude "x"`},
+		{map[string]string{"wave": "organ"}, `unknown wave "organ"`},
+		{map[string]string{"wavetable": "missing.wav"}, "missing.wav"},
+		{map[string]string{"decorrelate": "1"}, ""},
+		{map[string]string{"decorrelate": "-0.1"}, "invalid decorrelation depth -0.1"},
+		{map[string]string{"decorrelate": "1.5"}, "invalid decorrelation depth 1.5"},
+		{map[string]string{"comb-feedback": "-0.99", "delay-feedback": "0.99"}, ""},
+		{map[string]string{"comb-feedback": "1"}, "invalid comb filter feedback 1"},
+		{map[string]string{"comb-feedback": "-1.2"}, "invalid comb filter feedback -1.2"},
+		{map[string]string{"delay-feedback": "1"}, "invalid delay feedback 1"},
+		{map[string]string{"delay-feedback": "-1"}, "invalid delay feedback -1"},
+		{map[string]string{"octaves": "3"}, ""},
+		{map[string]string{"octaves": "0"}, "invalid octave count 0"},
+		{map[string]string{"octaves": "-1"}, "invalid octave count -1"},
+	}
+
+	for _, tt := range tests {
+		previous := map[string]string{}
+		for name, value := range tt.flags {
+			previous[name] = flag.Lookup(name).Value.String()
+			if err := flag.Set(name, value); err != nil {
+				t.Fatal(err)
+			}
+		}
+		err := validateFlags()
+		for name, value := range previous {
+			flag.Set(name, value)
+		}
+
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%v: %v", tt.flags, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%v: error %v, want one about %s", tt.flags, err, tt.err)
+		}
+	}
+}
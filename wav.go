@@ -0,0 +1,367 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+)
+
+const (
+	wavFormatPCM   = 1
+	wavFormatFloat = 3
+)
+
+// wavHeader is the format chunk of a WAV file plus the size of its data
+type wavHeader struct {
+	AudioFormat   int
+	Channels      int
+	SampleRate    int
+	BitsPerSample int
+	// DataSize is the length in bytes of the data chunk
+	DataSize int
+}
+
+// Frames is the number of sample frames in the data chunk
+func (h *wavHeader) Frames() int {
+	return h.DataSize / (h.Channels * h.BitsPerSample / 8)
+}
+
+// readWAVHeader reads the RIFF header and chunks up to the start of the
+// data chunk, leaving r positioned at the first sample
+func readWAVHeader(r io.Reader) (*wavHeader, error) {
+	var riff [12]byte
+	if _, err := io.ReadFull(r, riff[:]); err != nil {
+		return nil, err
+	}
+
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return nil, errors.New("not a RIFF/WAVE file")
+	}
+
+	var header *wavHeader
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			return nil, fmt.Errorf("missing data chunk: %v", err)
+		}
+
+		id := string(chunk[0:4])
+		size := int(binary.LittleEndian.Uint32(chunk[4:8]))
+
+		switch id {
+		case "fmt ":
+			body := make([]byte, size+size%2)
+			if _, err := io.ReadFull(r, body); err != nil {
+				return nil, err
+			}
+
+			if size < 16 {
+				return nil, errors.New("format chunk too short")
+			}
+
+			header = &wavHeader{
+				AudioFormat:   int(binary.LittleEndian.Uint16(body[0:2])),
+				Channels:      int(binary.LittleEndian.Uint16(body[2:4])),
+				SampleRate:    int(binary.LittleEndian.Uint32(body[4:8])),
+				BitsPerSample: int(binary.LittleEndian.Uint16(body[14:16])),
+			}
+
+			// WAVE_FORMAT_EXTENSIBLE keeps the real format in the sub format
+			if header.AudioFormat == 0xFFFE && size >= 26 {
+				header.AudioFormat = int(binary.LittleEndian.Uint16(body[24:26]))
+			}
+		case "data":
+			if header == nil {
+				return nil, errors.New("data chunk before format chunk")
+			}
+
+			header.DataSize = size
+			return header, nil
+		default:
+			if _, err := io.CopyN(io.Discard, r, int64(size+size%2)); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// printWAVInfo prints the format and length of a WAV file from its header
+// alone, without reading the samples
+func printWAVInfo(w io.Writer, h *wavHeader) {
+	codec := fmt.Sprintf("format %d", h.AudioFormat)
+	switch h.AudioFormat {
+	case wavFormatPCM:
+		codec = "PCM"
+	case wavFormatFloat:
+		codec = "IEEE float"
+	}
+
+	fmt.Fprintf(w, "codec:       %s\n", codec)
+	fmt.Fprintf(w, "channels:    %d\n", h.Channels)
+	fmt.Fprintf(w, "sample rate: %d Hz\n", h.SampleRate)
+	fmt.Fprintf(w, "bits:        %d\n", h.BitsPerSample)
+	fmt.Fprintf(w, "data size:   %d bytes\n", h.DataSize)
+	if h.Channels > 0 && h.BitsPerSample >= 8 && h.SampleRate > 0 {
+		fmt.Fprintf(w, "duration:    %.3f s\n", float64(h.Frames())/float64(h.SampleRate))
+	}
+}
+
+// readWAV decodes a whole WAV file into interleaved samples in [-1, 1]
+func readWAV(r io.Reader) (samples []float64, header *wavHeader, err error) {
+	header, err = readWAVHeader(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if header.Channels < 1 || header.BitsPerSample == 0 {
+		return nil, nil, errors.New("invalid format chunk")
+	}
+
+	data := make([]byte, header.DataSize)
+	n, err := io.ReadFull(r, data)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, nil, err
+	}
+	data = data[:n]
+
+	size := header.BitsPerSample / 8
+	samples = make([]float64, 0, len(data)/size)
+	for i := 0; i+size <= len(data); i += size {
+		b := data[i : i+size]
+		switch {
+		case header.AudioFormat == wavFormatFloat && size == 4:
+			samples = append(samples, float64(math.Float32frombits(binary.LittleEndian.Uint32(b))))
+		case header.AudioFormat == wavFormatFloat && size == 8:
+			samples = append(samples, math.Float64frombits(binary.LittleEndian.Uint64(b)))
+		case header.AudioFormat == wavFormatPCM && size == 1:
+			samples = append(samples, (float64(b[0])-128)/128)
+		case header.AudioFormat == wavFormatPCM && size == 2:
+			samples = append(samples, float64(int16(binary.LittleEndian.Uint16(b)))/32768)
+		case header.AudioFormat == wavFormatPCM && size == 3:
+			v := int32(b[0])<<8 | int32(b[1])<<16 | int32(b[2])<<24
+			samples = append(samples, float64(v>>8)/(1<<23))
+		case header.AudioFormat == wavFormatPCM && size == 4:
+			samples = append(samples, float64(int32(binary.LittleEndian.Uint32(b)))/(1<<31))
+		default:
+			return nil, nil, fmt.Errorf("unsupported WAV format %d with %d bits", header.AudioFormat, header.BitsPerSample)
+		}
+	}
+
+	return samples, header, nil
+}
+
+// downmix averages interleaved channels into a mono signal
+func downmix(samples []float64, channels int) []float64 {
+	if channels <= 1 {
+		return samples
+	}
+
+	mono := make([]float64, len(samples)/channels)
+	for i := range mono {
+		for ch := 0; ch < channels; ch++ {
+			mono[i] += samples[i*channels+ch]
+		}
+		mono[i] /= float64(channels)
+	}
+
+	return mono
+}
+
+// writeWAV writes interleaved samples as a WAV file, 8, 16 or 24-bit PCM
+// or 32-bit float. Everything up to here stays in float64, the integer
+// formats are quantized only now, with dither, and clipped the clip way
+func writeWAV(w io.Writer, samples []float64, channels, sampleRate, bitsPerSample int, clip string) error {
+	ww, err := newWAVWriter(w, channels, sampleRate, bitsPerSample, clip, len(samples)/channels)
+	if err != nil {
+		return err
+	}
+
+	if err := ww.Write(samples); err != nil {
+		return err
+	}
+	return ww.Close()
+}
+
+// wavBlock is how many samples pcmWriter encodes at a time
+const wavBlock = 1 << 16
+
+// wavWriter streams interleaved samples into a WAV file as they are
+// produced, so a render never has to be held in memory as a whole
+type wavWriter struct {
+	*pcmWriter
+	declared int
+}
+
+// newWAVWriter writes the header for frames sample frames. With frames
+// below 0 the length is unknown: the sizes are left at 0 and patched by
+// Close, which needs w to be an io.WriteSeeker
+func newWAVWriter(w io.Writer, channels, sampleRate, bitsPerSample int, clip string, frames int) (*wavWriter, error) {
+	if !clipModes[clip] {
+		return nil, fmt.Errorf("unknown clip mode %q, use clamp, soft or wrap", clip)
+	}
+
+	format := wavFormatPCM
+	switch bitsPerSample {
+	case 8, 16, 24:
+	case 32:
+		format = wavFormatFloat
+	default:
+		return nil, fmt.Errorf("unsupported bits per sample %d", bitsPerSample)
+	}
+
+	if _, ok := w.(io.WriteSeeker); frames < 0 && !ok {
+		return nil, errors.New("streaming a WAV of unknown length needs a seekable output")
+	}
+
+	size := bitsPerSample / 8
+	dataSize := 0
+	if frames > 0 {
+		dataSize = frames * channels * size
+	}
+
+	header := make([]byte, 44)
+	copy(header[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:8], uint32(36+dataSize))
+	copy(header[8:12], "WAVE")
+	copy(header[12:16], "fmt ")
+	binary.LittleEndian.PutUint32(header[16:20], 16)
+	binary.LittleEndian.PutUint16(header[20:22], uint16(format))
+	binary.LittleEndian.PutUint16(header[22:24], uint16(channels))
+	binary.LittleEndian.PutUint32(header[24:28], uint32(sampleRate))
+	binary.LittleEndian.PutUint32(header[28:32], uint32(sampleRate*channels*size))
+	binary.LittleEndian.PutUint16(header[32:34], uint16(channels*size))
+	binary.LittleEndian.PutUint16(header[34:36], uint16(bitsPerSample))
+	copy(header[36:40], "data")
+	binary.LittleEndian.PutUint32(header[40:44], uint32(dataSize))
+
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &wavWriter{pcmWriter: newPCMWriter(w, bitsPerSample, clip), declared: dataSize}, nil
+}
+
+// pcmWriter encodes interleaved samples as little endian PCM with no
+// header: unsigned 8-bit, signed 16 or 24-bit or 32-bit float
+type pcmWriter struct {
+	w       io.Writer
+	size    int
+	q       *quantizer
+	written int
+	buf     []byte
+}
+
+// newPCMWriter returns a writer of bitsPerSample samples, quantized with
+// dither and clipped the clip way
+func newPCMWriter(w io.Writer, bitsPerSample int, clip string) *pcmWriter {
+	size := bitsPerSample / 8
+	return &pcmWriter{
+		w:    w,
+		size: size,
+		q:    newQuantizer(bitsPerSample, clip),
+		buf:  make([]byte, wavBlock*size),
+	}
+}
+
+// Write encodes and writes the interleaved samples
+func (pw *pcmWriter) Write(samples []float64) error {
+	for len(samples) > 0 {
+		n := len(samples)
+		if n > wavBlock {
+			n = wavBlock
+		}
+
+		for i, s := range samples[:n] {
+			b := pw.buf[i*pw.size:]
+			switch pw.size {
+			case 1:
+				// 8-bit WAV is unsigned
+				b[0] = byte(pw.q.Quantize(s) + 128)
+			case 2:
+				binary.LittleEndian.PutUint16(b, uint16(int16(pw.q.Quantize(s))))
+			case 3:
+				v := pw.q.Quantize(s)
+				b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+			case 4:
+				binary.LittleEndian.PutUint32(b, math.Float32bits(float32(s)))
+			}
+		}
+
+		if _, err := pw.w.Write(pw.buf[:n*pw.size]); err != nil {
+			return err
+		}
+		pw.written += n * pw.size
+		samples = samples[n:]
+	}
+	return nil
+}
+
+// Close patches the RIFF and data sizes in the header when they differ
+// from the declared ones and leaves w at the end of the file
+func (ww *wavWriter) Close() error {
+	if ww.written == ww.declared {
+		return nil
+	}
+
+	ws, ok := ww.w.(io.WriteSeeker)
+	if !ok {
+		return fmt.Errorf("wrote %d bytes of WAV data, the header says %d", ww.written, ww.declared)
+	}
+
+	var size [4]byte
+	for _, patch := range []struct{ offset, value int }{{4, 36 + ww.written}, {40, ww.written}} {
+		if _, err := ws.Seek(int64(patch.offset), io.SeekStart); err != nil {
+			return err
+		}
+		binary.LittleEndian.PutUint32(size[:], uint32(patch.value))
+		if _, err := ws.Write(size[:]); err != nil {
+			return err
+		}
+	}
+
+	_, err := ws.Seek(0, io.SeekEnd)
+	return err
+}
+
+// clipModes are the ways samples beyond full scale are brought into the
+// integer range: clamp pins them to the limits, soft bends everything
+// through tanh first and wrap lets them overflow like a plain integer
+// conversion would
+var clipModes = map[string]bool{"clamp": true, "soft": true, "wrap": true}
+
+// quantizer converts float samples to signed integers of some bit depth,
+// adding triangular (TPDF) dither of one step so the rounding error turns
+// into a constant noise floor instead of distortion
+type quantizer struct {
+	max  float64
+	clip string
+	rng  *rand.Rand
+}
+
+// newQuantizer returns a quantizer for the bit depth and clip mode. The
+// dither is seeded so the same song always encodes to the same bytes
+func newQuantizer(bits int, clip string) *quantizer {
+	return &quantizer{
+		max:  float64(int(1)<<(bits-1)) - 1,
+		clip: clip,
+		rng:  rand.New(rand.NewSource(1)),
+	}
+}
+
+// Quantize returns the sample scaled to the integer range
+func (q *quantizer) Quantize(s float64) int {
+	if q.clip == "soft" {
+		s = math.Tanh(s)
+	}
+
+	dither := q.rng.Float64() - q.rng.Float64()
+	v := math.Round(s*q.max + dither)
+	if q.clip == "wrap" {
+		span := 2 * (q.max + 1)
+		v = v - span*math.Floor((v+q.max+1)/span)
+	}
+	return int(math.Max(-q.max-1, math.Min(q.max, v)))
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"math"
+	"testing"
+)
+
+func TestQuantize(t *testing.T) {
+	tests := []struct {
+		bits     int
+		clip     string
+		sample   float64
+		min, max int
+	}{
+		// the dither moves the rounding by up to a step either way
+		{16, "clamp", 0, -1, 1},
+		{16, "clamp", 0.5, 16382, 16385},
+		{16, "clamp", -1, -32768, -32766},
+		{16, "clamp", 2, 32767, 32767},
+		{16, "clamp", -2, -32768, -32768},
+		{8, "clamp", 0.5, 62, 65},
+		{24, "clamp", 0.25, 2097150, 2097153},
+		{16, "soft", 2, 31587, 31590},
+		{16, "wrap", 1.5, -16386, -16382},
+	}
+
+	for _, tt := range tests {
+		q := newQuantizer(tt.bits, tt.clip)
+		for i := 0; i < 100; i++ {
+			if v := q.Quantize(tt.sample); v < tt.min || v > tt.max {
+				t.Errorf("%d-bit %s: %v quantized to %d, want %d to %d", tt.bits, tt.clip, tt.sample, v, tt.min, tt.max)
+				break
+			}
+		}
+	}
+}
+
+func TestQuantizeDither(t *testing.T) {
+	// a quarter of a step would round to 0 every time, the dither keeps it
+	// on average
+	q := newQuantizer(16, "clamp")
+	sum := 0
+	const n = 100000
+	for i := 0; i < n; i++ {
+		sum += q.Quantize(0.25 / 32767)
+	}
+	if mean := float64(sum) / n; math.Abs(mean-0.25) > 0.02 {
+		t.Errorf("a quarter step averages %.3f steps", mean)
+	}
+}
+
+func TestWAVs16IsTheQuantizedFloat(t *testing.T) {
+	samples := noise(5000, 7)
+	for i := range samples {
+		samples[i] *= 1.2
+	}
+
+	var buf bytes.Buffer
+	if err := writeWAV(&buf, samples, 2, SampleRate, 16, "clamp"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the data is the float pipeline quantized once at the end
+	data := buf.Bytes()[44:]
+	q := newQuantizer(16, "clamp")
+	for i, s := range samples {
+		got := int(int16(binary.LittleEndian.Uint16(data[2*i:])))
+		if want := q.Quantize(s); got != want {
+			t.Fatalf("sample %d: %d, want %d", i, got, want)
+		}
+		if math.Abs(float64(got)-math.Max(-32768, math.Min(32767, s*32767))) > 1.5 {
+			t.Fatalf("sample %d: %v written as %d", i, s, got)
+		}
+	}
+}
+
+// wavFixture builds a WAV file by hand: an extensible format chunk with
+// the real format in its sub format, an odd sized LIST chunk and the data
+func wavFixture(format, channels, sampleRate, bits, dataSize int) []byte {
+	var b bytes.Buffer
+	le := binary.LittleEndian
+	b.WriteString("RIFF")
+	binary.Write(&b, le, uint32(0))
+	b.WriteString("WAVE")
+
+	b.WriteString("fmt ")
+	binary.Write(&b, le, uint32(40))
+	binary.Write(&b, le, uint16(0xFFFE))
+	binary.Write(&b, le, uint16(channels))
+	binary.Write(&b, le, uint32(sampleRate))
+	binary.Write(&b, le, uint32(sampleRate*channels*bits/8))
+	binary.Write(&b, le, uint16(channels*bits/8))
+	binary.Write(&b, le, uint16(bits))
+	binary.Write(&b, le, uint16(22))
+	binary.Write(&b, le, uint16(bits))
+	binary.Write(&b, le, uint32(0))
+	binary.Write(&b, le, uint16(format))
+	b.Write(make([]byte, 14))
+
+	b.WriteString("LIST")
+	binary.Write(&b, le, uint32(5))
+	b.WriteString("INFOx\x00")
+
+	b.WriteString("data")
+	binary.Write(&b, le, uint32(dataSize))
+	b.Write(make([]byte, dataSize))
+	return b.Bytes()
+}
+
+func TestPrintWAVInfo(t *testing.T) {
+	tests := []struct {
+		fixture []byte
+		want    string
+	}{
+		{
+			wavFixture(wavFormatPCM, 2, 48000, 24, 48000*2*3*3/2),
+			"codec:       PCM\nchannels:    2\nsample rate: 48000 Hz\nbits:        24\ndata size:   432000 bytes\nduration:    1.500 s\n",
+		},
+		{
+			wavFixture(wavFormatFloat, 1, 44100, 32, 4*4410),
+			"codec:       IEEE float\nchannels:    1\nsample rate: 44100 Hz\nbits:        32\ndata size:   17640 bytes\nduration:    0.100 s\n",
+		},
+		{
+			wavFixture(2, 1, 8000, 4, 0),
+			"codec:       format 2\nchannels:    1\nsample rate: 8000 Hz\nbits:        4\ndata size:   0 bytes\n",
+		},
+	}
+
+	for _, tt := range tests {
+		h, err := readWAVHeader(bytes.NewReader(tt.fixture))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		printWAVInfo(&buf, h)
+		if buf.String() != tt.want {
+			t.Errorf("printed:\n%s\nwant:\n%s", buf.String(), tt.want)
+		}
+	}
+}
+
+func TestReadWAVHeaderErrors(t *testing.T) {
+	fixture := wavFixture(wavFormatPCM, 1, 44100, 16, 0)
+	tests := map[string][]byte{
+		"empty":         nil,
+		"not RIFF":      append([]byte("RIFX"), fixture[4:]...),
+		"no data chunk": fixture[:len(fixture)-8],
+		"data first":    append(append([]byte(nil), fixture[:12]...), []byte("data\x00\x00\x00\x00")...),
+	}
+
+	for name, b := range tests {
+		if _, err := readWAVHeader(bytes.NewReader(b)); err == nil {
+			t.Errorf("%s: should fail", name)
+		}
+	}
+}
+
+// pcmValues decodes the data of a WAV with no extra chunks as signed
+// integers
+func pcmValues(wav []byte, bits int) []int {
+	data := wav[44:]
+	size := bits / 8
+	values := make([]int, len(data)/size)
+	for i := range values {
+		b := data[i*size:]
+		switch size {
+		case 1:
+			values[i] = int(b[0]) - 128
+		case 2:
+			values[i] = int(int16(binary.LittleEndian.Uint16(b)))
+		case 3:
+			values[i] = int(int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24) >> 8)
+		}
+	}
+	return values
+}
+
+func TestWAVClip(t *testing.T) {
+	tests := []struct {
+		bits     int
+		clip     string
+		sample   float64
+		min, max int
+	}{
+		{8, "clamp", 1.5, 127, 127},
+		{8, "clamp", -1.5, -128, -128},
+		{16, "clamp", 3, 32767, 32767},
+		{16, "clamp", -3, -32768, -32768},
+		{16, "clamp", 1.0001, 32767, 32767},
+		{24, "clamp", 1.5, 8388607, 8388607},
+		{24, "clamp", -1.5, -8388608, -8388608},
+		// wrap overflows like int16(x) would
+		{16, "wrap", 1.5, -16386, -16382},
+		{16, "wrap", -1.5, 16382, 16386},
+		{8, "wrap", 1.5, -66, -62},
+		// soft bends the overs back under full scale
+		{16, "soft", 3, 32604, 32606},
+		{16, "soft", -3, -32606, -32604},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := writeWAV(&buf, []float64{tt.sample}, 1, SampleRate, tt.bits, tt.clip); err != nil {
+			t.Fatal(err)
+		}
+		if v := pcmValues(buf.Bytes(), tt.bits)[0]; v < tt.min || v > tt.max {
+			t.Errorf("%d-bit %s: %v written as %d, want %d to %d", tt.bits, tt.clip, tt.sample, v, tt.min, tt.max)
+		}
+	}
+}
+
+func TestWAVClipUnknown(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeWAV(&buf, []float64{2}, 1, SampleRate, 16, "fold"); err == nil {
+		t.Error("writeWAV with clip fold should fail")
+	}
+}
+
+func TestWAVRoundTrip(t *testing.T) {
+	tests := []struct {
+		format string
+		// step is how far a sample read back can be from the one written,
+		// two 16-bit steps as it is written at 32767 and read at 32768
+		step float64
+	}{
+		{"s16", 2.0 / 32767},
+		{"f32", 1e-7},
+	}
+
+	samples := []float64{0, 0.5, -0.5, 0.999, -1, 0.123456, -0.0001, 0.25}
+	for _, tt := range tests {
+		for _, channels := range []int{1, 2} {
+			var buf bytes.Buffer
+			enc, err := encoderFor("song.wav", tt.format, "clamp")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := enc.Encode(&buf, samples, channels, 48000); err != nil {
+				t.Fatal(err)
+			}
+
+			got, h, err := readWAV(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if h.Channels != channels || h.SampleRate != 48000 || h.BitsPerSample != sampleFormats[tt.format] || h.Frames() != len(samples)/channels {
+				t.Errorf("%s, %d channels: header %+v", tt.format, channels, *h)
+			}
+			if len(got) != len(samples) {
+				t.Errorf("%s, %d channels: read %d samples, wrote %d", tt.format, channels, len(got), len(samples))
+				continue
+			}
+			for i := range samples {
+				if math.Abs(got[i]-samples[i]) > tt.step {
+					t.Errorf("%s, %d channels: sample %d read back as %v, wrote %v", tt.format, channels, i, got[i], samples[i])
+				}
+			}
+		}
+	}
+}
+
+func TestOutShorthand(t *testing.T) {
+	// -o and -out are the same setting
+	setFlag(t, "out", "out.bin")
+	setFlag(t, "o", "song.wav")
+	if *outFile != "song.wav" {
+		t.Errorf("-o song.wav left -out at %q", *outFile)
+	}
+	if got := flag.Lookup("out").Value.String(); got != "song.wav" {
+		t.Errorf("-out reads %q after -o song.wav", got)
+	}
+}
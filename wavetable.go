@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/tecnologer/SoundOfCode/synth"
+)
+
+// wavetables are the tables loaded so far by path, every track looks its
+// table up again so -ab overrides of -wavetable take effect
+var wavetables = map[string]synth.Wavetable{}
+
+// waveShape returns the oscillator waveform set by -wavetable or else by
+// -wave, nil keeping the sine
+func waveShape() (synth.Shape, error) {
+	if *wavetableFile != "" {
+		table, ok := wavetables[*wavetableFile]
+		if !ok {
+			var err error
+			table, err = loadWavetable(*wavetableFile)
+			if err != nil {
+				return nil, err
+			}
+			wavetables[*wavetableFile] = table
+		}
+		return table.Shape, nil
+	}
+
+	shape, ok := synth.Shapes[*waveName]
+	if !ok {
+		return nil, fmt.Errorf("unknown wave %q, use sine, square, triangle, saw, white or pink", *waveName)
+	}
+	if *waveName == "sine" {
+		return nil, nil
+	}
+	return shape, nil
+}
+
+// loadWavetable reads a single cycle waveform from a WAV file, mixed down
+// to mono, or from a text file of sample values separated by commas or
+// new lines
+func loadWavetable(path string) (synth.Wavetable, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var cycle []float64
+	if strings.ToLower(filepath.Ext(path)) == ".wav" {
+		samples, header, err := readWAV(f)
+		if err != nil {
+			return nil, err
+		}
+		cycle = downmix(samples, header.Channels)
+	} else {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			for _, field := range strings.Split(scanner.Text(), ",") {
+				field = strings.TrimSpace(field)
+				if field == "" {
+					continue
+				}
+
+				s, err := strconv.ParseFloat(field, 64)
+				if err != nil {
+					return nil, fmt.Errorf("%s: invalid sample %q", path, field)
+				}
+				cycle = append(cycle, s)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	table, err := synth.NewWavetable(cycle)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return table, nil
+}
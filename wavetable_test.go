@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadWavetable(t *testing.T) {
+	var wav bytes.Buffer
+	// a stereo cycle, mixed down to mono
+	if err := writeWAV(&wav, []float64{0, 0, 0.5, 0.5, 0, 0, -0.5, -0.5}, 2, SampleRate, 16, "clamp"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		data string
+		want []float64
+	}{
+		{"commas.txt", "0, 0.5, 0, -0.5", []float64{0, 1, 0, -1}},
+		{"lines.csv", "0\n0.25\n\n0\n-0.5\n", []float64{0, 0.5, 0, -1}},
+		{"both.csv", "0,1\n0,-1,\n", []float64{0, 1, 0, -1}},
+		{"cycle.wav", wav.String(), []float64{0, 1, 0, -1}},
+	}
+
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), tt.name)
+		if err := os.WriteFile(path, []byte(tt.data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		table, err := loadWavetable(path)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if len(table) != len(tt.want) {
+			t.Errorf("%s: table %v, want %v", tt.name, table, tt.want)
+			continue
+		}
+		for i := range tt.want {
+			if math.Abs(table[i]-tt.want[i]) > 1e-4 {
+				t.Errorf("%s: table %v, want %v", tt.name, table, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestLoadWavetableErrors(t *testing.T) {
+	tests := []struct {
+		data string
+		err  string
+	}{
+		{"0, 0.5, x", `invalid sample "x"`},
+		{"0.5", "at least 2 samples"},
+		{"0,0,0", "silent"},
+	}
+
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "table.txt")
+		if err := os.WriteFile(path, []byte(tt.data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadWavetable(path); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%q: error %v, want one about %q", tt.data, err, tt.err)
+		}
+	}
+
+	if _, err := loadWavetable(filepath.Join(t.TempDir(), "missing.csv")); err == nil {
+		t.Error("loading a missing table should fail")
+	}
+}
+
+func TestWavetableRender(t *testing.T) {
+	// a square cycle played from -wavetable renders like -wave square
+	path := filepath.Join(t.TempDir(), "square.txt")
+	if err := os.WriteFile(path, []byte("1,1,1,1,-1,-1,-1,-1"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	out := renderWith(t, "wavetable="+path, "A4:w")[0]
+	if got := crest(out[22050:66150]); got > 1.2 {
+		t.Errorf("crest factor %.3f, want the square's flat top near 1", got)
+	}
+}